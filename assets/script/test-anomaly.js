@@ -27,11 +27,17 @@ export default function (data) {
     const url = `${data.baseUrl}/anomaly-detection`;
     const formData1 = new FormData();
     formData1.append('file', http.file(fileData, 'measurements.txt', 'application/txt'));
-    const res = http.post(url, formData1.body(), {
+    const params = {
         headers: {
             'Content-Type': 'multipart/form-data; boundary=' + formData1.boundary,
         },
-    });
+    };
+    // A saturated server answers 429; wait as long as it asks before trying again
+    let res = http.post(url, formData1.body(), params);
+    for (let retries = 0; res.status === 429 && retries < 5; retries++) {
+        sleep(Number(res.headers['Retry-After']) || 1);
+        res = http.post(url, formData1.body(), params);
+    }
 
     check(res, {
         'status is 200': (r) => r.status === 200,
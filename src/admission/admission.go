@@ -0,0 +1,146 @@
+// Package admission bounds the work the server takes on at once. A Controller admits a
+// limited number of runs and queues the next ones for a while; a Pool caps the decode
+// goroutines of all admitted runs together, so concurrent runs share the CPUs instead of
+// each starting one goroutine per core.
+package admission
+
+import (
+	"1brc-challange/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSaturated matches the errors of runs that were turned away.
+var ErrSaturated = errors.New("server is saturated")
+
+var (
+	// ErrQueueFull is returned when the queue holds as many runs as it may.
+	ErrQueueFull = fmt.Errorf("%w: run queue is full", ErrSaturated)
+	// ErrQueueTimeout is returned when a run waited in the queue for too long.
+	ErrQueueTimeout = fmt.Errorf("%w: timed out waiting for a run slot", ErrSaturated)
+)
+
+// Reasons label metrics.Rejected.
+const (
+	ReasonQueueFull = "queue_full"
+	ReasonTimeout   = "timeout"
+)
+
+// Config sets the limits of a Controller.
+type Config struct {
+	MaxRuns      int           // runs processed at once
+	MaxQueue     int           // runs waiting for a slot, 0 turns runs away as soon as all slots are taken
+	QueueTimeout time.Duration // time a run may wait for a slot
+	PoolSize     int           // decode goroutines shared by all runs, 0 leaves them unbounded
+}
+
+// Controller admits runs. A nil Controller admits every run at once.
+type Controller struct {
+	cfg     Config
+	slots   chan struct{}
+	pool    *Pool
+	running atomic.Int64
+	queued  atomic.Int64
+}
+
+// New returns a controller for cfg, or nil when cfg.MaxRuns is not positive.
+func New(cfg Config) *Controller {
+	if cfg.MaxRuns <= 0 {
+		return nil
+	}
+	return &Controller{
+		slots: make(chan struct{}, cfg.MaxRuns),
+		cfg:   cfg,
+		pool:  NewPool(cfg.PoolSize),
+	}
+}
+
+// Admit waits for a run slot and returns the func that gives it back. It fails with
+// ErrQueueFull right away when the queue is full, with ErrQueueTimeout when no slot was
+// freed in time, and with the error of ctx when the caller gives up first.
+func (c *Controller) Admit(ctx context.Context) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return c.admitted(), nil
+	default:
+	}
+
+	if c.queued.Add(1) > int64(c.cfg.MaxQueue) {
+		c.queued.Add(-1)
+		metrics.Rejected.WithLabelValues(ReasonQueueFull).Inc()
+		return nil, ErrQueueFull
+	}
+	metrics.QueueDepth.Inc()
+	start := time.Now()
+	defer func() {
+		c.queued.Add(-1)
+		metrics.QueueDepth.Dec()
+		metrics.QueueWait.Observe(time.Since(start).Seconds())
+	}()
+
+	timer := time.NewTimer(c.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return c.admitted(), nil
+	case <-timer.C:
+		metrics.Rejected.WithLabelValues(ReasonTimeout).Inc()
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// admitted counts a run that took a slot and returns the func that frees it once.
+func (c *Controller) admitted() func() {
+	c.running.Add(1)
+	metrics.AdmittedRuns.Inc()
+	var once atomic.Bool
+	return func() {
+		if once.CompareAndSwap(false, true) {
+			c.running.Add(-1)
+			metrics.AdmittedRuns.Dec()
+			<-c.slots
+		}
+	}
+}
+
+// RetryAfter is the time a turned away client should wait before trying again: by then
+// every run now queued has either started or given up.
+func (c *Controller) RetryAfter() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return max(c.cfg.QueueTimeout.Round(time.Second), time.Second)
+}
+
+// Load returns the runs processed and queued.
+func (c *Controller) Load() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.running.Load() + c.queued.Load()
+}
+
+// Capacity returns the runs the controller holds before it turns runs away, 0 for a nil
+// Controller.
+func (c *Controller) Capacity() int64 {
+	if c == nil {
+		return 0
+	}
+	return int64(c.cfg.MaxRuns + c.cfg.MaxQueue)
+}
+
+// Pool returns the decode pool shared by the admitted runs.
+func (c *Controller) Pool() *Pool {
+	if c == nil {
+		return nil
+	}
+	return c.pool
+}
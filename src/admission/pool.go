@@ -0,0 +1,97 @@
+package admission
+
+import (
+	"1brc-challange/metrics"
+	"context"
+	"sync"
+)
+
+// Pool bounds the goroutines that decode at once across all runs. A nil Pool starts
+// every task right away.
+type Pool struct {
+	tokens chan struct{}
+	// reserving lets one Reserve at a time collect its goroutines
+	reserving sync.Mutex
+}
+
+// NewPool returns a pool of size goroutines, or nil when size is not positive.
+func NewPool(size int) *Pool {
+	if size <= 0 {
+		return nil
+	}
+	return &Pool{tokens: make(chan struct{}, size)}
+}
+
+// Go runs fn in a goroutine once one of the pool is free. It returns the error of ctx
+// without running fn when ctx ends first.
+func (p *Pool) Go(ctx context.Context, fn func()) error {
+	if p == nil {
+		go fn()
+		return nil
+	}
+	select {
+	case p.tokens <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	metrics.PoolBusy.Inc()
+	go func() {
+		defer func() {
+			metrics.PoolBusy.Dec()
+			<-p.tokens
+		}()
+		fn()
+	}()
+	return nil
+}
+
+// Reserve takes n goroutines of the pool, at most its size, for tasks that only make
+// progress together, such as anomaly detectors fed by one reader. Reservations are taken
+// one at a time, so two runs never hold part of the pool each while waiting for the rest.
+// It returns the func that gives the goroutines back, or the error of ctx when it ends first.
+func (p *Pool) Reserve(ctx context.Context, n int) (release func(), err error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	n = min(n, cap(p.tokens))
+	p.reserving.Lock()
+	defer p.reserving.Unlock()
+	taken := 0
+	release = func() {
+		metrics.PoolBusy.Sub(float64(taken))
+		for ; taken > 0; taken-- {
+			<-p.tokens
+		}
+	}
+	for ; taken < n; taken++ {
+		select {
+		case p.tokens <- struct{}{}:
+			metrics.PoolBusy.Inc()
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// Size returns the goroutines of the pool, 0 for a nil Pool.
+func (p *Pool) Size() int {
+	if p == nil {
+		return 0
+	}
+	return cap(p.tokens)
+}
+
+type poolKey struct{}
+
+// WithPool returns ctx for a run whose decode goroutines come from p.
+func WithPool(ctx context.Context, p *Pool) context.Context {
+	return context.WithValue(ctx, poolKey{}, p)
+}
+
+// PoolFrom returns the pool of the run of ctx, nil when it has none.
+func PoolFrom(ctx context.Context) *Pool {
+	p, _ := ctx.Value(poolKey{}).(*Pool)
+	return p
+}
@@ -0,0 +1,4 @@
+// Package brcpb holds the protobuf messages and gRPC stubs generated from brc.proto.
+package brcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative brc.proto
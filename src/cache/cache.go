@@ -0,0 +1,304 @@
+// Package cache keeps merged aggregation results keyed by the content hash of the
+// upload and the options it was processed with, so identical uploads are not decoded twice.
+package cache
+
+import (
+	"1brc-challange/models"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyVersion is bumped whenever the cached representation or the key layout changes.
+const keyVersion = "v1"
+
+// Config limits the size and lifetime of cached results.
+type Config struct {
+	MaxEntries   int           // results kept in memory, 0 disables the memory tier
+	TTL          time.Duration // 0 keeps results until they are evicted
+	Dir          string        // directory of the disk tier, "" disables it
+	MaxDiskBytes int64         // total size of the disk tier, 0 means unlimited
+}
+
+// Enabled reports whether any tier is configured.
+func (c Config) Enabled() bool {
+	return c.MaxEntries > 0 || c.Dir != ""
+}
+
+// ResultCache is an LRU of merged results in memory, backed by JSON files on disk.
+// Results handed out by Get are shared and must not be modified.
+type ResultCache struct {
+	cfg   Config
+	mu    sync.Mutex
+	lru   *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	stats   map[string]*models.TempStat
+	created time.Time
+}
+
+// New creates a result cache. It returns nil when cfg enables no tier; a nil cache never hits.
+func New(cfg Config) (*ResultCache, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("cache directory: %w", err)
+		}
+	}
+	return &ResultCache{
+		cfg:   cfg,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}, nil
+}
+
+// Ping checks that the disk tier, when there is one, can still be written.
+func (c *ResultCache) Ping() error {
+	if c == nil || c.cfg.Dir == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(c.cfg.Dir, "ping.*.tmp")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Key combines the content hash of an upload with the options that affect its merged result.
+// The options must already be prepared so that auto-detected formats and named columns are resolved.
+func Key(contentHash string, opts models.ProcessOptions) string {
+	loc := "UTC"
+	if opts.Time.Location != nil {
+		loc = opts.Time.Location.String()
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%+v\n%+v\n%+v\n%s|%d|%s",
+		keyVersion, contentHash, opts.Format, opts.Dialect, opts.JSON, opts.Numeric,
+		opts.Time.Format, opts.Time.Bucket, loc)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the cached result for key, looking in memory first and then on disk.
+func (c *ResultCache) Get(key string) (map[string]*models.TempStat, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if !c.expired(e.created) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return e.stats, true
+		}
+		c.removeElement(el)
+	}
+	c.mu.Unlock()
+
+	stats, created, ok := c.readDisk(key)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.addMemory(key, stats, created)
+	c.mu.Unlock()
+	return stats, true
+}
+
+// Put stores a result in both tiers. A disk write failure only loses the disk copy.
+func (c *ResultCache) Put(key string, stats map[string]*models.TempStat) error {
+	if c == nil {
+		return nil
+	}
+	created := time.Now()
+	c.mu.Lock()
+	c.addMemory(key, stats, created)
+	c.mu.Unlock()
+	return c.writeDisk(key, stats)
+}
+
+// Purge drops every cached result and returns how many entries were removed from each tier.
+func (c *ResultCache) Purge() (memory, disk int, err error) {
+	if c == nil {
+		return 0, 0, nil
+	}
+	c.mu.Lock()
+	memory = c.lru.Len()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+
+	files, err := c.diskFiles()
+	for _, f := range files {
+		if rmErr := os.Remove(f.path); rmErr == nil {
+			disk++
+		}
+	}
+	return memory, disk, err
+}
+
+// Len returns the number of results held in memory.
+func (c *ResultCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ResultCache) expired(created time.Time) bool {
+	return c.cfg.TTL > 0 && time.Since(created) > c.cfg.TTL
+}
+
+// addMemory inserts or refreshes an entry and evicts the least recently used ones. Callers hold mu.
+func (c *ResultCache) addMemory(key string, stats map[string]*models.TempStat, created time.Time) {
+	if c.cfg.MaxEntries <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = &entry{key: key, stats: stats, created: created}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&entry{key: key, stats: stats, created: created})
+	for c.lru.Len() > c.cfg.MaxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *ResultCache) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
+
+func (c *ResultCache) path(key string) string {
+	return filepath.Join(c.cfg.Dir, key+".json")
+}
+
+// readDisk loads a result file, treating the file modification time as its creation time.
+func (c *ResultCache) readDisk(key string) (map[string]*models.TempStat, time.Time, bool) {
+	if c.cfg.Dir == "" {
+		return nil, time.Time{}, false
+	}
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	if c.expired(info.ModTime()) {
+		os.Remove(path)
+		return nil, time.Time{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	var stats map[string]*models.TempStat
+	if err := json.Unmarshal(data, &stats); err != nil {
+		// A truncated or foreign file is dropped rather than served
+		os.Remove(path)
+		return nil, time.Time{}, false
+	}
+	return stats, info.ModTime(), true
+}
+
+// writeDisk stores a result atomically and trims the disk tier to MaxDiskBytes.
+func (c *ResultCache) writeDisk(key string, stats map[string]*models.TempStat) error {
+	if c.cfg.Dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.cfg.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.trimDisk()
+}
+
+type diskFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// diskFiles lists the result files of the disk tier, oldest first.
+func (c *ResultCache) diskFiles() ([]diskFile, error) {
+	if c.cfg.Dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(c.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []diskFile
+	for _, de := range entries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, diskFile{filepath.Join(c.cfg.Dir, de.Name()), info.Size(), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	return files, nil
+}
+
+// trimDisk removes expired files, then the oldest ones until the tier fits MaxDiskBytes.
+func (c *ResultCache) trimDisk() error {
+	files, err := c.diskFiles()
+	if err != nil {
+		return err
+	}
+	var total int64
+	kept := files[:0]
+	for _, f := range files {
+		if c.expired(f.modTime) {
+			os.Remove(f.path)
+			continue
+		}
+		total += f.size
+		kept = append(kept, f)
+	}
+	for _, f := range kept {
+		if c.cfg.MaxDiskBytes <= 0 || total <= c.cfg.MaxDiskBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+// Package client is a Go client for the HTTP API. Uploads are streamed as multipart bodies
+// straight from the reader, so files are never buffered in memory, and results are decoded
+// into the types of the models package.
+package client
+
+import (
+	"1brc-challange/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Client calls the API at BaseURL. The zero values of the other fields pick the defaults.
+type Client struct {
+	BaseURL    string       // e.g. http://localhost:8080
+	HTTPClient *http.Client // http.DefaultClient when nil
+	// MaxAttempts is how often a request is tried; 3 when zero. Uploads are only tried
+	// again when their reader is an io.Seeker, so the body can be sent once more.
+	MaxAttempts int
+	// Backoff is the wait before the second attempt, doubling with each further one; 200ms
+	// when zero. A Retry-After header of the server takes precedence.
+	Backoff time.Duration
+	// Propagator writes the trace context of the request context into the request
+	// headers, so the server continues the caller's trace; the W3C traceparent header
+	// when nil.
+	Propagator propagation.TextMapPropagator
+}
+
+// New returns a client for the API at baseURL with default settings.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// APIError is a response of the server with a status other than 2xx.
+type APIError struct {
+	StatusCode int
+	Message    string // the "error" field of the response, or its status text
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Options are the input options of the processing endpoints. Empty fields leave the
+// server defaults; the values are those of the query parameters documented in the README.
+type Options struct {
+	Filename string // name sent with the upload; the file name for an *os.File, else "measurements.txt"
+
+	InputFormat     string // "text", "ndjson" or "columnar"
+	Delimiter       string
+	Quote           string
+	Comment         string
+	Header          bool
+	StationColumn   string
+	ValueColumn     string
+	TimestampColumn string
+	StationField    string
+	ValueField      string
+	TimestampField  string
+	EmptyPolicy     string
+	NaNPolicy       string
+	TimeFormat      string
+	Bucket          string // "hour", "day" or a Go duration
+	Timezone        string
+}
+
+func (o Options) values() url.Values {
+	q := url.Values{}
+	set := func(key, v string) {
+		if v != "" {
+			q.Set(key, v)
+		}
+	}
+	set("input_format", o.InputFormat)
+	set("delimiter", o.Delimiter)
+	set("quote", o.Quote)
+	set("comment", o.Comment)
+	if o.Header {
+		q.Set("header", "true")
+	}
+	set("station_column", o.StationColumn)
+	set("value_column", o.ValueColumn)
+	set("timestamp_column", o.TimestampColumn)
+	set("station_field", o.StationField)
+	set("value_field", o.ValueField)
+	set("timestamp_field", o.TimestampField)
+	set("empty_policy", o.EmptyPolicy)
+	set("nan_policy", o.NaNPolicy)
+	set("time_format", o.TimeFormat)
+	set("bucket", o.Bucket)
+	set("timezone", o.Timezone)
+	return q
+}
+
+// AggregateResult is the answer of the aggregation endpoint. Stations is set for plain
+// runs, Buckets when the run was bucketed by time.
+type AggregateResult struct {
+	Stations  map[string]models.TempStat
+	Buckets   []models.BucketStat
+	Bucket    string
+	RunID     string
+	InputHash string
+	Cached    bool
+	NumCPU    int
+}
+
+// AnomalyResult is the answer of the anomaly detection endpoint.
+type AnomalyResult struct {
+	Anomalies []models.Anomaly
+	RunID     string
+	NumCPU    int
+}
+
+// Aggregate uploads r and returns the per-station aggregates.
+func (c *Client) Aggregate(ctx context.Context, r io.Reader, opts Options) (*AggregateResult, error) {
+	var body struct {
+		Result json.RawMessage `json:"result"`
+		Bucket string          `json:"bucket"`
+		RunID  string          `json:"run_id"`
+		NumCPU int             `json:"num_cpu"`
+	}
+	resp, err := c.upload(ctx, "/one-billion-row-challenge", r, opts, opts.values(), &body)
+	if err != nil {
+		return nil, err
+	}
+	result := &AggregateResult{
+		Bucket:    body.Bucket,
+		RunID:     body.RunID,
+		InputHash: resp.Header.Get("X-Content-SHA256"),
+		Cached:    resp.Header.Get("X-Cache") == "HIT",
+		NumCPU:    body.NumCPU,
+	}
+	if body.Bucket != "" {
+		err = json.Unmarshal(body.Result, &result.Buckets)
+	} else {
+		err = json.Unmarshal(body.Result, &result.Stations)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	return result, nil
+}
+
+// AggregateFile streams the file at path to Aggregate; failed attempts are retried.
+func (c *Client) AggregateFile(ctx context.Context, path string, opts Options) (*AggregateResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.Aggregate(ctx, f, opts)
+}
+
+// DetectAnomalies uploads r and returns the anomalies found. A nil rules uses the server defaults.
+func (c *Client) DetectAnomalies(ctx context.Context, r io.Reader, opts Options, rules *models.AnomalyRules) (*AnomalyResult, error) {
+	q := opts.values()
+	if rules != nil {
+		format := func(v float32) string { return strconv.FormatFloat(float64(v), 'g', -1, 32) }
+		q.Set("extreme_min", format(rules.ExtremeMin))
+		q.Set("extreme_max", format(rules.ExtremeMax))
+		q.Set("spike_delta", format(rules.SpikeDelta))
+		if rules.SpikeRate > 0 {
+			q.Set("spike_rate", format(rules.SpikeRate))
+		}
+	}
+	var body struct {
+		Result []models.Anomaly `json:"result"`
+		RunID  string           `json:"run_id"`
+		NumCPU int              `json:"num_cpu"`
+	}
+	if _, err := c.upload(ctx, "/anomaly-detection", r, opts, q, &body); err != nil {
+		return nil, err
+	}
+	return &AnomalyResult{Anomalies: body.Result, RunID: body.RunID, NumCPU: body.NumCPU}, nil
+}
+
+// Health returns nil when the server reports itself healthy.
+func (c *Client) Health(ctx context.Context) error {
+	var body struct {
+		Status string `json:"status"`
+	}
+	if _, err := c.get(ctx, "/health", nil, &body); err != nil {
+		return err
+	}
+	if body.Status != "ok" {
+		return fmt.Errorf("server status %q", body.Status)
+	}
+	return nil
+}
+
+// RunQuery selects recorded runs. Zero fields leave the server defaults.
+type RunQuery struct {
+	Kind   models.RunKind
+	Limit  int
+	Offset int
+}
+
+// ListRuns returns a page of recorded runs, newest first, and the number of matching runs.
+func (c *Client) ListRuns(ctx context.Context, query RunQuery) ([]models.Run, int, error) {
+	q := url.Values{}
+	if query.Kind != "" {
+		q.Set("kind", string(query.Kind))
+	}
+	if query.Limit > 0 {
+		q.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Offset > 0 {
+		q.Set("offset", strconv.Itoa(query.Offset))
+	}
+	var body struct {
+		Runs  []models.Run `json:"runs"`
+		Total int          `json:"total"`
+	}
+	if _, err := c.get(ctx, "/runs", q, &body); err != nil {
+		return nil, 0, err
+	}
+	return body.Runs, body.Total, nil
+}
+
+// RunDetail is a recorded run with its result rows and anomalies.
+type RunDetail struct {
+	Run       models.Run
+	Result    []models.StationResult
+	Total     int // rows before paging
+	Anomalies []models.Anomaly
+}
+
+// GetRun returns a recorded run. Result parameters such as include or limit may be passed in params.
+func (c *Client) GetRun(ctx context.Context, id string, params url.Values) (*RunDetail, error) {
+	return c.getRun(ctx, "/runs/"+url.PathEscape(id), params)
+}
+
+// GetRunStation returns what a recorded run holds for one station.
+func (c *Client) GetRunStation(ctx context.Context, id, station string) (*RunDetail, error) {
+	return c.getRun(ctx, "/runs/"+url.PathEscape(id)+"/stations/"+url.PathEscape(station), nil)
+}
+
+func (c *Client) getRun(ctx context.Context, path string, params url.Values) (*RunDetail, error) {
+	var body struct {
+		Run       models.Run             `json:"run"`
+		Result    []models.StationResult `json:"result"`
+		Total     int                    `json:"total"`
+		Anomalies []models.Anomaly       `json:"anomalies"`
+	}
+	if _, err := c.get(ctx, path, params, &body); err != nil {
+		return nil, err
+	}
+	return &RunDetail{Run: body.Run, Result: body.Result, Total: body.Total, Anomalies: body.Anomalies}, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, q url.Values, out any) (*http.Response, error) {
+	return c.do(ctx, func() (*http.Request, func(), error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, q), nil)
+		return req, func() {}, err
+	}, true, out)
+}
+
+// upload sends r as the `file` field of a multipart form. The body is written while it is
+// sent, so r is read exactly once per attempt.
+func (c *Client) upload(ctx context.Context, path string, r io.Reader, opts Options, q url.Values, out any) (*http.Response, error) {
+	name := opts.Filename
+	if name == "" {
+		name = "measurements.txt"
+		if f, ok := r.(*os.File); ok {
+			name = filepath.Base(f.Name())
+		}
+	}
+	seeker, replayable := r.(io.Seeker)
+	var start int64
+	if replayable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			replayable = false
+		}
+	}
+	attempt := 0
+	return c.do(ctx, func() (*http.Request, func(), error) {
+		if attempt++; attempt > 1 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, nil, err
+			}
+		}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			part, err := mw.CreateFormFile("file", name)
+			if err == nil {
+				_, err = io.Copy(part, r)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		// The writer must have stopped reading r before the next attempt seeks it
+		wait := func() {
+			pr.CloseWithError(errors.New("request finished"))
+			<-done
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url(path, q), pr)
+		if err != nil {
+			wait()
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req, wait, nil
+	}, replayable, out)
+}
+
+func (c *Client) url(path string, q url.Values) string {
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+// do sends the request built by newRequest and decodes a 2xx JSON answer into out. Transport
+// errors and 429, 502, 503 and 504 answers are retried when retry is set. finish is called
+// once the attempt is over.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, func(), error), retry bool, out any) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+	if c.Propagator != nil {
+		propagator = c.Propagator
+	}
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	if !retry {
+		attempts = 1
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = 200 * time.Millisecond
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, finish, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		// Continue the caller's trace on the server
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		resp, err := httpClient.Do(req)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			finish()
+			if ctx.Err() != nil || attempt >= attempts {
+				return nil, err
+			}
+			wait = backoff << (attempt - 1)
+		case retryable(resp.StatusCode) && attempt < attempts:
+			wait = retryAfter(resp, backoff<<(attempt-1))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			finish()
+		default:
+			err := decodeResponse(resp, out)
+			finish()
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads the wait from a Retry-After header in seconds, falling back to def.
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return def
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package cluster spreads the decoding of one file over several processes. A coordinator
+// splits the spooled upload into byte ranges and hands them to registered workers over
+// HTTP. Workers decode their range, either from a path they share with the coordinator or
+// from the bytes streamed in the request, and answer with a partial aggregate that the
+// coordinator merges. Workers prove they are alive with heartbeats; ranges of a worker that
+// fails or goes silent are handed to another one.
+package cluster
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoWorkers is returned when no live worker is registered, so the caller can decode locally.
+	ErrNoWorkers = errors.New("no cluster workers available")
+	// ErrWorkerNotFound is returned for a heartbeat or removal of an unknown worker.
+	ErrWorkerNotFound = errors.New("cluster worker not found")
+	// ErrRangeRejected is returned when a worker refuses a range because of its content,
+	// such as a value the numeric policy fails on. Another worker would refuse it too.
+	ErrRangeRejected = utilities.BadInput("range rejected by worker")
+)
+
+// Config tunes the coordinator.
+type Config struct {
+	// HeartbeatInterval is how often workers are asked to send a heartbeat.
+	HeartbeatInterval time.Duration
+	// HeartbeatTimeout is how long a worker may stay silent before it is considered dead.
+	HeartbeatTimeout time.Duration
+	// RangeTimeout bounds the decoding of one range by one worker.
+	RangeTimeout time.Duration
+	// MaxAttempts is how often a range is tried before the run fails.
+	MaxAttempts int
+	// RangesPerSlot splits the file finer than the number of worker slots, so a range
+	// lost with a worker costs less and faster workers pick up more of the file.
+	RangesPerSlot int
+	// SharedPath sends workers the path of the spooled file instead of its bytes. It only
+	// works when coordinator and workers see the same file system.
+	SharedPath bool
+	// SharedDir is the directory uploads are spooled to with SharedPath, so workers only
+	// need access to this directory. It must not be the temp dir, which holds other files.
+	SharedDir string
+	// Token is the secret that coordinator and workers present to each other. Requests
+	// to the cluster routes without it are refused.
+	Token string
+}
+
+// DefaultConfig returns the coordinator settings used when none are configured.
+func DefaultConfig() Config {
+	return Config{
+		HeartbeatInterval: 2 * time.Second,
+		HeartbeatTimeout:  10 * time.Second,
+		RangeTimeout:      5 * time.Minute,
+		MaxAttempts:       3,
+		RangesPerSlot:     2,
+	}
+}
+
+// SetToken adds the cluster token to a request between coordinator and workers.
+func SetToken(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// Authorized reports whether req carries token. No request is authorized when token is empty.
+func Authorized(req *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// Registration is what a worker sends to join the cluster.
+type Registration struct {
+	Addr  string // base URL the coordinator reaches the worker at, e.g. http://10.0.0.5:9001
+	Slots int    // ranges the worker decodes at the same time
+}
+
+// Assignment is the coordinator's answer to a registration.
+type Assignment struct {
+	ID                string
+	HeartbeatInterval time.Duration
+}
+
+// WorkerInfo describes a registered worker.
+type WorkerInfo struct {
+	ID         string
+	Addr       string
+	Slots      int
+	Busy       int
+	Alive      bool
+	Registered time.Time
+	LastSeen   time.Time
+	Done       int // ranges decoded
+	Failed     int // ranges that failed on this worker
+}
+
+// DecodeSpec carries the decoder settings of a run to the workers. Named columns must
+// already be resolved to indices.
+type DecodeSpec struct {
+	Format     models.InputFormat
+	Dialect    models.Dialect
+	JSON       models.JSONFields
+	Numeric    models.NumericOptions
+	TimeFormat string        `json:",omitempty"`
+	Bucket     time.Duration `json:",omitempty"`
+	Location   string        `json:",omitempty"`
+}
+
+// SpecFromOptions captures the decoder settings of prepared processing options.
+func SpecFromOptions(opts models.ProcessOptions) DecodeSpec {
+	spec := DecodeSpec{
+		Format:     opts.Format,
+		Dialect:    opts.Dialect,
+		JSON:       opts.JSON,
+		Numeric:    opts.Numeric,
+		TimeFormat: opts.Time.Format,
+		Bucket:     opts.Time.Bucket,
+	}
+	if opts.Time.Location != nil {
+		spec.Location = opts.Time.Location.String()
+	}
+	return spec
+}
+
+// Options turns the spec back into processing options for a decoder.
+func (s DecodeSpec) Options() (models.ProcessOptions, error) {
+	opts := models.ProcessOptions{
+		Format:  s.Format,
+		Dialect: s.Dialect,
+		JSON:    s.JSON,
+		Numeric: s.Numeric,
+		Time:    models.TimeOptions{Format: s.TimeFormat, Bucket: s.Bucket},
+	}
+	if s.Location != "" {
+		loc, err := time.LoadLocation(s.Location)
+		if err != nil {
+			return opts, fmt.Errorf("location: %w", err)
+		}
+		opts.Time.Location = loc
+	}
+	return opts, nil
+}
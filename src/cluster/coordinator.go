@@ -0,0 +1,476 @@
+package cluster
+
+import (
+	"1brc-challange/logging"
+	"1brc-challange/models"
+	"1brc-challange/tracing"
+	"1brc-challange/utilities"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// forgetAfter is how many heartbeat timeouts a dead worker stays listed before it is dropped.
+const forgetAfter = 10
+
+// Coordinator keeps the worker registry and schedules the ranges of a run onto workers.
+// A nil coordinator has no workers.
+type Coordinator struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	// wake is signalled when a worker joins or comes back, so waiting runs retry dispatch
+	wake chan struct{}
+}
+
+// worker is the coordinator's view of one registered worker.
+type worker struct {
+	WorkerInfo
+	inflight map[*task]context.CancelFunc
+}
+
+// task is one byte range of a run.
+type task struct {
+	index    int
+	part     models.Part
+	attempts int
+}
+
+// outcome is the result of one attempt at a task.
+type outcome struct {
+	task   *task
+	worker *worker
+	stats  map[string]models.TempStat
+	err    error
+}
+
+// NewCoordinator returns a coordinator with an empty registry. Zero fields of cfg take
+// their DefaultConfig values.
+func NewCoordinator(cfg Config) *Coordinator {
+	def := DefaultConfig()
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = def.HeartbeatInterval
+	}
+	if cfg.HeartbeatTimeout <= 0 {
+		cfg.HeartbeatTimeout = def.HeartbeatTimeout
+	}
+	if cfg.RangeTimeout <= 0 {
+		cfg.RangeTimeout = def.RangeTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.RangesPerSlot <= 0 {
+		cfg.RangesPerSlot = def.RangesPerSlot
+	}
+	return &Coordinator{
+		cfg:     cfg,
+		client:  &http.Client{},
+		workers: make(map[string]*worker),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Config returns the effective coordinator settings.
+func (co *Coordinator) Config() Config {
+	return co.cfg
+}
+
+// Authorized reports whether req carries the cluster token.
+func (co *Coordinator) Authorized(req *http.Request) bool {
+	return co != nil && Authorized(req, co.cfg.Token)
+}
+
+// Register adds a worker to the registry. A worker registering again with the same
+// address replaces its old entry, so a restarted worker does not count twice.
+func (co *Coordinator) Register(reg Registration) (Assignment, error) {
+	addr := strings.TrimRight(reg.Addr, "/")
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Assignment{}, fmt.Errorf("worker address %q is not an http(s) URL", reg.Addr)
+	}
+	if reg.Slots <= 0 {
+		reg.Slots = 1
+	}
+	now := time.Now().UTC()
+	w := &worker{
+		WorkerInfo: WorkerInfo{
+			ID:         newWorkerID(now),
+			Addr:       addr,
+			Slots:      reg.Slots,
+			Alive:      true,
+			Registered: now,
+			LastSeen:   now,
+		},
+		inflight: make(map[*task]context.CancelFunc),
+	}
+
+	co.mu.Lock()
+	for id, old := range co.workers {
+		if old.Addr == addr {
+			co.kill(old)
+			delete(co.workers, id)
+		}
+	}
+	co.workers[w.ID] = w
+	co.mu.Unlock()
+	co.signal()
+	return Assignment{ID: w.ID, HeartbeatInterval: co.cfg.HeartbeatInterval}, nil
+}
+
+// Heartbeat marks a worker as alive. It revives a worker that was considered dead.
+func (co *Coordinator) Heartbeat(id string) error {
+	if co == nil {
+		return ErrWorkerNotFound
+	}
+	co.mu.Lock()
+	w, ok := co.workers[id]
+	if ok {
+		revived := !w.Alive
+		w.Alive = true
+		w.LastSeen = time.Now().UTC()
+		if revived {
+			defer co.signal()
+		}
+	}
+	co.mu.Unlock()
+	if !ok {
+		return ErrWorkerNotFound
+	}
+	return nil
+}
+
+// Remove drops a worker from the registry; its ranges in flight are reassigned.
+func (co *Coordinator) Remove(id string) error {
+	if co == nil {
+		return ErrWorkerNotFound
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	w, ok := co.workers[id]
+	if !ok {
+		return ErrWorkerNotFound
+	}
+	co.kill(w)
+	delete(co.workers, id)
+	return nil
+}
+
+// Workers lists the registered workers ordered by registration.
+func (co *Coordinator) Workers() []WorkerInfo {
+	if co == nil {
+		return nil
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.reap()
+	list := make([]WorkerInfo, 0, len(co.workers))
+	for _, w := range co.workers {
+		list = append(list, w.WorkerInfo)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Slots returns the number of ranges live workers can decode at the same time.
+func (co *Coordinator) Slots() int {
+	if co == nil {
+		return 0
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.reap()
+	slots := 0
+	for _, w := range co.workers {
+		if w.Alive {
+			slots += w.Slots
+		}
+	}
+	return slots
+}
+
+// Decode splits the file at path into ranges and decodes them on the workers, returning
+// one result per range for MergeResults. A range whose worker fails or stops sending
+// heartbeats is handed to another worker, up to MaxAttempts times. It returns ErrNoWorkers
+// when no worker is alive, before or during the run.
+func (co *Coordinator) Decode(ctx context.Context, path string, spec DecodeSpec) ([]map[string]models.TempStat, error) {
+	slots := co.Slots()
+	if slots == 0 {
+		return nil, ErrNoWorkers
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// Small files are not worth many ranges
+	ranges := slots * co.cfg.RangesPerSlot
+	if max := int(info.Size() / 1024); ranges > max {
+		ranges = max
+	}
+	if ranges < 1 {
+		ranges = 1
+	}
+	parts, err := utilities.SplitFile(path, ranges)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split file: %w", err)
+	}
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make([]*task, len(parts))
+	for i, p := range parts {
+		pending[i] = &task{index: i, part: p}
+	}
+	results := make([]map[string]models.TempStat, len(parts))
+	// Every task is in flight at most once, so the channel never blocks a sender
+	done := make(chan outcome, len(parts))
+	inflight := 0
+	remaining := len(parts)
+	var stalled time.Time
+	ticker := time.NewTicker(co.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	// A run that ends early cancels the ranges still in flight and waits for them, so
+	// their workers get their slots back
+	defer func() {
+		cancel()
+		for ; inflight > 0; inflight-- {
+			o := <-done
+			co.release(o.worker, o.task, o.err)
+		}
+	}()
+
+	for remaining > 0 {
+		for len(pending) > 0 {
+			w, taskCtx := co.acquire(ctx, pending[0])
+			if w == nil {
+				break
+			}
+			t := pending[0]
+			pending = pending[1:]
+			inflight++
+			go func() {
+				stats, err := co.decodeRange(taskCtx, w, path, t.part, specJSON)
+				done <- outcome{task: t, worker: w, stats: stats, err: err}
+			}()
+		}
+		// Workers taken out of rotation get one heartbeat timeout to come back
+		if inflight == 0 && co.Slots() == 0 {
+			if stalled.IsZero() {
+				stalled = time.Now()
+			} else if time.Since(stalled) > co.cfg.HeartbeatTimeout {
+				return nil, fmt.Errorf("%w: all workers were lost during the run", ErrNoWorkers)
+			}
+		} else {
+			stalled = time.Time{}
+		}
+
+		select {
+		case o := <-done:
+			inflight--
+			co.release(o.worker, o.task, o.err)
+			if o.err == nil {
+				results[o.task.index] = o.stats
+				remaining--
+				continue
+			}
+			if errors.Is(o.err, ErrRangeRejected) {
+				return nil, o.err
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			o.task.attempts++
+			slog.WarnContext(ctx, "range failed", "range", o.task.index, "offset", o.task.part.Offset,
+				"worker", o.worker.Addr, "attempt", o.task.attempts, "error", o.err)
+			if o.task.attempts >= co.cfg.MaxAttempts {
+				return nil, fmt.Errorf("range at offset %d failed %d times: %w", o.task.part.Offset, o.task.attempts, o.err)
+			}
+			pending = append(pending, o.task)
+		case <-co.wake:
+		case <-ticker.C:
+			co.mu.Lock()
+			co.reap()
+			co.mu.Unlock()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// acquire reserves a slot on the least loaded live worker for t and returns the context
+// the attempt runs under. It returns nil when every slot is taken.
+func (co *Coordinator) acquire(ctx context.Context, t *task) (*worker, context.Context) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.reap()
+	var best *worker
+	for _, w := range co.workers {
+		if !w.Alive || w.Busy >= w.Slots {
+			continue
+		}
+		if best == nil || lessLoaded(w, best) {
+			best = w
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	taskCtx, cancel := context.WithTimeout(ctx, co.cfg.RangeTimeout)
+	best.Busy++
+	best.inflight[t] = cancel
+	return best, taskCtx
+}
+
+// lessLoaded reports whether a has the lower share of busy slots, ties going to the
+// worker that failed less often.
+func lessLoaded(a, b *worker) bool {
+	load, other := a.Busy*b.Slots, b.Busy*a.Slots
+	if load != other {
+		return load < other
+	}
+	return a.Failed < b.Failed
+}
+
+// release returns the slot of a finished attempt. A worker that could not be reached or
+// answered with a server error is taken out of rotation until its next heartbeat.
+func (co *Coordinator) release(w *worker, t *task, err error) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if cancel, ok := w.inflight[t]; ok {
+		cancel()
+		delete(w.inflight, t)
+		w.Busy--
+	}
+	switch {
+	case err == nil:
+		w.Done++
+	case errors.Is(err, ErrRangeRejected), errors.Is(err, context.Canceled):
+		// The content or the run is at fault, not the worker; a worker taken out of
+		// rotation has its ranges cancelled too and was already counted as failed
+	default:
+		w.Failed++
+		w.Alive = false
+	}
+}
+
+// reap marks workers without a recent heartbeat as dead, cancelling their ranges in
+// flight, and forgets workers that stayed dead for long. The caller holds co.mu.
+func (co *Coordinator) reap() {
+	now := time.Now()
+	for id, w := range co.workers {
+		silent := now.Sub(w.LastSeen)
+		if w.Alive && silent > co.cfg.HeartbeatTimeout {
+			co.kill(w)
+		}
+		if silent > forgetAfter*co.cfg.HeartbeatTimeout && len(w.inflight) == 0 {
+			delete(co.workers, id)
+		}
+	}
+}
+
+// kill marks a worker dead and cancels its ranges in flight. The caller holds co.mu.
+func (co *Coordinator) kill(w *worker) {
+	w.Alive = false
+	for _, cancel := range w.inflight {
+		cancel()
+	}
+}
+
+func (co *Coordinator) signal() {
+	select {
+	case co.wake <- struct{}{}:
+	default:
+	}
+}
+
+// decodeRange asks a worker to decode one range. With SharedPath the worker opens the file
+// itself; otherwise the bytes of the range are streamed in the request body.
+func (co *Coordinator) decodeRange(ctx context.Context, w *worker, path string, part models.Part, spec []byte) (stations map[string]models.TempStat, err error) {
+	ctx, span := tracing.Start(ctx, "decode_range", attribute.String("worker.addr", w.Addr),
+		attribute.Int64("part.offset", part.Offset), attribute.Int64("part.size", part.Size))
+	defer func() { tracing.End(span, err) }()
+
+	q := url.Values{}
+	q.Set("offset", strconv.FormatInt(part.Offset, 10))
+	q.Set("size", strconv.FormatInt(part.Size, 10))
+	q.Set("spec", string(spec))
+
+	var body io.Reader = http.NoBody
+	if co.cfg.SharedPath {
+		q.Set("path", path)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = io.NewSectionReader(f, part.Offset, part.Size)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Addr+DecodePath+"?"+q.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = part.Size
+	if co.cfg.SharedPath {
+		req.ContentLength = 0
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	SetToken(req, co.cfg.Token)
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	resp, err := co.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg struct{ Error string }
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&msg)
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return nil, fmt.Errorf("%w: %s", ErrRangeRejected, msg.Error)
+		}
+		return nil, fmt.Errorf("worker answered %s: %s", resp.Status, msg.Error)
+	}
+	set, err := utilities.DecodePartials(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	merged := make([]map[string]models.TempStat, 0, len(set.Partials))
+	for _, p := range set.Partials {
+		merged = append(merged, p.Stations)
+	}
+	if len(merged) == 1 {
+		return merged[0], nil
+	}
+	// Partial maps of one range are merged here so the run keeps one result per range
+	stats := make(map[string]models.TempStat)
+	for key, stat := range utilities.MergeResults(merged) {
+		stats[key] = *stat
+	}
+	return stats, nil
+}
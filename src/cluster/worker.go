@@ -0,0 +1,164 @@
+package cluster
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// WorkersPath is where workers register with the coordinator.
+	WorkersPath = "/cluster/workers"
+	// DecodePath is where a worker accepts ranges to decode.
+	DecodePath = "/cluster/decode"
+)
+
+// newWorkerID returns a unique worker ID that sorts by registration time.
+func newWorkerID(now time.Time) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return fmt.Sprintf("%016x%s", now.UnixNano(), hex.EncodeToString(suffix[:]))
+}
+
+// DecodeRange decodes one range for the coordinator. With a path the worker reads the
+// range from the shared file; otherwise body holds the bytes of the range. A range at
+// offset 0 skips the header row when the dialect has one.
+func DecodeRange(spec DecodeSpec, path string, offset, size int64, body io.Reader) (map[string]models.TempStat, error) {
+	opts, err := spec.Options()
+	if err != nil {
+		return nil, err
+	}
+	// The answer holds every station of the range at once, so the range does not spill:
+	// with one decoder its table would have to fit the budget again when merged
+	dec := utilities.NewDecoder(opts)
+	result := make(map[string]models.TempStat)
+	if path != "" {
+		err = dec.DecodePart(path, offset, size, result)
+	} else {
+		err = dec.DecodeSection(io.LimitReader(body, size), offset == 0, result)
+	}
+	return result, err
+}
+
+// AgentConfig tells a worker process how to join a cluster.
+type AgentConfig struct {
+	Coordinator string // base URL of the coordinator
+	Token       string // shared cluster token
+	Registration
+}
+
+// RunAgent registers the worker with the coordinator and sends heartbeats until ctx is
+// done, then leaves the cluster. It registers again whenever the coordinator no longer
+// knows the worker, for example after a coordinator restart.
+func RunAgent(ctx context.Context, cfg AgentConfig) error {
+	base := strings.TrimRight(cfg.Coordinator, "/")
+	client := &http.Client{Timeout: 5 * time.Second}
+	var assigned Assignment
+	retry := time.Second
+
+	for {
+		if assigned.ID == "" {
+			a, err := register(ctx, client, base, cfg.Token, cfg.Registration)
+			if err != nil {
+				slog.WarnContext(ctx, "registering with coordinator failed", "coordinator", base, "error", err)
+			} else {
+				assigned = a
+				slog.InfoContext(ctx, "registered with coordinator", "coordinator", base, "worker_id", a.ID)
+			}
+		} else {
+			err := heartbeat(ctx, client, base, cfg.Token, assigned.ID)
+			if errors.Is(err, ErrWorkerNotFound) {
+				assigned = Assignment{}
+				continue
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "heartbeat failed", "coordinator", base, "worker_id", assigned.ID, "error", err)
+			}
+		}
+
+		wait := retry
+		if assigned.HeartbeatInterval > 0 {
+			wait = assigned.HeartbeatInterval
+		}
+		select {
+		case <-ctx.Done():
+			if assigned.ID != "" {
+				leave(client, base, cfg.Token, assigned.ID)
+			}
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func register(ctx context.Context, client *http.Client, base, token string, reg Registration) (Assignment, error) {
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return Assignment{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+WorkersPath, bytes.NewReader(data))
+	if err != nil {
+		return Assignment{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SetToken(req, token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return Assignment{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return Assignment{}, fmt.Errorf("coordinator answered %s", resp.Status)
+	}
+	var a Assignment
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return Assignment{}, err
+	}
+	return a, nil
+}
+
+func heartbeat(ctx context.Context, client *http.Client, base, token, id string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+WorkersPath+"/"+id+"/heartbeat", http.NoBody)
+	if err != nil {
+		return err
+	}
+	SetToken(req, token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrWorkerNotFound
+	default:
+		return fmt.Errorf("coordinator answered %s", resp.Status)
+	}
+}
+
+// leave deregisters the worker; it runs after the agent's context ended, so it has its own deadline.
+func leave(client *http.Client, base, token, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, base+WorkersPath+"/"+id, http.NoBody)
+	if err != nil {
+		return
+	}
+	SetToken(req, token)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
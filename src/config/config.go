@@ -0,0 +1,385 @@
+// Package config holds the settings of the service. Values start from Default and are
+// overridden by a YAML file, then by environment variables, then by command-line flags.
+// Load validates the result, so a bad setting stops the process at startup.
+package config
+
+import (
+	"1brc-challange/admission"
+	"1brc-challange/cache"
+	"1brc-challange/cluster"
+	"1brc-challange/logging"
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/tracing"
+	"1brc-challange/utilities"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the server. The yaml names are the keys of the file.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	Processing Processing `yaml:"processing"`
+	Admission  Admission  `yaml:"admission"`
+	Anomaly    Anomaly    `yaml:"anomaly"`
+	Cache      Cache      `yaml:"cache"`
+	Runs       Runs       `yaml:"runs"`
+	Aggregates Aggregates `yaml:"aggregates"`
+	Cluster    Cluster    `yaml:"cluster"`
+	Health     Health     `yaml:"health"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Logging    Logging    `yaml:"logging"`
+}
+
+// Server selects the addresses the service listens on.
+type Server struct {
+	Port         int    `yaml:"port"`          // port of the HTTP API
+	GRPCAddr     string `yaml:"grpc_addr"`     // "" disables gRPC
+	AdminAddr    string `yaml:"admin_addr"`    // address of the admin routes, "" keeps them off or on the API port
+	PprofEnabled bool   `yaml:"pprof_enabled"` // mount the admin routes on the API port when AdminAddr is empty
+
+	// ShutdownTimeout bounds how long requests in flight may finish after a stop signal;
+	// the runs still going then are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay keeps serving with failing health checks before shutting down, so load
+	// balancers stop routing to the server first.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+// Addr returns the address of the HTTP API.
+func (s Server) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
+
+// Processing sizes the decoding pipeline.
+type Processing struct {
+	Workers         int   `yaml:"workers"`          // decode workers, 0 uses one per CPU
+	MemoryThreshold int64 `yaml:"memory_threshold"` // uploads larger than this are spooled to disk
+	ReadBuffer      int   `yaml:"read_buffer"`      // bytes the decoders read at a time
+	LineBuffer      int   `yaml:"line_buffer"`      // bytes anomaly detection reads at a time
+	ChannelBuffer   int   `yaml:"channel_buffer"`   // entries queued between the stages of anomaly detection
+	MaxLineLength   int   `yaml:"max_line_length"`  // longest accepted line, 0 accepts any length
+
+	// MemoryBudget bounds the bytes the station table of one decode worker may take, 0
+	// leaves it unbounded. BudgetPolicy is spill to write tables over it to disk and merge
+	// them at the end, or fail to fail the run.
+	MemoryBudget int64  `yaml:"memory_budget"`
+	BudgetPolicy string `yaml:"budget_policy"`
+
+	// TempMaxAge is the age after which upload files left in the temp dir by a process
+	// that died are removed at startup. 0 disables the sweep.
+	TempMaxAge time.Duration `yaml:"temp_max_age"`
+}
+
+// WorkerCount returns the number of decode workers.
+func (p Processing) WorkerCount() int {
+	if p.Workers == 0 {
+		return runtime.NumCPU()
+	}
+	return p.Workers
+}
+
+// Tuning returns the limits of the input pipeline.
+func (p Processing) Tuning() utilities.Tuning {
+	return utilities.Tuning{
+		MemoryThreshold: p.MemoryThreshold,
+		ReadBuffer:      p.ReadBuffer,
+		LineBuffer:      p.LineBuffer,
+		ChannelBuffer:   p.ChannelBuffer,
+		MaxLineLength:   p.MaxLineLength,
+		MemoryBudget:    p.MemoryBudget,
+		BudgetPolicy:    p.BudgetPolicy,
+	}
+}
+
+// Admission bounds the runs processed at once. MaxRuns 0 admits every run right away.
+type Admission struct {
+	MaxRuns      int           `yaml:"max_runs"`      // runs processed at once
+	MaxQueue     int           `yaml:"max_queue"`     // runs waiting for a slot before new ones are turned away
+	QueueTimeout time.Duration `yaml:"queue_timeout"` // time a run may wait for a slot
+	PoolSize     int           `yaml:"pool_size"`     // decode goroutines shared by all runs, 0 uses one per CPU
+}
+
+// Config returns the limits of admission control.
+func (a Admission) Config() admission.Config {
+	cfg := admission.Config{MaxRuns: a.MaxRuns, MaxQueue: a.MaxQueue, QueueTimeout: a.QueueTimeout, PoolSize: a.PoolSize}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = runtime.NumCPU()
+	}
+	return cfg
+}
+
+// Anomaly holds the default thresholds of anomaly detection. Requests may override them.
+type Anomaly struct {
+	ExtremeMin float32 `yaml:"extreme_min"`
+	ExtremeMax float32 `yaml:"extreme_max"`
+	SpikeDelta float32 `yaml:"spike_delta"`
+	SpikeRate  float32 `yaml:"spike_rate"` // °C per minute, 0 disables the rate rule
+}
+
+// Rules returns the thresholds as anomaly rules.
+func (a Anomaly) Rules() models.AnomalyRules {
+	return models.AnomalyRules{ExtremeMin: a.ExtremeMin, ExtremeMax: a.ExtremeMax, SpikeDelta: a.SpikeDelta, SpikeRate: a.SpikeRate}
+}
+
+// Cache holds the result cache limits. MaxEntries 0 without a Dir disables caching.
+type Cache struct {
+	MaxEntries   int           `yaml:"max_entries"`
+	TTL          time.Duration `yaml:"ttl"`
+	Dir          string        `yaml:"dir"`
+	MaxDiskBytes int64         `yaml:"max_disk_bytes"`
+}
+
+// Config returns the settings of the result cache.
+func (c Cache) Config() cache.Config {
+	return cache.Config{MaxEntries: c.MaxEntries, TTL: c.TTL, Dir: c.Dir, MaxDiskBytes: c.MaxDiskBytes}
+}
+
+// Runs holds the run history settings. An empty Path disables run history.
+type Runs struct {
+	Path    string        `yaml:"path"`
+	MaxRuns int           `yaml:"max_runs"`
+	MaxAge  time.Duration `yaml:"max_age"`
+}
+
+// Config returns the settings of the run store.
+func (r Runs) Config() store.Config {
+	return store.Config{Path: r.Path, MaxRuns: r.MaxRuns, MaxAge: r.MaxAge}
+}
+
+// Aggregates locates the named aggregates. An empty Path disables them.
+type Aggregates struct {
+	Path string `yaml:"path"`
+}
+
+// Cluster holds the coordinator settings used when Coordinator is set.
+type Cluster struct {
+	Coordinator      bool          `yaml:"coordinator"`
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"`
+	RangeTimeout     time.Duration `yaml:"range_timeout"`
+	MaxAttempts      int           `yaml:"max_attempts"`
+	RangesPerSlot    int           `yaml:"ranges_per_slot"`
+	SharedPath       bool          `yaml:"shared_path"`
+	SharedDir        string        `yaml:"shared_dir"` // where uploads are spooled for workers with shared_path
+	Token            string        `yaml:"token" secret:"true"`
+}
+
+// Config returns the settings of the coordinator.
+func (c Cluster) Config() cluster.Config {
+	cfg := cluster.DefaultConfig()
+	cfg.HeartbeatTimeout = c.HeartbeatTimeout
+	cfg.RangeTimeout = c.RangeTimeout
+	cfg.MaxAttempts = c.MaxAttempts
+	cfg.RangesPerSlot = c.RangesPerSlot
+	cfg.SharedPath = c.SharedPath
+	cfg.SharedDir = c.SharedDir
+	cfg.Token = c.Token
+	return cfg
+}
+
+// SameDir reports whether a and b name the same directory.
+func SameDir(a, b string) bool {
+	a, _ = filepath.Abs(a)
+	b, _ = filepath.Abs(b)
+	return a == b
+}
+
+// Health sets the thresholds of the readiness checks. A zero threshold skips its check.
+type Health struct {
+	MinFreeDisk    int64         `yaml:"min_free_disk"`   // bytes the temp dir must keep free
+	MemoryLimit    int64         `yaml:"memory_limit"`    // bytes the process may use, 0 uses GOMEMLIMIT
+	MemoryHeadroom int64         `yaml:"memory_headroom"` // bytes that must stay free below the limit
+	CheckTimeout   time.Duration `yaml:"check_timeout"`   // time each check may take
+}
+
+// Tracing selects where spans are exported. Headers is a comma-separated list of
+// key=value pairs sent with every OTLP export.
+type Tracing struct {
+	Exporter    string `yaml:"exporter"`
+	Endpoint    string `yaml:"endpoint"`
+	Headers     string `yaml:"headers" secret:"true"`
+	File        string `yaml:"file"`
+	ServiceName string `yaml:"service_name"` // "" uses the name of the process
+}
+
+// Config returns the tracing settings, named service unless a service name is configured.
+func (t Tracing) Config(service string) tracing.Config {
+	cfg := tracing.Config{Exporter: t.Exporter, Endpoint: t.Endpoint, File: t.File, ServiceName: t.ServiceName}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = service
+	}
+	cfg.Headers, _ = parseHeaders(t.Headers)
+	return cfg
+}
+
+// Logging selects the level and format of the logs.
+type Logging struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Config returns the logging settings.
+func (l Logging) Config() logging.Config {
+	return logging.Config{Level: l.Level, Format: l.Format}
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() Config {
+	tuning := utilities.DefaultTuning()
+	rules := utilities.DefaultAnomalyRules
+	clusterCfg := cluster.DefaultConfig()
+	return Config{
+		Server: Server{Port: 8080, GRPCAddr: ":50051", ShutdownTimeout: 20 * time.Second},
+		Processing: Processing{
+			MemoryThreshold: tuning.MemoryThreshold,
+			ReadBuffer:      tuning.ReadBuffer,
+			LineBuffer:      tuning.LineBuffer,
+			ChannelBuffer:   tuning.ChannelBuffer,
+			MaxLineLength:   tuning.MaxLineLength,
+			MemoryBudget:    tuning.MemoryBudget,
+			BudgetPolicy:    tuning.BudgetPolicy,
+			TempMaxAge:      time.Hour,
+		},
+		Admission:  Admission{MaxRuns: 4, MaxQueue: 64, QueueTimeout: 30 * time.Second},
+		Anomaly:    Anomaly{ExtremeMin: rules.ExtremeMin, ExtremeMax: rules.ExtremeMax, SpikeDelta: rules.SpikeDelta, SpikeRate: rules.SpikeRate},
+		Cache:      Cache{MaxEntries: 64, TTL: 24 * time.Hour, MaxDiskBytes: 1 << 30},
+		Runs:       Runs{Path: "data/runs.db", MaxRuns: 1000, MaxAge: 30 * 24 * time.Hour},
+		Aggregates: Aggregates{Path: "data/aggregates.db"},
+		Cluster: Cluster{
+			HeartbeatTimeout: clusterCfg.HeartbeatTimeout,
+			RangeTimeout:     clusterCfg.RangeTimeout,
+			MaxAttempts:      clusterCfg.MaxAttempts,
+			RangesPerSlot:    clusterCfg.RangesPerSlot,
+		},
+		Health:  Health{MinFreeDisk: 512 << 20, MemoryHeadroom: 256 << 20, CheckTimeout: 2 * time.Second},
+		Tracing: Tracing{File: "traces.json"},
+		Logging: Logging{Level: "info", Format: "json"},
+	}
+}
+
+// Validate reports every setting that is out of range.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Server.Port > 0 && c.Server.Port < 1<<16, "server.port: %d is not a port", c.Server.Port)
+	check(!(c.Server.AdminAddr != "" && c.Server.AdminAddr == c.Server.Addr()), "server.admin_addr: must differ from the API address")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout: must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay: must not be negative")
+
+	p := c.Processing
+	check(p.Workers >= 0, "processing.workers: must not be negative")
+	check(p.MemoryThreshold >= 0, "processing.memory_threshold: must not be negative")
+	check(p.ReadBuffer >= 4096, "processing.read_buffer: must be at least 4096 bytes")
+	check(p.LineBuffer >= 4096, "processing.line_buffer: must be at least 4096 bytes")
+	check(p.ChannelBuffer >= 0, "processing.channel_buffer: must not be negative")
+	check(p.MaxLineLength >= 0, "processing.max_line_length: must not be negative")
+	check(p.TempMaxAge >= 0, "processing.temp_max_age: must not be negative")
+	check(p.MemoryBudget >= 0, "processing.memory_budget: must not be negative")
+	check(p.BudgetPolicy == utilities.BudgetSpill || p.BudgetPolicy == utilities.BudgetFail,
+		"processing.budget_policy: unknown policy %q, want spill or fail", p.BudgetPolicy)
+
+	check(c.Admission.MaxRuns >= 0, "admission.max_runs: must not be negative")
+	check(c.Admission.MaxQueue >= 0, "admission.max_queue: must not be negative")
+	check(c.Admission.QueueTimeout >= 0, "admission.queue_timeout: must not be negative")
+	check(c.Admission.PoolSize >= 0, "admission.pool_size: must not be negative")
+
+	a := c.Anomaly
+	check(a.ExtremeMin <= a.ExtremeMax, "anomaly: extreme_min %g is above extreme_max %g", a.ExtremeMin, a.ExtremeMax)
+	check(a.SpikeDelta >= 0, "anomaly.spike_delta: must not be negative")
+	check(a.SpikeRate >= 0, "anomaly.spike_rate: must not be negative")
+
+	check(c.Cache.MaxEntries >= 0, "cache.max_entries: must not be negative")
+	check(c.Cache.TTL >= 0, "cache.ttl: must not be negative")
+	check(c.Cache.MaxDiskBytes >= 0, "cache.max_disk_bytes: must not be negative")
+	check(c.Runs.MaxRuns >= 0, "runs.max_runs: must not be negative")
+	check(c.Runs.MaxAge >= 0, "runs.max_age: must not be negative")
+
+	check(c.Cluster.HeartbeatTimeout > 0, "cluster.heartbeat_timeout: must be positive")
+	check(c.Cluster.RangeTimeout > 0, "cluster.range_timeout: must be positive")
+	check(c.Cluster.MaxAttempts >= 1, "cluster.max_attempts: must be at least 1")
+	check(c.Cluster.RangesPerSlot >= 1, "cluster.ranges_per_slot: must be at least 1")
+	check(!c.Cluster.Coordinator || c.Cluster.Token != "", "cluster.token: required with cluster.coordinator")
+	check(!c.Cluster.SharedPath || c.Cluster.SharedDir != "", "cluster.shared_dir: required with cluster.shared_path")
+	check(c.Cluster.SharedDir == "" || !SameDir(c.Cluster.SharedDir, os.TempDir()),
+		"cluster.shared_dir: must be a dedicated directory, not the temp dir")
+
+	check(c.Health.MinFreeDisk >= 0, "health.min_free_disk: must not be negative")
+	check(c.Health.MemoryLimit >= 0, "health.memory_limit: must not be negative")
+	check(c.Health.MemoryHeadroom >= 0, "health.memory_headroom: must not be negative")
+	check(c.Health.MemoryLimit == 0 || c.Health.MemoryHeadroom < c.Health.MemoryLimit, "health.memory_headroom: must be below memory_limit")
+	check(c.Health.CheckTimeout > 0, "health.check_timeout: must be positive")
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		check(c.Tracing.File != "", "tracing.file: the file exporter needs a file")
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: unknown exporter %q, want otlp, stdout or file", c.Tracing.Exporter))
+	}
+	if _, err := parseHeaders(c.Tracing.Headers); err != nil {
+		errs = append(errs, fmt.Errorf("tracing.headers: %w", err))
+	}
+	if _, err := logging.New(io.Discard, c.Logging.Config()); err != nil {
+		errs = append(errs, fmt.Errorf("logging: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// parseHeaders reads a comma-separated list of key=value pairs.
+func parseHeaders(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", strings.TrimSpace(pair))
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// redacted replaces the value of secret settings.
+const redacted = "[redacted]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Redacted returns the configuration keyed like the YAML file, with the values of secret
+// settings replaced and durations written as text.
+func (c Config) Redacted() map[string]any {
+	return redact(reflect.ValueOf(c))
+}
+
+func redact(v reflect.Value) map[string]any {
+	out := make(map[string]any)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			out[name] = redact(fv)
+		case f.Tag.Get("secret") == "true" && !fv.IsZero():
+			out[name] = redacted
+		case f.Type == durationType:
+			out[name] = time.Duration(fv.Int()).String()
+		default:
+			out[name] = fv.Interface()
+		}
+	}
+	return out
+}
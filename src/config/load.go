@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that points at the YAML file when no -config flag is given.
+const FileEnv = "CONFIG_FILE"
+
+// setting binds one value of Config to an environment variable and a flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value any // pointer into a Config
+}
+
+// settings lists the overridable values of c. The flags are named after the keys of the file.
+func settings(c *Config) []setting {
+	return []setting{
+		{"PORT", "server.port", "port of the HTTP API", &c.Server.Port},
+		{"GRPC_ADDR", "server.grpc_addr", "gRPC address, empty disables gRPC", &c.Server.GRPCAddr},
+		{"ADMIN_ADDR", "server.admin_addr", "address of the pprof and diagnostics routes", &c.Server.AdminAddr},
+		{"PPROF_ENABLED", "server.pprof_enabled", "serve the admin routes on the API port", &c.Server.PprofEnabled},
+		{"SHUTDOWN_TIMEOUT", "server.shutdown_timeout", "time requests in flight get to finish after a stop signal", &c.Server.ShutdownTimeout},
+		{"DRAIN_DELAY", "server.drain_delay", "time health checks fail before shutting down", &c.Server.DrainDelay},
+
+		{"WORKERS", "processing.workers", "decode workers, 0 uses one per CPU", &c.Processing.Workers},
+		{"MEMORY_THRESHOLD", "processing.memory_threshold", "uploads larger than this many bytes are spooled to disk", &c.Processing.MemoryThreshold},
+		{"READ_BUFFER", "processing.read_buffer", "bytes the decoders read at a time", &c.Processing.ReadBuffer},
+		{"LINE_BUFFER", "processing.line_buffer", "bytes anomaly detection reads at a time", &c.Processing.LineBuffer},
+		{"CHANNEL_BUFFER", "processing.channel_buffer", "entries queued between the stages of anomaly detection", &c.Processing.ChannelBuffer},
+		{"MAX_LINE_LENGTH", "processing.max_line_length", "longest accepted line in bytes, 0 accepts any length", &c.Processing.MaxLineLength},
+		{"MEMORY_BUDGET", "processing.memory_budget", "bytes the station table of one decode worker may take, 0 leaves it unbounded", &c.Processing.MemoryBudget},
+		{"BUDGET_POLICY", "processing.budget_policy", "spill or fail, what a table over the memory budget does", &c.Processing.BudgetPolicy},
+		{"TEMP_MAX_AGE", "processing.temp_max_age", "upload files older than this are removed at startup, 0 keeps them", &c.Processing.TempMaxAge},
+
+		{"MAX_RUNS_IN_FLIGHT", "admission.max_runs", "runs processed at once, 0 admits every run", &c.Admission.MaxRuns},
+		{"MAX_QUEUED_RUNS", "admission.max_queue", "runs waiting for a slot before new ones get 429", &c.Admission.MaxQueue},
+		{"QUEUE_TIMEOUT", "admission.queue_timeout", "time a run may wait for a slot", &c.Admission.QueueTimeout},
+		{"DECODE_POOL_SIZE", "admission.pool_size", "decode goroutines shared by all runs, 0 uses one per CPU", &c.Admission.PoolSize},
+
+		{"ANOMALY_EXTREME_MIN", "anomaly.extreme_min", "readings below this °C are extreme", &c.Anomaly.ExtremeMin},
+		{"ANOMALY_EXTREME_MAX", "anomaly.extreme_max", "readings above this °C are extreme", &c.Anomaly.ExtremeMax},
+		{"ANOMALY_SPIKE_DELTA", "anomaly.spike_delta", "largest change in °C between consecutive readings", &c.Anomaly.SpikeDelta},
+		{"ANOMALY_SPIKE_RATE", "anomaly.spike_rate", "largest change in °C per minute, 0 disables the rule", &c.Anomaly.SpikeRate},
+
+		{"CACHE_MAX_ENTRIES", "cache.max_entries", "results kept in memory", &c.Cache.MaxEntries},
+		{"CACHE_TTL", "cache.ttl", "how long a cached result stays valid", &c.Cache.TTL},
+		{"CACHE_DIR", "cache.dir", "directory of the disk cache", &c.Cache.Dir},
+		{"CACHE_MAX_DISK_BYTES", "cache.max_disk_bytes", "size limit of the disk cache", &c.Cache.MaxDiskBytes},
+
+		{"RUNS_DB", "runs.path", "run history database, empty disables it", &c.Runs.Path},
+		{"RUNS_MAX", "runs.max_runs", "runs kept, 0 keeps any number", &c.Runs.MaxRuns},
+		{"RUNS_MAX_AGE", "runs.max_age", "runs older than this are dropped, 0 keeps them", &c.Runs.MaxAge},
+		{"AGGREGATES_DB", "aggregates.path", "named aggregates database, empty disables them", &c.Aggregates.Path},
+
+		{"CLUSTER_COORDINATOR", "cluster.coordinator", "enable the cluster coordinator", &c.Cluster.Coordinator},
+		{"CLUSTER_HEARTBEAT_TIMEOUT", "cluster.heartbeat_timeout", "silence after which a worker counts as dead", &c.Cluster.HeartbeatTimeout},
+		{"CLUSTER_RANGE_TIMEOUT", "cluster.range_timeout", "limit for one range on one worker", &c.Cluster.RangeTimeout},
+		{"CLUSTER_MAX_ATTEMPTS", "cluster.max_attempts", "tries per range", &c.Cluster.MaxAttempts},
+		{"CLUSTER_RANGES_PER_SLOT", "cluster.ranges_per_slot", "ranges per worker slot", &c.Cluster.RangesPerSlot},
+		{"CLUSTER_SHARED_PATH", "cluster.shared_path", "send workers file paths instead of bytes", &c.Cluster.SharedPath},
+		{"CLUSTER_SHARED_DIR", "cluster.shared_dir", "directory shared with the workers, required with shared paths", &c.Cluster.SharedDir},
+		{"CLUSTER_TOKEN", "cluster.token", "secret shared by the coordinator and its workers", &c.Cluster.Token},
+
+		{"MIN_FREE_DISK", "health.min_free_disk", "bytes the temp dir must keep free to be ready, 0 skips the check", &c.Health.MinFreeDisk},
+		{"MEMORY_LIMIT", "health.memory_limit", "bytes the process may use, 0 uses GOMEMLIMIT", &c.Health.MemoryLimit},
+		{"MEMORY_HEADROOM", "health.memory_headroom", "bytes that must stay free below the memory limit to be ready", &c.Health.MemoryHeadroom},
+		{"HEALTH_CHECK_TIMEOUT", "health.check_timeout", "time each readiness check may take", &c.Health.CheckTimeout},
+
+		{"TRACE_EXPORTER", "tracing.exporter", "otlp, stdout or file, empty disables tracing", &c.Tracing.Exporter},
+		{"TRACE_ENDPOINT", "tracing.endpoint", "OTLP/HTTP endpoint", &c.Tracing.Endpoint},
+		{"TRACE_HEADERS", "tracing.headers", "key=value pairs sent with every OTLP export", &c.Tracing.Headers},
+		{"TRACE_FILE", "tracing.file", "output of the file exporter", &c.Tracing.File},
+		{"OTEL_SERVICE_NAME", "tracing.service_name", "service name of the spans", &c.Tracing.ServiceName},
+
+		{"LOG_LEVEL", "logging.level", "debug, info, warn or error", &c.Logging.Level},
+		{"LOG_FORMAT", "logging.format", "json or text", &c.Logging.Format},
+	}
+}
+
+// set parses s into the value the setting points at.
+func (s setting) set(v string) error {
+	var err error
+	switch p := s.value.(type) {
+	case *string:
+		*p = v
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *int64:
+		*p, err = strconv.ParseInt(v, 10, 64)
+	case *float32:
+		var f float64
+		f, err = strconv.ParseFloat(v, 32)
+		*p = float32(f)
+	case *time.Duration:
+		*p, err = time.ParseDuration(v)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T", s.value))
+	}
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok {
+			err = ne.Err
+		}
+		return fmt.Errorf("invalid value %q: %w", v, err)
+	}
+	return nil
+}
+
+// Load returns the configuration of the server. It starts from Default, then reads the YAML
+// file named by the -config flag or CONFIG_FILE, then the environment through lookupEnv,
+// then the flags in args. Settings that are out of range fail validation.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	table := settings(&cfg)
+
+	// Flags are parsed first to find the file, and applied last
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", "", "YAML configuration file")
+	type override struct {
+		setting
+		raw string
+	}
+	var flagged []override
+	for _, s := range table {
+		fs.Func(s.flag, s.usage+" ($"+s.env+")", func(v string) error {
+			flagged = append(flagged, override{s, v})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv(FileEnv)
+	}
+	if *path != "" {
+		if err := readFile(*path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	var errs []error
+	for _, s := range table {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	for _, o := range flagged {
+		if err := o.set(o.raw); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", o.flag, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// readFile reads the YAML file at path over cfg. Unknown keys are errors, so typos do not
+// pass silently.
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Usage writes the flags and the environment variables they override to w.
+func Usage(w io.Writer) {
+	var cfg Config
+	fmt.Fprintf(w, "  -config string\n    \tYAML configuration file ($%s)\n", FileEnv)
+	for _, s := range settings(&cfg) {
+		fmt.Fprintf(w, "  -%s\n    \t%s ($%s)\n", s.flag, s.usage, s.env)
+	}
+}
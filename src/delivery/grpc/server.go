@@ -0,0 +1,325 @@
+// Package grpc serves the aggregation and anomaly detection of ProcessService over gRPC,
+// next to the Gin HTTP API.
+package grpc
+
+import (
+	"1brc-challange/admission"
+	"1brc-challange/brcpb"
+	"1brc-challange/logging"
+	"1brc-challange/models"
+	"1brc-challange/services"
+	"1brc-challange/tracing"
+	"1brc-challange/utilities"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements brcpb.BRCServer on top of a ProcessService.
+type Server struct {
+	brcpb.UnimplementedBRCServer
+	ProcessService services.ProcessService
+}
+
+// NewServer returns a gRPC server with the BRC service registered. Calls continue the
+// trace of incoming W3C traceparent metadata and carry the ID of x-request-id metadata.
+func NewServer(ps services.ProcessService, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{grpc.ChainStreamInterceptor(requestIDStream, traceStream, logStream)}, opts...)
+	s := grpc.NewServer(opts...)
+	brcpb.RegisterBRCServer(s, &Server{ProcessService: ps})
+	return s
+}
+
+// Aggregate aggregates the streamed file like the HTTP endpoint. The service spools the
+// chunks once, into the directory it decodes uploads from.
+func (s *Server) Aggregate(stream brcpb.BRC_AggregateServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	opts, err := processOptions(first.GetOptions(), nil)
+	if err != nil {
+		return err
+	}
+	input := streamReader(first.GetChunk(), func() ([]byte, error) {
+		msg, err := stream.Recv()
+		return msg.GetChunk(), err
+	})
+	defer input.Close()
+
+	result, err := s.ProcessService.AggregateStream(stream.Context(), input, streamName(first.GetFilename()), opts)
+	if err != nil {
+		return processError(err)
+	}
+	resp := &brcpb.AggregateResponse{
+		Stations:    stationStats(result.Stations),
+		InputSha256: result.InputHash,
+		Cached:      result.Cached,
+		RunId:       result.RunID,
+	}
+	if opts.Time.Bucket > 0 {
+		resp.Bucket = opts.Time.Bucket.String()
+	}
+	return stream.SendAndClose(resp)
+}
+
+// DetectAnomalies detects anomalies in the chunks as they arrive and sends every anomaly
+// back as soon as the detector reports it, while the client may still be sending. The
+// run ID is sent as the `run-id` trailer.
+func (s *Server) DetectAnomalies(stream brcpb.BRC_DetectAnomaliesServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	opts, err := processOptions(first.GetOptions(), first.GetRules())
+	if err != nil {
+		return err
+	}
+	input := streamReader(first.GetChunk(), func() ([]byte, error) {
+		msg, err := stream.Recv()
+		return msg.GetChunk(), err
+	})
+	defer input.Close()
+
+	result, err := s.ProcessService.StreamAnomalies(stream.Context(), input, streamName(first.GetFilename()), opts, func(a *models.Anomaly) error {
+		msg := &brcpb.Anomaly{Station: a.Station, Temp: a.Temp, Reason: a.Reason}
+		if a.Time != nil {
+			msg.Time = timestamppb.New(*a.Time)
+		}
+		return stream.Send(msg)
+	})
+	if err != nil {
+		return processError(err)
+	}
+	if result.RunID != "" {
+		stream.SetTrailer(metadata.Pairs("run-id", result.RunID))
+	}
+	return nil
+}
+
+// requestIDStream gives every call the ID of its x-request-id metadata, or a new one, and
+// answers it in the header metadata.
+func requestIDStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	id := logging.RequestIDFrom(metadataCarrier(md).Get(strings.ToLower(logging.RequestIDHeader)))
+	ss.SetHeader(metadata.Pairs(strings.ToLower(logging.RequestIDHeader), id))
+	return handler(srv, &contextStream{ServerStream: ss, ctx: logging.WithRequestID(ss.Context(), id)})
+}
+
+// AdmitStream returns an interceptor that holds every call back until c has a slot for
+// it, before the upload is streamed. Calls turned away fail with RESOURCE_EXHAUSTED and
+// a `retry-after` header in seconds. Admitted calls decode on the shared pool of c.
+func AdmitStream(c *admission.Controller) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := c.Admit(ss.Context())
+		if errors.Is(err, admission.ErrSaturated) {
+			ss.SetHeader(metadata.Pairs("retry-after", strconv.Itoa(int(c.RetryAfter().Seconds()))))
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+		defer release()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: admission.WithPool(ss.Context(), c.Pool())})
+	}
+}
+
+// logStream logs every call once it is over.
+func logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	level := slog.LevelInfo
+	switch status.Code(err) {
+	case codes.OK, codes.Canceled, codes.InvalidArgument:
+	case codes.ResourceExhausted:
+		level = slog.LevelWarn
+	default:
+		level = slog.LevelError
+	}
+	slog.Log(ss.Context(), level, "rpc", "method", info.FullMethod, "code", status.Code(err).String(),
+		"duration_ms", time.Since(start).Milliseconds())
+	return err
+}
+
+// traceStream runs every streaming call in a server span.
+func traceStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	ctx := tracing.Extract(ss.Context(), metadataCarrier(md))
+	ctx, span := tracing.StartServer(ctx, info.FullMethod, attribute.String("rpc.system", "grpc"), attribute.String("rpc.method", info.FullMethod))
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", status.Code(err).String()))
+	tracing.End(span, err)
+	return err
+}
+
+// contextStream is a server stream with the context of an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// metadataCarrier reads and writes trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (mc metadataCarrier) Get(key string) string {
+	if v := metadata.MD(mc).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (mc metadataCarrier) Set(key, value string) { metadata.MD(mc).Set(key, value) }
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// streamReader returns a reader of the first chunk and every chunk returned by recv,
+// fed through a pipe until the client closes its side of the stream. A stream without
+// any content fails with InvalidArgument. Closing the reader stops the feeding.
+func streamReader(first []byte, recv func() ([]byte, error)) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		var total int64
+		chunk := first
+		for {
+			// An empty write would hand the reader an empty read, which some take for the end
+			if len(chunk) > 0 {
+				n, err := pw.Write(chunk)
+				if err != nil {
+					return
+				}
+				total += int64(n)
+			}
+			var err error
+			if chunk, err = recv(); errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if total == 0 {
+			pw.CloseWithError(status.Error(codes.InvalidArgument, "the stream carried no file content"))
+			return
+		}
+		pw.Close()
+	}()
+	return pr
+}
+
+// streamName is the name a streamed file is recorded under.
+func streamName(filename string) string {
+	if filename == "" {
+		return "stream"
+	}
+	return filename
+}
+
+// processOptions maps the request options onto the HTTP parameter names and parses them
+// like the HTTP API does, so both APIs accept the same values.
+func processOptions(in *brcpb.InputOptions, rules *brcpb.AnomalyRules) (models.ProcessOptions, error) {
+	params := map[string]string{
+		"input_format":     in.GetInputFormat(),
+		"delimiter":        in.GetDelimiter(),
+		"quote":            in.GetQuote(),
+		"comment":          in.GetComment(),
+		"station_column":   in.GetStationColumn(),
+		"value_column":     in.GetValueColumn(),
+		"timestamp_column": in.GetTimestampColumn(),
+		"station_field":    in.GetStationField(),
+		"value_field":      in.GetValueField(),
+		"timestamp_field":  in.GetTimestampField(),
+		"empty_policy":     in.GetEmptyPolicy(),
+		"nan_policy":       in.GetNanPolicy(),
+		"time_format":      in.GetTimeFormat(),
+		"bucket":           in.GetBucket(),
+		"timezone":         in.GetTimezone(),
+	}
+	if in.GetHeader() {
+		params["header"] = "true"
+	}
+	if rules == nil {
+		rules = &brcpb.AnomalyRules{}
+	}
+	for key, v := range map[string]*float32{
+		"extreme_min": rules.ExtremeMin,
+		"extreme_max": rules.ExtremeMax,
+		"spike_delta": rules.SpikeDelta,
+		"spike_rate":  rules.SpikeRate,
+	} {
+		if v != nil {
+			params[key] = strconv.FormatFloat(float64(*v), 'g', -1, 32)
+		}
+	}
+	opts, err := services.ParseProcessOptions(func(key string) string { return params[key] })
+	if err != nil {
+		return opts, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return opts, nil
+}
+
+// stationStats converts merged results into messages ordered by station, then bucket start.
+func stationStats(stats map[string]*models.TempStat) []*brcpb.StationStats {
+	out := make([]*brcpb.StationStats, 0, len(stats))
+	for key, stat := range stats {
+		if stat.Count == 0 {
+			continue
+		}
+		name, start, bucketed := utilities.SplitBucketKey(key)
+		msg := &brcpb.StationStats{
+			Station: name,
+			Mean:    float64(stat.Sum) / float64(stat.Count),
+			Min:     float64(stat.Min),
+			Max:     float64(stat.Max),
+			Count:   int64(stat.Count),
+			Sum:     float64(stat.Sum),
+		}
+		if bucketed {
+			msg.Start = timestamppb.New(start)
+		}
+		out = append(out, msg)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Station != out[j].Station {
+			return out[i].Station < out[j].Station
+		}
+		return out[i].GetStart().AsTime().Before(out[j].GetStart().AsTime())
+	})
+	return out
+}
+
+// processError maps a processing error onto a gRPC status, like respondProcessError does for HTTP.
+func processError(err error) error {
+	// Errors of the stream itself, such as a failed Recv or a send to a gone client, pass as they are
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return err
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidOptions), errors.Is(err, utilities.ErrBadInput):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, "failed to process file")
+	}
+}
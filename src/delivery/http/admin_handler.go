@@ -0,0 +1,131 @@
+package http
+
+import (
+	"1brc-challange/logging"
+	"1brc-challange/models"
+	"1brc-challange/services"
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// started is when the process came up, for the uptime of the runtime diagnostics.
+var started = time.Now()
+
+// ProfileRun processes one uploaded file like the aggregate endpoint, or the anomaly endpoint
+// with `operation=anomaly`, while a CPU profile is taken, and answers with the profile. The
+// result cache is bypassed so the run decodes its input. Samples of the run are labelled
+// with its `request_id` and `operation`, as other requests may run at the same time.
+// Only one CPU profile can be taken at once; a second one is answered with 409.
+func (ch *ClientHandler) ProfileRun(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
+		return
+	}
+	defer file.Close()
+
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	operation := param(c, "operation")
+	switch operation {
+	case "":
+		operation = "aggregate"
+	case "aggregate", "anomaly":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("operation: unknown operation %q, want aggregate or anomaly", operation)})
+		return
+	}
+
+	var profile bytes.Buffer
+	if err := pprof.StartCPUProfile(&profile); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A CPU profile is already being taken"})
+		return
+	}
+	var runID string
+	ctx := services.WithoutCache(c.Request.Context())
+	labels := pprof.Labels("request_id", logging.RequestID(ctx), "operation", operation)
+	pprof.Do(ctx, labels, func(ctx context.Context) {
+		if operation == "anomaly" {
+			var result *models.AnomalyResult
+			if result, err = ch.ProcessService.AnomalyDetection(ctx, file, header, opts); err == nil {
+				runID = result.RunID
+			}
+			return
+		}
+		var result *models.AggregateResult
+		if result, err = ch.ProcessService.OneBillionRowChallange(ctx, file, header, opts); err == nil {
+			runID = result.RunID
+		}
+	})
+	pprof.StopCPUProfile()
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)) + "-cpu.pprof"
+	if runID != "" {
+		c.Header("X-Run-ID", runID)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Data(http.StatusOK, "application/octet-stream", profile.Bytes())
+}
+
+// RuntimeStats reports the Go runtime, memory and build information of the process.
+func (ch *ClientHandler) RuntimeStats(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	body := gin.H{
+		"go_version":     runtime.Version(),
+		"os":             runtime.GOOS,
+		"arch":           runtime.GOARCH,
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime_seconds": time.Since(started).Seconds(),
+		"memory": gin.H{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"num_gc":            mem.NumGC,
+			"gc_pause_total_ns": mem.PauseTotalNs,
+		},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build := gin.H{"path": info.Main.Path, "version": info.Main.Version}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				build[strings.TrimPrefix(s.Key, "vcs.")] = s.Value
+			}
+		}
+		body["build"] = build
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// ShowConfig answers the effective configuration, keyed like the configuration file,
+// with secrets redacted.
+func (ch *ClientHandler) ShowConfig(c *gin.Context) {
+	if ch.Config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No configuration was loaded"})
+		return
+	}
+	c.JSON(http.StatusOK, ch.Config.Redacted())
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"1brc-challange/admission"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admit holds a run back until admission control has a slot for it, before its upload is
+// read. Runs turned away get 429 with a Retry-After header. Admitted runs decode on the
+// shared pool.
+func (ch *ClientHandler) Admit(c *gin.Context) {
+	release, err := ch.Admission.Admit(c.Request.Context())
+	if errors.Is(err, admission.ErrSaturated) {
+		slog.WarnContext(c.Request.Context(), "run turned away", "error", err)
+		c.Header("Retry-After", strconv.Itoa(int(ch.Admission.RetryAfter().Seconds())))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondProcessError(c, err)
+		c.Abort()
+		return
+	}
+	defer release()
+	c.Request = c.Request.WithContext(admission.WithPool(c.Request.Context(), ch.Admission.Pool()))
+	c.Next()
+}
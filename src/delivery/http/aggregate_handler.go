@@ -0,0 +1,209 @@
+package http
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppendAggregate decodes an uploaded file and merges it into a named running aggregate,
+// creating the aggregate on first use. It accepts the input options of the aggregate
+// endpoint; an upload whose content was already appended is refused unless `force=true`.
+func (ch *ClientHandler) AppendAggregate(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	name := c.Param("name")
+	if !store.ValidAggregateName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": store.ErrInvalidName.Error()})
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
+		return
+	}
+	defer file.Close()
+
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	force := param(c, "force") == "true"
+	if !force {
+		// Refuse content that was already appended before it is decoded and recorded as a run
+		hash, err := utilities.HashContent(io.NewSectionReader(file, 0, header.Size))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+			return
+		}
+		if err := ch.Aggregates.CheckInput(name, hash); err != nil {
+			respondAggregateError(c, err)
+			return
+		}
+	}
+
+	result, err := ch.ProcessService.OneBillionRowChallange(c.Request.Context(), file, header, opts)
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+	var bucket string
+	if opts.Time.Bucket > 0 {
+		bucket = opts.Time.Bucket.String()
+	}
+	info, err := ch.Aggregates.Append(name, result.InputHash, header.Filename, bucket, result.Stations, force)
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"aggregate": info,
+		"run_id":    result.RunID,
+		"message":   "File appended successfully",
+	})
+}
+
+// ListAggregates returns every named aggregate.
+func (ch *ClientHandler) ListAggregates(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	list, err := ch.Aggregates.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read aggregates"})
+		return
+	}
+	if list == nil {
+		list = []models.AggregateInfo{}
+	}
+	c.JSON(http.StatusOK, gin.H{"aggregates": list})
+}
+
+// GetAggregate returns the current state of a named aggregate. The result parameters of
+// the aggregate endpoint apply, and `timezone` sets the zone bucket starts are shown in.
+func (ch *ClientHandler) GetAggregate(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	info, stats, err := ch.Aggregates.Get(c.Param("name"))
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	ch.respondAggregate(c, gin.H{"aggregate": info}, stats)
+}
+
+// DeleteAggregate removes a named aggregate and its snapshots.
+func (ch *ClientHandler) DeleteAggregate(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	if err := ch.Aggregates.Delete(c.Param("name")); err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Aggregate deleted"})
+}
+
+// CreateSnapshot freezes the current state of a named aggregate.
+func (ch *ClientHandler) CreateSnapshot(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	snap, err := ch.Aggregates.Snapshot(c.Param("name"))
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"snapshot": snap})
+}
+
+// ListSnapshots returns the snapshots of a named aggregate, oldest first.
+func (ch *ClientHandler) ListSnapshots(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	list, err := ch.Aggregates.Snapshots(c.Param("name"))
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": list})
+}
+
+// GetSnapshot returns the state of a named aggregate frozen in a snapshot.
+func (ch *ClientHandler) GetSnapshot(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	snap, stats, err := ch.Aggregates.GetSnapshot(c.Param("name"), c.Param("id"))
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	ch.respondAggregate(c, gin.H{"snapshot": snap}, stats)
+}
+
+// RestoreSnapshot replaces the current state of a named aggregate with a snapshot.
+func (ch *ClientHandler) RestoreSnapshot(c *gin.Context) {
+	if !ch.requireAggregates(c) {
+		return
+	}
+	info, err := ch.Aggregates.Restore(c.Param("name"), c.Param("id"))
+	if err != nil {
+		respondAggregateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"aggregate": info,
+		"message":   "Snapshot restored",
+	})
+}
+
+// respondAggregate adds the rows of a stored aggregate to body and sends it.
+func (ch *ClientHandler) respondAggregate(c *gin.Context, body gin.H, stats map[string]*models.TempStat) {
+	loc, err := timezoneParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, total, ok := resultRows(c, stats, loc)
+	if !ok {
+		return
+	}
+	body["result"] = rows
+	body["total"] = total
+	c.JSON(http.StatusOK, body)
+}
+
+// requireAggregates answers 404 when named aggregates are disabled.
+func (ch *ClientHandler) requireAggregates(c *gin.Context) bool {
+	if ch.Aggregates == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Named aggregates are disabled"})
+		return false
+	}
+	return true
+}
+
+// respondAggregateError maps an aggregate store error onto an HTTP status.
+func respondAggregateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrAggregateNotFound), errors.Is(err, store.ErrSnapshotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrDuplicateInput):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error() + "; append with force=true to count it again"})
+	case errors.Is(err, store.ErrBucketMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrInvalidName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update aggregate"})
+	}
+}
@@ -1,8 +1,26 @@
 package http
 
 import (
+	"1brc-challange/admission"
+	"1brc-challange/cache"
+	"1brc-challange/cluster"
+	"1brc-challange/config"
+	"1brc-challange/health"
+	"1brc-challange/metrics"
+	"1brc-challange/models"
 	"1brc-challange/services"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"context"
+	"errors"
+	"mime"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,12 +28,28 @@ import (
 type ClientHandler struct {
 	NumCPU         int
 	ProcessService services.ProcessService
+	Cache          *cache.ResultCache
+	Runs           *store.RunStore
+	Aggregates     *store.AggregateStore
+	Cluster        *cluster.Coordinator
+	Config         *config.Config // effective configuration, shown on the admin routes
+	Readiness      *health.Checker
+	Admission      *admission.Controller // nil admits every run
+
+	draining atomic.Bool
+	unready  atomic.Bool // outcome of the last readiness probe
 }
 
-func NewClientHandler(numCPU int) *ClientHandler {
+// NewClientHandler wires the handlers to a process service. resultCache, runs, aggregates
+// and coordinator may be nil to disable the matching features.
+func NewClientHandler(numCPU int, resultCache *cache.ResultCache, runs *store.RunStore, aggregates *store.AggregateStore, coordinator *cluster.Coordinator) *ClientHandler {
 	return &ClientHandler{
 		NumCPU:         numCPU,
-		ProcessService: services.NewProcessService(numCPU),
+		ProcessService: services.NewProcessService(numCPU, resultCache, runs, coordinator),
+		Cache:          resultCache,
+		Runs:           runs,
+		Aggregates:     aggregates,
+		Cluster:        coordinator,
 	}
 }
 
@@ -27,46 +61,212 @@ func (ch *ClientHandler) OneBillionRowChallange(c *gin.Context) {
 	}
 	defer file.Close()
 
-	result, err := ch.ProcessService.OneBillionRowChallange(file, header)
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resultOpts, err := parseResultOptions(c, opts.Time.Location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enc, err := resultEncoder(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	run, err := ch.ProcessService.OneBillionRowChallange(c.Request.Context(), file, header, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+		respondProcessError(c, err)
+		return
+	}
+	defer metrics.ObserveStage(metrics.StageEncode, time.Now())
+	if run.Cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	c.Header("X-Content-SHA256", run.InputHash)
+	if run.RunID != "" {
+		c.Header("X-Run-ID", run.RunID)
+	}
+	result := run.Stations
+	if enc != nil || hasResultQuery(c) {
+		rows := utilities.BuildRows(result, resultOpts)
+		total := len(rows)
+		c.Header("X-Total-Count", strconv.Itoa(total))
+		rows = utilities.PageRows(rows, resultOpts)
+		if enc != nil {
+			writeResult(c, enc, header.Filename, rows, resultOpts)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"result":  rows,
+			"total":   total,
+			"run_id":  run.RunID,
+			"num_cpu": ch.NumCPU,
+			"message": "File processed successfully",
+		})
+		return
+	}
+	if opts.Time.Bucket > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"result":  utilities.GroupBuckets(result, opts.Time.Location),
+			"bucket":  opts.Time.Bucket.String(),
+			"run_id":  run.RunID,
+			"num_cpu": ch.NumCPU,
+			"message": "File processed successfully",
+		})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"result":  result,
+		"run_id":  run.RunID,
 		"num_cpu": ch.NumCPU,
 		"message": "File processed successfully",
 	})
 }
 
+// writeResult streams the rows with the chosen encoder. Spreadsheet formats are sent as downloads.
+func writeResult(c *gin.Context, enc utilities.ResultEncoder, filename string, rows []models.StationResult, opts models.ResultOptions) {
+	c.Header("Content-Type", enc.ContentType()+"; charset=utf-8")
+	c.Header("Vary", "Accept")
+	switch enc.Extension() {
+	case "csv", "tsv":
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)) + "-result." + enc.Extension()
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	c.Status(http.StatusOK)
+	// Headers are already out, so an encoding error can only abort the connection
+	if err := enc.Encode(c.Writer, rows, opts); err != nil {
+		c.Error(err)
+		c.Abort()
+	}
+}
+
 func (ch *ClientHandler) AnomalyDetection(c *gin.Context) {
-	file, _, err := c.Request.FormFile("file")
+	file, header, err := c.Request.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
 		return
 	}
 	defer file.Close()
 
-	result, err := ch.ProcessService.AnomalyDetection(file)
+	opts, err := parseProcessOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+
+	result, err := ch.ProcessService.AnomalyDetection(c.Request.Context(), file, header, opts)
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+	defer metrics.ObserveStage(metrics.StageEncode, time.Now())
+	if result.RunID != "" {
+		c.Header("X-Run-ID", result.RunID)
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"result":  result,
+		"result":  result.Anomalies,
+		"run_id":  result.RunID,
 		"num_cpu": ch.NumCPU,
 		"message": "Anomaly detection completed successfully",
 	})
 }
 
+// Ingest converts an uploaded text or NDJSON file into the columnar format and returns it as a download.
+func (ch *ClientHandler) Ingest(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
+		return
+	}
+	defer file.Close()
+
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Encode into a temp file first so that a decode error can still be reported as JSON
+	out, err := os.CreateTemp("", "ingest-*.brc")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create output file"})
+		return
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	rows, err := ch.ProcessService.Ingest(c.Request.Context(), file, header, opts, out)
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)) + ".brc"
+	c.Header("X-Row-Count", strconv.FormatInt(rows, 10))
+	c.FileAttachment(out.Name(), name)
+}
+
+// PurgeCache drops every cached aggregation result.
+func (ch *ClientHandler) PurgeCache(c *gin.Context) {
+	memory, disk, err := ch.Cache.Purge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge cache"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"purged_memory": memory,
+		"purged_disk":   disk,
+		"message":       "Cache purged",
+	})
+}
+
 func (ch *ClientHandler) GetNumCPU(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"num_cpu": ch.NumCPU,
 	})
 }
 
+// HealthCheck reports whether the server takes uploads; it fails while the server drains.
 func (ch *ClientHandler) HealthCheck(c *gin.Context) {
+	if ch.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
 	c.JSON(200, gin.H{
 		"status": "ok",
 	})
 }
+
+// StartDraining makes the health check fail, so load balancers stop sending new uploads
+// while the requests in flight finish.
+func (ch *ClientHandler) StartDraining() {
+	ch.draining.Store(true)
+}
+
+// Draining reports whether the server is shutting down.
+func (ch *ClientHandler) Draining() bool {
+	return ch.draining.Load()
+}
+
+// respondProcessError maps a processing error onto an HTTP status.
+func respondProcessError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, utilities.ErrBadInput) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	// Runs are cancelled when the client goes away or the server stops draining
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Processing was cancelled"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"1brc-challange/cluster"
+	"1brc-challange/models"
+	"1brc-challange/tracing"
+	"1brc-challange/utilities"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RegisterWorker adds a worker process to the cluster. The body is a cluster.Registration.
+func (ch *ClientHandler) RegisterWorker(c *gin.Context) {
+	if !ch.requireCluster(c) {
+		return
+	}
+	var reg cluster.Registration
+	if err := c.ShouldBindJSON(&reg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid registration: " + err.Error()})
+		return
+	}
+	assigned, err := ch.Cluster.Register(reg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, assigned)
+}
+
+// WorkerHeartbeat records that a worker is alive.
+func (ch *ClientHandler) WorkerHeartbeat(c *gin.Context) {
+	if !ch.requireCluster(c) {
+		return
+	}
+	if err := ch.Cluster.Heartbeat(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveWorker takes a worker out of the cluster; its ranges in flight are reassigned.
+func (ch *ClientHandler) RemoveWorker(c *gin.Context) {
+	if !ch.requireCluster(c) {
+		return
+	}
+	if err := ch.Cluster.Remove(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Worker removed"})
+}
+
+// ListWorkers returns the registered workers with their load and health.
+func (ch *ClientHandler) ListWorkers(c *gin.Context) {
+	if !ch.requireCluster(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"workers": ch.Cluster.Workers(),
+		"slots":   ch.Cluster.Slots(),
+	})
+}
+
+// requireCluster answers 404 when the process does not coordinate a cluster, and 401
+// when the request does not carry the cluster token.
+func (ch *ClientHandler) requireCluster(c *gin.Context) bool {
+	if ch.Cluster == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster mode is disabled"})
+		return false
+	}
+	if !ch.Cluster.Authorized(c.Request) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid cluster token"})
+		return false
+	}
+	return true
+}
+
+// WorkerHandler serves the endpoints of a cluster worker process.
+type WorkerHandler struct {
+	// SharedDir is the only directory a coordinator may point the worker at by path. It
+	// is empty when the worker only accepts the bytes of its ranges.
+	SharedDir string
+	// Token is the cluster token the coordinator must present.
+	Token string
+}
+
+// DecodeRange decodes one byte range sent by the coordinator and answers with a binary
+// partials file. Query parameters are `offset`, `size`, the JSON `spec` of the decoder
+// settings and, when coordinator and worker share a file system, `path`; without a path
+// the request body holds the bytes of the range.
+func (wh *WorkerHandler) DecodeRange(c *gin.Context) {
+	if !cluster.Authorized(c.Request, wh.Token) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid cluster token"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset: must be a non-negative integer"})
+		return
+	}
+	size, err := strconv.ParseInt(c.Query("size"), 10, 64)
+	if err != nil || size < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size: must be a non-negative integer"})
+		return
+	}
+	var spec cluster.DecodeSpec
+	if err := json.Unmarshal([]byte(c.Query("spec")), &spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "spec: " + err.Error()})
+		return
+	}
+	path := c.Query("path")
+	if path != "" && !wh.allowed(path) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "path: outside the shared directory"})
+		return
+	}
+
+	_, span := tracing.Start(c.Request.Context(), "decode_part",
+		attribute.Int64("part.offset", offset), attribute.Int64("part.size", size))
+	stats, err := cluster.DecodeRange(spec, path, offset, size, c.Request.Body)
+	tracing.End(span, err)
+	if err != nil {
+		if errors.Is(err, utilities.ErrBadInput) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	set := &models.PartialSet{Version: utilities.PartialsVersion, Partials: []models.Partial{{Stations: stats}}}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+	if err := utilities.EncodePartials(c.Writer, set, utilities.PartialsBinary); err != nil {
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// HealthCheck reports that the worker process is up.
+func (wh *WorkerHandler) HealthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "role": "worker"})
+}
+
+// allowed reports whether path lies inside the shared directory.
+func (wh *WorkerHandler) allowed(path string) bool {
+	if wh.SharedDir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(wh.SharedDir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compareSide describes where one side of a comparison came from.
+type compareSide struct {
+	Source    string // "upload" or "run"
+	Name      string `json:",omitempty"`
+	InputHash string `json:",omitempty"`
+	RunID     string `json:",omitempty"`
+}
+
+// errCompareInput marks a request that does not describe both sides of a comparison.
+var errCompareInput = errors.New("invalid comparison input")
+
+// compareUnsupportedParams are result parameters that compare does not apply: its lists
+// are always ordered by station and returned whole, and it only answers JSON.
+var compareUnsupportedParams = []string{"sort", "order", "limit", "offset", "hottest", "coldest", "format"}
+
+// Compare diffs two datasets per station. Each side is either an uploaded file in the
+// `base` / `target` form fields or a stored run given as `base_run` / `target_run`.
+// Uploads accept the input options of the aggregate endpoint, and `decimals`,
+// `include`, `exclude`, `prefix`, `match` and `movers` shape the comparison. Ordering,
+// paging and output format parameters are rejected rather than ignored.
+func (ch *ClientHandler) Compare(c *gin.Context) {
+	for _, key := range compareUnsupportedParams {
+		if param(c, key) != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: not supported by compare", key)})
+			return
+		}
+	}
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resultOpts, err := parseResultOptions(c, opts.Time.Location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	movers := utilities.DefaultMovers
+	if param(c, "movers") != "" {
+		if movers, err = parseCount(c, "movers"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	base, baseInfo, err := ch.loadCompareSide(c, "base", opts)
+	if err != nil {
+		respondCompareError(c, err)
+		return
+	}
+	target, targetInfo, err := ch.loadCompareSide(c, "target", opts)
+	if err != nil {
+		respondCompareError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"base":       baseInfo,
+		"target":     targetInfo,
+		"comparison": utilities.CompareResults(base, target, resultOpts, movers),
+		"message":    "Comparison completed successfully",
+	})
+}
+
+// loadCompareSide aggregates the uploaded file of one side or loads its stored run.
+func (ch *ClientHandler) loadCompareSide(c *gin.Context, side string, opts models.ProcessOptions) (map[string]*models.TempStat, compareSide, error) {
+	if file, header, err := c.Request.FormFile(side); err == nil {
+		defer file.Close()
+		result, err := ch.ProcessService.OneBillionRowChallange(c.Request.Context(), file, header, opts)
+		if err != nil {
+			return nil, compareSide{}, fmt.Errorf("%s: %w", side, err)
+		}
+		return result.Stations, compareSide{Source: "upload", Name: header.Filename, InputHash: result.InputHash, RunID: result.RunID}, nil
+	}
+
+	id := param(c, side+"_run")
+	if id == "" {
+		return nil, compareSide{}, fmt.Errorf("%w: upload a %s file or give %s_run", errCompareInput, side, side)
+	}
+	if ch.Runs == nil {
+		return nil, compareSide{}, fmt.Errorf("%w: run history is disabled", errCompareInput)
+	}
+	run, stats, _, err := ch.Runs.Get(id)
+	if err != nil {
+		return nil, compareSide{}, fmt.Errorf("%s_run: %w", side, err)
+	}
+	if run.Kind != models.RunAggregate {
+		return nil, compareSide{}, fmt.Errorf("%w: %s_run %s is not an aggregation run", errCompareInput, side, id)
+	}
+	return stats, compareSide{Source: "run", Name: run.InputName, InputHash: run.InputHash, RunID: run.ID}, nil
+}
+
+// respondCompareError maps comparison errors onto HTTP statuses, deferring to the
+// processing and run store mappings for errors from either side.
+func respondCompareError(c *gin.Context, err error) {
+	if errors.Is(err, errCompareInput) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrRunNotFound) {
+		respondRunError(c, err)
+		return
+	}
+	respondProcessError(c, err)
+}
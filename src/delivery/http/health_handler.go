@@ -0,0 +1,50 @@
+package http
+
+import (
+	"1brc-challange/health"
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Livez reports that the process serves requests. It stays up while the server drains,
+// so an orchestrator does not kill runs that are finishing.
+func (ch *ClientHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK, "uptime_seconds": time.Since(started).Seconds()})
+}
+
+// Readyz runs the readiness checks and answers 503 with the result of every check when
+// one of them fails or the server drains, so no new uploads are routed here.
+func (ch *ClientHandler) Readyz(c *gin.Context) {
+	report := ch.Readiness.Run(c.Request.Context())
+	if ch.Draining() {
+		report.Status = health.StatusFail
+		report.Checks["draining"] = health.Result{Status: health.StatusFail, Error: "the server is shutting down"}
+	}
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	if ch.unready.Swap(!report.Ready()) != !report.Ready() {
+		logReadiness(c.Request.Context(), report)
+	}
+	c.JSON(status, report)
+}
+
+// logReadiness logs a change of readiness with the checks that failed.
+func logReadiness(ctx context.Context, report health.Report) {
+	if report.Ready() {
+		slog.InfoContext(ctx, "ready")
+		return
+	}
+	failed := make(map[string]string)
+	for name, result := range report.Checks {
+		if result.Status == health.StatusFail {
+			failed[name] = result.Error
+		}
+	}
+	slog.WarnContext(ctx, "not ready", "failed", failed)
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/services"
+	"1brc-challange/utilities"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// param reads a request option from the query string, falling back to the multipart form.
+func param(c *gin.Context, key string) string {
+	if v, ok := c.GetQuery(key); ok {
+		return v
+	}
+	return c.PostForm(key)
+}
+
+// parseProcessOptions builds the processing options from the request parameters.
+func parseProcessOptions(c *gin.Context) (models.ProcessOptions, error) {
+	return services.ParseProcessOptions(func(key string) string { return param(c, key) })
+}
+
+// resultQueryParams are the parameters that select, order or page result rows.
+var resultQueryParams = []string{"sort", "order", "include", "exclude", "prefix", "match", "limit", "offset", "hottest", "coldest"}
+
+// hasResultQuery reports whether the request asks for filtered, sorted or paged rows.
+func hasResultQuery(c *gin.Context) bool {
+	for _, key := range resultQueryParams {
+		if param(c, key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseResultOptions reads the rounding, filtering, ordering and paging options for rendered results.
+func parseResultOptions(c *gin.Context, loc *time.Location) (models.ResultOptions, error) {
+	opts := utilities.DefaultResultOptions
+	opts.Location = loc
+	var err error
+	if v := param(c, "decimals"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 6 {
+			return opts, fmt.Errorf("decimals: must be an integer between 0 and 6")
+		}
+		opts.Decimals = n
+	}
+
+	if v := strings.ToLower(param(c, "sort")); v != "" {
+		if v == "station" {
+			v = string(models.SortName)
+		}
+		opts.Sort = models.ResultSort(v)
+		if !utilities.ValidResultSort(opts.Sort) {
+			return opts, fmt.Errorf("sort: must be one of name, mean, min, max, count, range")
+		}
+	}
+	switch v := strings.ToLower(param(c, "order")); v {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("order: must be asc or desc")
+	}
+
+	opts.Include = paramList(c, "include")
+	opts.Exclude = paramList(c, "exclude")
+	opts.Prefix = param(c, "prefix")
+	if v := param(c, "match"); v != "" {
+		if opts.Match, err = regexp.Compile(v); err != nil {
+			return opts, fmt.Errorf("match: %v", err)
+		}
+	}
+
+	if opts.Offset, err = parseCount(c, "offset"); err != nil {
+		return opts, err
+	}
+	if opts.Limit, err = parseCount(c, "limit"); err != nil {
+		return opts, err
+	}
+
+	// hottest=N and coldest=N are shorthands for the N stations with the highest or lowest mean
+	hottest, err := parseCount(c, "hottest")
+	if err != nil {
+		return opts, err
+	}
+	coldest, err := parseCount(c, "coldest")
+	if err != nil {
+		return opts, err
+	}
+	if hottest > 0 && coldest > 0 {
+		return opts, fmt.Errorf("hottest and coldest cannot be combined")
+	}
+	if top := max(hottest, coldest); top > 0 {
+		if param(c, "sort") != "" || param(c, "order") != "" || param(c, "limit") != "" {
+			return opts, fmt.Errorf("hottest and coldest cannot be combined with sort, order or limit")
+		}
+		opts.Sort = models.SortMean
+		opts.Descending = hottest > 0
+		opts.Limit = top
+	}
+	return opts, nil
+}
+
+// paramList reads a list parameter given either repeated or comma-separated.
+func paramList(c *gin.Context, key string) []string {
+	values, ok := c.GetQueryArray(key)
+	if !ok {
+		values = c.PostFormArray(key)
+	}
+	var list []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				list = append(list, name)
+			}
+		}
+	}
+	return list
+}
+
+// parseCount reads a non-negative integer parameter, 0 when absent.
+func parseCount(c *gin.Context, key string) (int, error) {
+	v := param(c, key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+// resultEncoder selects the output encoder from the format parameter or the Accept header.
+// It returns a nil encoder when the client did not ask for a specific format, in which
+// case the JSON envelope is returned.
+func resultEncoder(c *gin.Context) (utilities.ResultEncoder, error) {
+	if v := param(c, "format"); v != "" {
+		enc, ok := utilities.LookupEncoder(v)
+		if !ok {
+			return nil, fmt.Errorf("format: unknown output format %q", v)
+		}
+		return enc, nil
+	}
+	_, enc, ok := utilities.NegotiateEncoder(c.GetHeader("Accept"))
+	if !ok || enc.ContentType() == "application/json" {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// timezoneParam returns the zone named by the timezone parameter that stored bucket starts
+// are shown in, UTC by default.
+func timezoneParam(c *gin.Context) (*time.Location, error) {
+	name := param(c, "timezone")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: unknown time zone %s", name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"1brc-challange/metrics"
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportPartials decodes an uploaded file without merging it and returns the partial
+// aggregate of every decode worker as a download. It accepts the input options of the
+// aggregate endpoint, `sketches=true` to add a sketch of each station's values and
+// `encoding=binary|json` (binary by default).
+func (ch *ClientHandler) ExportPartials(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file upload"})
+		return
+	}
+	defer file.Close()
+
+	opts, err := parseProcessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	encoding := utilities.PartialsEncoding(strings.ToLower(param(c, "encoding")))
+	ext := ".partials"
+	switch encoding {
+	case "", utilities.PartialsBinary:
+		encoding = utilities.PartialsBinary
+	case utilities.PartialsJSON:
+		ext = ".partials.json"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("encoding: unknown encoding %q, want binary or json", encoding)})
+		return
+	}
+
+	set, err := ch.ProcessService.ExportPartials(c.Request.Context(), file, header, opts, param(c, "sketches") == "true")
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+	// Encode before answering so that a failure can still be reported as JSON
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := utilities.EncodePartials(&buf, set, encoding); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode partials"})
+		return
+	}
+	metrics.ObserveStage(metrics.StageEncode, start)
+	contentType := "application/octet-stream"
+	if encoding == utilities.PartialsJSON {
+		contentType = "application/json"
+	}
+	name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)) + ext
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("X-Partial-Count", strconv.Itoa(len(set.Partials)))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// ImportPartials merges one or more uploaded partials files, given as repeated `file`
+// fields, into a single result. Binary and JSON partials may be mixed. The result
+// parameters of the aggregate endpoint apply, and `timezone` sets the zone bucket starts
+// are shown in. When every partial carries sketches the response adds station quantiles.
+func (ch *ClientHandler) ImportPartials(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload at least one partials file as file"})
+		return
+	}
+	loc, err := timezoneParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resultOpts, err := parseResultOptions(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enc, err := resultEncoder(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sets []*models.PartialSet
+	partials := 0
+	for _, header := range form.File["file"] {
+		set, err := readPartials(header)
+		if err != nil {
+			respondProcessError(c, err)
+			return
+		}
+		sets = append(sets, set)
+		partials += len(set.Partials)
+	}
+	stats, sketches, bucket, err := utilities.MergePartials(sets)
+	if err != nil {
+		respondProcessError(c, err)
+		return
+	}
+
+	rows := utilities.BuildRows(stats, resultOpts)
+	total := len(rows)
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	rows = utilities.PageRows(rows, resultOpts)
+	if enc != nil {
+		writeResult(c, enc, form.File["file"][0].Filename, rows, resultOpts)
+		return
+	}
+	body := gin.H{
+		"result":   rows,
+		"total":    total,
+		"files":    len(sets),
+		"partials": partials,
+		"message":  "Partials merged successfully",
+	}
+	if bucket != "" {
+		body["bucket"] = bucket
+	}
+	if sketches != nil {
+		// Only the stations of the returned page
+		page := make(map[string]*models.TempSketch, len(rows))
+		for _, row := range rows {
+			key := row.Station
+			if row.Start != nil {
+				key = utilities.BucketKey(row.Station, *row.Start)
+			}
+			page[key] = sketches[key]
+		}
+		body["quantiles"] = utilities.SketchQuantiles(page)
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// readPartials opens and decodes one uploaded partials file.
+func readPartials(header *multipart.FileHeader) (*models.PartialSet, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set, err := utilities.DecodePartials(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", header.Filename, err)
+	}
+	return set, nil
+}
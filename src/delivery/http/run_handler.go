@@ -0,0 +1,138 @@
+package http
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRunPage is the number of runs listed when no limit is given.
+const defaultRunPage = 50
+
+// ListRuns returns recorded runs, newest first.
+//
+//	kind           "aggregate" or "anomaly"
+//	limit, offset  paging, 50 runs per page by default
+func (ch *ClientHandler) ListRuns(c *gin.Context) {
+	if !ch.requireRuns(c) {
+		return
+	}
+	kind := models.RunKind(c.Query("kind"))
+	if kind != "" && kind != models.RunAggregate && kind != models.RunAnomaly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind: must be aggregate or anomaly"})
+		return
+	}
+	offset, err := parseCount(c, "offset")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := parseCount(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit == 0 {
+		limit = defaultRunPage
+	}
+
+	runs, total, err := ch.Runs.List(kind, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read run history"})
+		return
+	}
+	if runs == nil {
+		runs = []models.Run{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"runs":  runs,
+		"total": total,
+	})
+}
+
+// GetRun returns a recorded run with its results and anomalies. The result
+// parameters of the aggregate endpoint (include, sort, limit, ...) apply to the results.
+func (ch *ClientHandler) GetRun(c *gin.Context) {
+	if !ch.requireRuns(c) {
+		return
+	}
+	run, stats, anomalies, err := ch.Runs.Get(c.Param("id"))
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	ch.respondRun(c, run, stats, anomalies)
+}
+
+// GetRunStation returns what a recorded run holds for one station.
+func (ch *ClientHandler) GetRunStation(c *gin.Context) {
+	if !ch.requireRuns(c) {
+		return
+	}
+	run, stats, anomalies, err := ch.Runs.Station(c.Param("id"), c.Param("name"))
+	if err != nil {
+		respondRunError(c, err)
+		return
+	}
+	ch.respondRun(c, run, stats, anomalies)
+}
+
+// respondRun renders a run with its results as ordered rows.
+func (ch *ClientHandler) respondRun(c *gin.Context, run *models.Run, stats map[string]*models.TempStat, anomalies []*models.Anomaly) {
+	// Bucket starts are shown in the time zone the run was bucketed in
+	loc := time.UTC
+	if name := run.Options["timezone"]; name != "" {
+		if l, err := time.LoadLocation(name); err == nil {
+			loc = l
+		}
+	}
+	rows, total, ok := resultRows(c, stats, loc)
+	if !ok {
+		return
+	}
+	if anomalies == nil {
+		anomalies = []*models.Anomaly{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"run":       run,
+		"result":    rows,
+		"total":     total,
+		"anomalies": anomalies,
+	})
+}
+
+// resultRows applies the result parameters of the request to a stored result and returns
+// the page of rows with the number of matching rows. It answers 400 itself on bad parameters.
+func resultRows(c *gin.Context, stats map[string]*models.TempStat, loc *time.Location) ([]models.StationResult, int, bool) {
+	resultOpts, err := parseResultOptions(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	rows := utilities.BuildRows(stats, resultOpts)
+	total := len(rows)
+	return utilities.PageRows(rows, resultOpts), total, true
+}
+
+// requireRuns answers 404 when run history is disabled.
+func (ch *ClientHandler) requireRuns(c *gin.Context) bool {
+	if ch.Runs == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Run history is disabled"})
+		return false
+	}
+	return true
+}
+
+// respondRunError maps a run store error onto an HTTP status.
+func respondRunError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrRunNotFound) || errors.Is(err, store.ErrStationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read run history"})
+}
@@ -2,26 +2,33 @@ package delivery
 
 import (
 	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/logging"
+	"1brc-challange/metrics"
+	"1brc-challange/tracing"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/pprof"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type RouteConfig struct {
 	Router        *gin.Engine
 	ClientHandler *http_delivery.ClientHandler
+	WorkerHandler *http_delivery.WorkerHandler
 }
 
 func (c *RouteConfig) SetupRoutes() {
 	// Set up Prometheus metrics
-	prometheus.MustRegister(httpRequests)
-	prometheus.MustRegister(httpDuration)
-	// prometheus.MustRegister(collectors.NewGoCollector())                                       // goroutines, GC, mem
-	// prometheus.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})) // CPU, memory, FD
+	registerMetrics()
 
 	c.Router.GET("/", func(ctx *gin.Context) {
 		ctx.JSON(200, gin.H{
@@ -29,13 +36,76 @@ func (c *RouteConfig) SetupRoutes() {
 		})
 	})
 
-	c.Router.Use(PrometheusMiddleware())
-	c.Router.POST("/one-billion-row-challenge", c.ClientHandler.OneBillionRowChallange)
-	c.Router.POST("/anomaly-detection", c.ClientHandler.AnomalyDetection)
+	c.Router.Use(RequestIDMiddleware(), TracingMiddleware(), PrometheusMiddleware(), AccessLogMiddleware())
+	// Runs that decode an upload wait for admission before the upload is read
+	admit := c.ClientHandler.Admit
+	c.Router.POST("/one-billion-row-challenge", admit, c.ClientHandler.OneBillionRowChallange)
+	c.Router.POST("/anomaly-detection", admit, c.ClientHandler.AnomalyDetection)
+	c.Router.POST("/ingest", admit, c.ClientHandler.Ingest)
+	c.Router.POST("/compare", admit, c.ClientHandler.Compare)
+	c.Router.POST("/partials/export", admit, c.ClientHandler.ExportPartials)
+	c.Router.POST("/partials/import", admit, c.ClientHandler.ImportPartials)
+	c.Router.DELETE("/cache", c.ClientHandler.PurgeCache)
+
+	c.Router.GET("/runs", c.ClientHandler.ListRuns)
+	c.Router.GET("/runs/:id", c.ClientHandler.GetRun)
+	c.Router.GET("/runs/:id/stations/:name", c.ClientHandler.GetRunStation)
+
+	c.Router.GET("/aggregates", c.ClientHandler.ListAggregates)
+	c.Router.GET("/aggregates/:name", c.ClientHandler.GetAggregate)
+	c.Router.DELETE("/aggregates/:name", c.ClientHandler.DeleteAggregate)
+	c.Router.POST("/aggregates/:name/append", admit, c.ClientHandler.AppendAggregate)
+	c.Router.POST("/aggregates/:name/snapshots", c.ClientHandler.CreateSnapshot)
+	c.Router.GET("/aggregates/:name/snapshots", c.ClientHandler.ListSnapshots)
+	c.Router.GET("/aggregates/:name/snapshots/:id", c.ClientHandler.GetSnapshot)
+	c.Router.POST("/aggregates/:name/snapshots/:id/restore", c.ClientHandler.RestoreSnapshot)
+
+	c.Router.POST("/cluster/workers", c.ClientHandler.RegisterWorker)
+	c.Router.GET("/cluster/workers", c.ClientHandler.ListWorkers)
+	c.Router.POST("/cluster/workers/:id/heartbeat", c.ClientHandler.WorkerHeartbeat)
+	c.Router.DELETE("/cluster/workers/:id", c.ClientHandler.RemoveWorker)
 
 	c.Router.GET("/health", c.ClientHandler.HealthCheck)
+	c.Router.GET("/livez", c.ClientHandler.Livez)
+	c.Router.GET("/readyz", c.ClientHandler.Readyz)
 	c.Router.GET("/numcpu", c.ClientHandler.GetNumCPU)
-	c.Router.GET("/debug/pprof/", gin.WrapH(http.DefaultServeMux))
+	c.Router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
+
+// SetupAdminRoutes mounts pprof, the runtime diagnostics and the effective configuration.
+// They expose the internals of the process, so they are only set up when enabled, next to
+// the API or on an admin address of their own.
+func (c *RouteConfig) SetupAdminRoutes() {
+	g := c.Router.Group("/debug/pprof")
+	g.GET("/", gin.WrapF(pprof.Index))
+	g.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	g.GET("/profile", gin.WrapF(pprof.Profile))
+	g.GET("/symbol", gin.WrapF(pprof.Symbol))
+	g.POST("/symbol", gin.WrapF(pprof.Symbol))
+	g.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		g.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+	g.POST("/run", c.ClientHandler.ProfileRun)
+	c.Router.GET("/debug/runtime", c.ClientHandler.RuntimeStats)
+	c.Router.GET("/config", c.ClientHandler.ShowConfig)
+}
+
+// SetupAdminServer sets up a router that serves only the admin routes.
+func (c *RouteConfig) SetupAdminServer() {
+	registerMetrics()
+
+	c.Router.Use(RequestIDMiddleware(), TracingMiddleware(), AccessLogMiddleware())
+	c.SetupAdminRoutes()
+}
+
+// SetupWorkerRoutes sets up the endpoints of a cluster worker process.
+func (c *RouteConfig) SetupWorkerRoutes() {
+	registerMetrics()
+
+	c.Router.Use(RequestIDMiddleware(), TracingMiddleware(), PrometheusMiddleware(), AccessLogMiddleware())
+	c.Router.POST("/cluster/decode", c.WorkerHandler.DecodeRange)
+	c.Router.GET("/health", c.WorkerHandler.HealthCheck)
 	c.Router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 }
 
@@ -58,6 +128,18 @@ var (
 	)
 )
 
+var registerOnce sync.Once
+
+// registerMetrics registers the HTTP and pipeline metrics once per process, so several
+// routers can be set up side by side, as tests and embedding programs do.
+func registerMetrics() {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(httpRequests)
+		prometheus.MustRegister(httpDuration)
+		metrics.Register()
+	})
+}
+
 func PrometheusMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -77,3 +159,75 @@ func PrometheusMiddleware() gin.HandlerFunc {
 		httpDuration.WithLabelValues(path, method).Observe(duration)
 	}
 }
+
+// TracingMiddleware starts a server span for every request, continuing the trace of
+// incoming W3C traceparent headers, and hands its context to the handler.
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path // fallback
+		}
+		ctx, span := tracing.StartServer(ctx, c.Request.Method+" "+route,
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("http.route", route),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// RequestIDMiddleware gives every request the ID of its X-Request-ID header, or a new one
+// when it has none, answers it in the same header and puts it in the request context.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := logging.RequestIDFrom(c.GetHeader(logging.RequestIDHeader))
+		c.Header(logging.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// probes are the routes polled by orchestrators and Prometheus.
+var probes = map[string]bool{"/health": true, "/livez": true, "/readyz": true, "/metrics": true}
+
+// AccessLogMiddleware logs every request once it is answered. Probes are only logged at
+// debug level, also when they fail; changes of readiness are logged by the handler.
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		level := slog.LevelInfo
+		switch status := c.Writer.Status(); {
+		case probes[c.FullPath()]:
+			level = slog.LevelDebug
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.Int64("request_bytes", c.Request.ContentLength),
+			slog.Int("response_bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
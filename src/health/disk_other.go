@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package health
+
+// freeBytes is not implemented on this platform, so the disk space check is skipped.
+func freeBytes(string) (uint64, error) {
+	return 0, Skip("free disk space is not known on this platform")
+}
@@ -0,0 +1,217 @@
+// Package health runs the readiness checks of the service. Each check reports its own
+// status and details, so a failing probe says which resource is short.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Statuses of a check.
+const (
+	StatusOK      = "ok"
+	StatusFail    = "fail"
+	StatusSkipped = "skipped" // the check does not apply, e.g. no limit is configured
+)
+
+// Details are the measurements a check reports next to its status.
+type Details map[string]any
+
+// Check is one readiness condition. Run returns the measurements it took, and an error
+// when the service should not take uploads. Errors made by Skip do not fail.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (Details, error)
+}
+
+// ErrSkipped matches the errors of checks that do not apply.
+var ErrSkipped = errors.New("skipped")
+
+// Skip returns the error of a check that does not apply, for reason.
+func Skip(reason string) error {
+	return skipped(reason)
+}
+
+type skipped string
+
+func (s skipped) Error() string        { return string(s) }
+func (s skipped) Is(target error) bool { return target == ErrSkipped }
+
+// Result is the outcome of one check.
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`  // why the check failed
+	Reason     string  `json:"reason,omitempty"` // why the check was skipped
+	Details    Details `json:"details,omitempty"`
+	DurationMs int64   `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks. Status is StatusFail when any check failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready reports whether no check failed.
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs a set of checks. A nil Checker has no checks and is always ready.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewChecker returns a checker that gives every check up to timeout.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run runs all checks at once and collects their results.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result)}
+	if c == nil {
+		return report
+	}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status == StatusFail {
+				report.Status = StatusFail
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+// run runs one check. A check that outlives the timeout fails, and its goroutine is left
+// to finish on its own.
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	type outcome struct {
+		details Details
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := check.Run(ctx)
+		done <- outcome{details, err}
+	}()
+
+	var result Result
+	select {
+	case o := <-done:
+		result = Result{Status: StatusOK, Details: o.details}
+		switch {
+		case errors.Is(o.err, ErrSkipped):
+			result.Status = StatusSkipped
+			result.Reason = o.err.Error()
+		case o.err != nil:
+			result.Status = StatusFail
+			result.Error = o.err.Error()
+		}
+	case <-ctx.Done():
+		result = Result{Status: StatusFail, Error: fmt.Sprintf("timed out after %s", c.timeout)}
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// TempDir checks that a file can be created in dir, where uploads are spooled.
+func TempDir(dir string) Check {
+	return Check{Name: "temp_dir", Run: func(context.Context) (Details, error) {
+		details := Details{"dir": dir}
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return details, fmt.Errorf("not writable: %w", err)
+		}
+		f.Close()
+		return details, os.Remove(f.Name())
+	}}
+}
+
+// DiskSpace checks that the file system of dir has at least floor bytes free.
+// A floor of 0 skips the check.
+func DiskSpace(dir string, floor int64) Check {
+	return Check{Name: "disk_space", Run: func(context.Context) (Details, error) {
+		if floor <= 0 {
+			return nil, Skip("no floor configured")
+		}
+		free, err := freeBytes(dir)
+		if err != nil {
+			return nil, err
+		}
+		details := Details{"dir": dir, "free_bytes": free, "floor_bytes": floor}
+		if free < uint64(floor) {
+			return details, fmt.Errorf("%d bytes free, below the floor of %d", free, floor)
+		}
+		return details, nil
+	}}
+}
+
+// Memory checks that the memory the Go runtime holds leaves at least headroom bytes
+// below limit. A limit of 0 uses the soft limit of the runtime (GOMEMLIMIT) and skips
+// the check when there is none.
+func Memory(limit, headroom int64) Check {
+	if limit <= 0 {
+		if soft := debug.SetMemoryLimit(-1); soft != math.MaxInt64 {
+			limit = soft
+		}
+	}
+	return Check{Name: "memory", Run: func(context.Context) (Details, error) {
+		if limit <= 0 {
+			return nil, Skip("no memory limit configured")
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		used := int64(mem.Sys - mem.HeapReleased)
+		details := Details{"used_bytes": used, "limit_bytes": limit, "headroom_bytes": limit - used, "min_headroom_bytes": headroom}
+		if limit-used < headroom {
+			return details, fmt.Errorf("%d bytes below the limit, want at least %d", limit-used, headroom)
+		}
+		return details, nil
+	}}
+}
+
+// Saturation checks that used stays below capacity, e.g. runs in flight against the runs
+// the server takes at once. A capacity of 0 skips the check.
+func Saturation(name string, used func() int64, capacity int64) Check {
+	return Check{Name: name, Run: func(context.Context) (Details, error) {
+		if capacity <= 0 {
+			return nil, Skip("no capacity configured")
+		}
+		n := used()
+		details := Details{"used": n, "capacity": capacity}
+		if n >= capacity {
+			return details, fmt.Errorf("saturated: %d of %d", n, capacity)
+		}
+		return details, nil
+	}}
+}
+
+// Ping wraps the probe of a store as a check.
+func Ping(name string, ping func() error) Check {
+	return Check{Name: name, Run: func(context.Context) (Details, error) {
+		return nil, ping()
+	}}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/utilities"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// runIngest implements the `ingest` subcommand, which converts a measurements file
+// into the columnar format once so later runs can skip text parsing.
+func runIngest(args []string) error {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	in := fs.String("in", "", "input measurements file (text or NDJSON)")
+	out := fs.String("out", "", "output columnar file")
+	format := fs.String("input-format", "text", "input format: text or ndjson")
+	delimiter := fs.String("delimiter", ";", "field delimiter for text input")
+	header := fs.Bool("header", false, "text input starts with a header row")
+	stationColumn := fs.String("station-column", "0", "station column index or header name")
+	valueColumn := fs.String("value-column", "1", "value column index or header name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" || *out == "" {
+		fs.Usage()
+		return fmt.Errorf("both -in and -out are required")
+	}
+
+	opts := models.ProcessOptions{
+		Format:  models.InputFormat(*format),
+		Dialect: utilities.DefaultDialect,
+		JSON:    utilities.DefaultJSONFields,
+	}
+	if len(*delimiter) != 1 {
+		return fmt.Errorf("delimiter must be a single character")
+	}
+	opts.Dialect.Delimiter = (*delimiter)[0]
+	opts.Dialect.HasHeader = *header
+	if idx, err := strconv.Atoi(*stationColumn); err == nil {
+		opts.Dialect.StationColumn = idx
+	} else {
+		opts.Dialect.StationName = *stationColumn
+	}
+	if idx, err := strconv.Atoi(*valueColumn); err == nil {
+		opts.Dialect.ValueColumn = idx
+	} else {
+		opts.Dialect.ValueName = *valueColumn
+	}
+
+	src, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	rows, err := brc.Ingest(context.Background(), src, info.Size(), dst, brc.WithProcessOptions(opts))
+	if err != nil {
+		dst.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	slog.Info("ingest finished", "input", *in, "output", *out, "rows", rows, "bytes", info.Size())
+	return nil
+}
@@ -0,0 +1,115 @@
+// Package logging configures the structured logger of the service. Records logged with a
+// context carry the request ID and the trace ID of that context, so every line of a
+// request can be found from either.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is the header a request ID is read from and answered in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestID bounds the length of a request ID accepted from a client.
+const maxRequestID = 128
+
+// Config selects the level and format of the logs.
+type Config struct {
+	Level  string // debug, info, warn or error; info when empty
+	Format string // json or text; json when empty
+}
+
+// New returns a logger writing to w as cfg describes.
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("log level: %w", err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format: unknown format %q, want json or text", cfg.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Setup makes the logger of cfg the default of slog and of the standard log package.
+func Setup(w io.Writer, cfg Config) error {
+	logger, err := New(w, cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDFrom returns the client's request ID when it is usable, and a new one otherwise.
+func RequestIDFrom(id string) string {
+	if id == "" || len(id) > maxRequestID {
+		return NewRequestID()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return NewRequestID()
+		}
+	}
+	return id
+}
+
+// NewRequestID returns a random 128-bit ID in hex.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// contextHandler adds the request and trace IDs of the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
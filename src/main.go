@@ -1,33 +1,241 @@
 package main
 
 import (
+	"1brc-challange/admission"
+	"1brc-challange/cache"
+	"1brc-challange/cluster"
+	"1brc-challange/config"
 	"1brc-challange/delivery"
+	grpc_delivery "1brc-challange/delivery/grpc"
 	http_delivery "1brc-challange/delivery/http"
-	"log"
+	"1brc-challange/health"
+	"1brc-challange/logging"
+	"1brc-challange/store"
+	"1brc-challange/tracing"
+	"1brc-challange/utilities"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"runtime"
+	_ "time/tzdata" // bucket time zones must resolve in minimal containers
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	// Set up CPU profiling if needed
-	numCPU := runtime.NumCPU()
-	runtime.GOMAXPROCS(numCPU)
+	// `app ingest` and `app worker` take flags of their own, so only the server reads the
+	// configuration from flags; all of them read the file and the environment
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && (args[0] == "ingest" || args[0] == "worker") {
+		command, args = args[0], args[1:]
+	}
+	var configArgs []string
+	if command == "" {
+		configArgs = args
+	}
+	cfg, err := config.Load(configArgs, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		config.Usage(os.Stderr)
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Logging.Config()); err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	utilities.Configure(cfg.Processing.Tuning())
+	utilities.DefaultAnomalyRules = cfg.Anomaly.Rules()
+
+	switch command {
+	case "ingest":
+		// `app ingest -in measurements.txt -out measurements.brc` converts a file and exits
+		if err := runIngest(args); err != nil {
+			fatal("Ingest failed", err)
+		}
+		return
+	case "worker":
+		// `app worker -coordinator http://host:8080` decodes ranges for a coordinator
+		if err := runWorker(args, cfg); err != nil {
+			fatal("Worker failed", err)
+		}
+		return
+	}
+
+	if err := serve(cfg); err != nil {
+		fatal("Server failed", err)
+	}
+}
+
+// serve runs the API, admin and gRPC servers until one of them fails or a stop signal
+// arrives, then drains them. It returns once every deferred cleanup has run.
+func serve(cfg config.Config) error {
+	// Export spans when an exporter is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Config("1brc-challange"))
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Uploads are spooled to the shared directory for workers that are sent paths
+	if cfg.Cluster.Coordinator && cfg.Cluster.SharedPath {
+		if err := os.MkdirAll(cfg.Cluster.SharedDir, 0o750); err != nil {
+			return fmt.Errorf("failed to create cluster shared dir: %w", err)
+		}
+	}
+
+	// Upload files of a process that died mid-request are never removed otherwise
+	if cfg.Processing.TempMaxAge > 0 {
+		dirs := []string{os.TempDir()}
+		if cfg.Cluster.Coordinator && cfg.Cluster.SharedPath {
+			dirs = append(dirs, cfg.Cluster.SharedDir)
+		}
+		for _, dir := range dirs {
+			removed, err := utilities.SweepTempFiles(dir, cfg.Processing.TempMaxAge)
+			if err != nil {
+				slog.Warn("failed to remove stale temp files", "dir", dir, "error", err)
+			}
+			if removed > 0 {
+				slog.Info("removed stale temp files", "files", removed, "dir", dir)
+			}
+		}
+	}
 
 	// Initialize services
-	clientHandler := http_delivery.NewClientHandler(numCPU)
+	resultCache, err := cache.New(cfg.Cache.Config())
+	if err != nil {
+		return fmt.Errorf("failed to set up result cache: %w", err)
+	}
+	runs, err := store.Open(cfg.Runs.Config())
+	if err != nil {
+		return fmt.Errorf("failed to open run history: %w", err)
+	}
+	defer runs.Close()
+	aggregates, err := store.OpenAggregates(cfg.Aggregates.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open named aggregates: %w", err)
+	}
+	defer aggregates.Close()
+	var coordinator *cluster.Coordinator
+	if cfg.Cluster.Coordinator {
+		coordinator = cluster.NewCoordinator(cfg.Cluster.Config())
+	}
+	clientHandler := http_delivery.NewClientHandler(cfg.Processing.WorkerCount(), resultCache, runs, aggregates, coordinator)
+	clientHandler.Config = &cfg
+	clientHandler.Admission = admission.New(cfg.Admission.Config())
+	clientHandler.Readiness = readiness(cfg.Health, clientHandler.Admission, resultCache, runs, aggregates)
 
 	router := delivery.RouteConfig{
-		Router:        gin.Default(),
+		Router:        newRouter(),
 		ClientHandler: clientHandler,
 	}
-	// Enable pprof for debugging
 	router.SetupRoutes()
 
-	// Set up routes
-	err := router.Router.Run(":8080")
-	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Requests run under jobs, which is only cancelled when draining runs out of time
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	servers := []*http.Server{newServer(cfg.Server.Addr(), router.Router, jobs)}
+
+	// pprof, runtime diagnostics and the configuration: on an address of their own with
+	// an admin address, next to the API with pprof enabled, and off otherwise
+	if cfg.Server.AdminAddr != "" {
+		admin := delivery.RouteConfig{Router: newRouter(), ClientHandler: clientHandler}
+		admin.SetupAdminServer()
+		servers = append(servers, newServer(cfg.Server.AdminAddr, admin.Router, jobs))
+	} else if cfg.Server.PprofEnabled {
+		router.SetupAdminRoutes()
+	}
+
+	serveErr := make(chan error, len(servers)+1)
+	for _, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return err
+		}
+		go func(srv *http.Server) {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("%s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	// Serve the same processing over gRPC; an empty address disables it
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		grpcServer = grpc_delivery.NewServer(clientHandler.ProcessService,
+			grpc.ChainStreamInterceptor(grpc_delivery.AdmitStream(clientHandler.Admission)))
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				serveErr <- fmt.Errorf("gRPC: %w", err)
+			}
+		}()
+	}
+	slog.Info("serving", "addr", cfg.Server.Addr(), "grpc_addr", cfg.Server.GRPCAddr, "admin_addr", cfg.Server.AdminAddr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-serveErr:
+		slog.Error("server failed, shutting down", "error", err)
+	case <-ctx.Done():
+		slog.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.String(), "drain_delay", cfg.Server.DrainDelay.String())
 	}
+	// A second signal kills the process
+	stop()
+
+	clientHandler.StartDraining()
+	time.Sleep(cfg.Server.DrainDelay)
+	drain(servers, grpcServer, cfg.Server.ShutdownTimeout, cancelJobs)
+	return err
+}
+
+// readiness returns the checks of /readyz: room to spool uploads, memory, load and the
+// stores that are configured. The queue counts as saturated once admission control
+// would turn new runs away.
+func readiness(cfg config.Health, admitted *admission.Controller, resultCache *cache.ResultCache, runs *store.RunStore, aggregates *store.AggregateStore) *health.Checker {
+	checks := []health.Check{
+		health.TempDir(os.TempDir()),
+		health.DiskSpace(os.TempDir(), cfg.MinFreeDisk),
+		health.Memory(cfg.MemoryLimit, cfg.MemoryHeadroom),
+		health.Saturation("queue", admitted.Load, admitted.Capacity()),
+	}
+	if resultCache != nil {
+		checks = append(checks, health.Ping("result_cache", resultCache.Ping))
+	}
+	if runs != nil {
+		checks = append(checks, health.Ping("run_history", runs.Ping))
+	}
+	if aggregates != nil {
+		checks = append(checks, health.Ping("aggregates", aggregates.Ping))
+	}
+	return health.NewChecker(cfg.CheckTimeout, checks...)
+}
+
+// fatal logs err and exits. Deferred calls do not run, so it is only used before
+// anything needs cleaning up, or after serve has cleaned up.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newRouter returns a Gin engine that recovers from panics. Requests are logged by the
+// access log middleware of the routes, not by Gin.
+func newRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	return router
 }
@@ -0,0 +1,166 @@
+// Package metrics holds the Prometheus collectors of the processing pipeline. The services,
+// on behalf of the engine, and the handlers update them; Register exposes them once per process.
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Operations label the pipeline metrics.
+const (
+	OpAggregate = "aggregate"
+	OpAnomaly   = "anomaly"
+	OpIngest    = "ingest"
+	OpPartials  = "partials"
+)
+
+// Stages label StageDuration.
+const (
+	StageSpool  = "spool"
+	StageSplit  = "split"
+	StageDecode = "decode"
+	StageMerge  = "merge"
+	StageEncode = "encode"
+)
+
+var (
+	// Rows counts the readings aggregated or checked.
+	Rows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brc_rows_total",
+		Help: "Readings processed, by operation.",
+	}, []string{"operation"})
+
+	// Bytes counts the input bytes decoded.
+	Bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brc_bytes_total",
+		Help: "Input bytes processed, by operation.",
+	}, []string{"operation"})
+
+	// ParseErrors counts input lines or values that could not be used.
+	ParseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brc_parse_errors_total",
+		Help: "Input lines or values that could not be used, by category.",
+	}, []string{"category"})
+
+	// Stations is the number of distinct stations of the last finished run.
+	Stations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brc_stations",
+		Help: "Distinct stations in the last finished run, by operation.",
+	}, []string{"operation"})
+
+	// Anomalies counts the anomalies found.
+	Anomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brc_anomalies_total",
+		Help: "Anomalies detected, by reason.",
+	}, []string{"reason"})
+
+	// TempFileBytes is the size of the upload files and spilled tables currently on disk.
+	TempFileBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "brc_temp_file_bytes",
+		Help: "Bytes of temporary upload files and spilled tables currently on disk.",
+	})
+
+	// SpillRuns counts the tables decode workers spilled to disk over the memory budget.
+	SpillRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "brc_spill_runs_total",
+		Help: "Station tables spilled to disk over the memory budget.",
+	})
+
+	// SpillBytes counts the bytes of the spilled tables.
+	SpillBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "brc_spill_bytes_total",
+		Help: "Bytes written by spilled station tables.",
+	})
+
+	// StageDuration times the pipeline stages of a run.
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "brc_stage_duration_seconds",
+		Help:    "Duration of pipeline stages: spool, split, decode, merge and encode.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to about 4m
+	}, []string{"stage"})
+
+	// InFlight is the number of runs being processed.
+	InFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "brc_runs_in_flight",
+		Help: "Runs currently being processed, by operation.",
+	}, []string{"operation"})
+
+	// AdmittedRuns is the number of runs holding a slot of admission control.
+	AdmittedRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "brc_admission_running",
+		Help: "Runs admitted and not yet finished.",
+	})
+
+	// QueueDepth is the number of runs waiting for a slot.
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "brc_admission_queue_depth",
+		Help: "Runs waiting for a slot.",
+	})
+
+	// QueueWait times how long queued runs waited, whether they got a slot or not.
+	QueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "brc_admission_queue_wait_seconds",
+		Help:    "Time runs waited in the queue.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8), // 10ms to about 3m
+	})
+
+	// Rejected counts the runs turned away.
+	Rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "brc_admission_rejected_total",
+		Help: "Runs turned away, by reason: queue_full or timeout.",
+	}, []string{"reason"})
+
+	// PoolBusy is the number of decode goroutines of the shared pool at work.
+	PoolBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "brc_decode_pool_busy",
+		Help: "Decode goroutines of the shared pool at work.",
+	})
+)
+
+var registerOnce sync.Once
+
+// Register adds the pipeline collectors and the Go and process collectors to the default
+// registry. It may be called more than once.
+func Register() {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(Rows, Bytes, ParseErrors, Stations, Anomalies, TempFileBytes, SpillRuns, SpillBytes, StageDuration, InFlight,
+			AdmittedRuns, QueueDepth, QueueWait, Rejected, PoolBusy)
+		// The default registry may already carry the runtime collectors
+		for _, c := range []prometheus.Collector{
+			collectors.NewGoCollector(),                                       // goroutines, GC, mem
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}), // CPU, memory, FD
+		} {
+			var are prometheus.AlreadyRegisteredError
+			if err := prometheus.Register(c); err != nil && !errors.As(err, &are) {
+				panic(err)
+			}
+		}
+	})
+}
+
+// ObserveStage records the time since start for stage.
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// Track counts a run of operation as in flight until the returned func is called.
+func Track(operation string) func() {
+	g := InFlight.WithLabelValues(operation)
+	g.Inc()
+	return g.Dec
+}
+
+// ObserveSpill records a station table spilled to disk over the memory budget when bytes
+// is positive, and the removal of spill files of that size when it is negative.
+func ObserveSpill(bytes int64) {
+	if bytes > 0 {
+		SpillRuns.Inc()
+		SpillBytes.Add(float64(bytes))
+	}
+	TempFileBytes.Add(float64(bytes))
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+// Dialect describes the layout of a delimited text input.
+// The zero value is not usable; start from utilities.DefaultDialect.
+type Dialect struct {
+	Delimiter byte // field separator, e.g. ';', ',' or '\t'
+	Quote     byte // quote character, 0 disables quote handling
+	Comment   byte // lines starting with this byte are skipped, 0 disables comments
+	HasHeader bool // the first line of the input is a header row
+
+	// Column indices are zero based. When a name is set it takes precedence
+	// and is resolved against the header row.
+	StationColumn int
+	ValueColumn   int
+	StationName   string
+	ValueName     string
+
+	// HasTimestamp enables the optional timestamp column.
+	HasTimestamp    bool
+	TimestampColumn int
+	TimestampName   string
+}
+
+// InputFormat names the encoding of an uploaded file.
+type InputFormat string
+
+const (
+	FormatText     InputFormat = "text"     // delimited text described by a Dialect (default)
+	FormatNDJSON   InputFormat = "ndjson"   // one JSON object per line
+	FormatColumnar InputFormat = "columnar" // binary columnar format produced by ingest
+)
+
+// JSONFields names the object fields read from NDJSON input.
+type JSONFields struct {
+	Station   string
+	Value     string
+	Timestamp string // empty when objects carry no timestamp
+}
+
+// ValuePolicy decides what happens to a row whose temperature is empty or NaN.
+type ValuePolicy string
+
+const (
+	PolicySkip ValuePolicy = "skip" // drop the row (default)
+	PolicyZero ValuePolicy = "zero" // count the row as 0.0
+	PolicyFail ValuePolicy = "fail" // abort the run with an error
+)
+
+// NumericOptions controls how special temperature values are handled.
+// An empty policy behaves like PolicySkip.
+type NumericOptions struct {
+	Empty ValuePolicy
+	NaN   ValuePolicy
+}
+
+// Timestamp formats understood by the timestamp column.
+const (
+	TimeFormatAuto    = "auto"    // epoch seconds/milliseconds or RFC3339, detected per value
+	TimeFormatRFC3339 = "rfc3339" // RFC3339 with optional fractional seconds
+	TimeFormatUnix    = "unix"    // seconds since the epoch, fractions allowed
+	TimeFormatUnixMs  = "unix_ms" // milliseconds since the epoch
+)
+
+// TimeOptions controls timestamp parsing and time-bucketed aggregation.
+type TimeOptions struct {
+	Format   string         // one of the TimeFormat constants, empty means auto
+	Bucket   time.Duration  // bucket width, 0 disables bucketing
+	Location *time.Location // zone used to align buckets, nil means UTC
+}
+
+// AnomalyRules configures the anomaly detection thresholds.
+type AnomalyRules struct {
+	ExtremeMin float32 // readings below this are extreme
+	ExtremeMax float32 // readings above this are extreme
+	SpikeDelta float32 // maximum change between consecutive readings of a station
+	// SpikeRate is the maximum change in °C per minute. When set and both readings
+	// carry a timestamp it replaces SpikeDelta.
+	SpikeRate float32
+}
+
+// ProcessOptions groups the per-request settings of a processing run.
+type ProcessOptions struct {
+	Format  InputFormat // empty means FormatText, or FormatColumnar when the upload starts with its magic
+	Dialect Dialect
+	JSON    JSONFields
+	Numeric NumericOptions
+	Time    TimeOptions
+	Rules   AnomalyRules
+}
+
+// ResultSort names the column that result rows are ordered by.
+type ResultSort string
+
+const (
+	SortName  ResultSort = "name"
+	SortMean  ResultSort = "mean"
+	SortMin   ResultSort = "min"
+	SortMax   ResultSort = "max"
+	SortCount ResultSort = "count"
+	SortRange ResultSort = "range"
+)
+
+// ResultOptions controls how merged results are filtered, ordered and rendered.
+type ResultOptions struct {
+	Decimals   int // digits after the decimal point, rounding half up
+	Sort       ResultSort
+	Descending bool
+	Location   *time.Location
+
+	Include []string // keep only these stations when non-empty
+	Exclude []string
+	Prefix  string
+	Match   *regexp.Regexp
+
+	Offset int
+	Limit  int // 0 means no limit
+}
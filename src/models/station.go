@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Part struct {
 	Offset int64
 	Size   int64
@@ -8,6 +10,7 @@ type Part struct {
 type LineSplit struct {
 	Station     []byte
 	Temperature []byte
+	Timestamp   []byte
 }
 
 type TempStat struct {
@@ -21,4 +24,136 @@ type Anomaly struct {
 	Station string
 	Temp    float32
 	Reason  string
+	Time    *time.Time `json:",omitempty"`
+}
+
+// BucketStat is the aggregate of one station within one time bucket.
+type BucketStat struct {
+	Station string
+	Start   time.Time
+	TempStat
+}
+
+// StationResult is the rendered summary of one station, optionally within a time bucket.
+type StationResult struct {
+	Station string
+	Start   *time.Time `json:",omitempty"`
+	Mean    float64
+	Min     float64
+	Max     float64
+	Count   int64
+}
+
+// AggregateResult is the merged outcome of one aggregation run.
+type AggregateResult struct {
+	Stations  map[string]*TempStat
+	InputHash string // hex SHA-256 of the uploaded content
+	Cached    bool   // served from the result cache without decoding
+	RunID     string // ID in the run history, empty when history is disabled
+}
+
+// AnomalyResult is the outcome of one anomaly detection run.
+type AnomalyResult struct {
+	Anomalies []*Anomaly
+	RunID     string
+}
+
+// RunKind tells which endpoint produced a recorded run.
+type RunKind string
+
+const (
+	RunAggregate RunKind = "aggregate"
+	RunAnomaly   RunKind = "anomaly"
+)
+
+// RunTimings are the stage durations of a run in seconds.
+type RunTimings struct {
+	Spool  float64 // hashing and spooling the upload
+	Decode float64 `json:",omitempty"` // not set for cached runs
+	Merge  float64 `json:",omitempty"`
+	Detect float64 `json:",omitempty"` // anomaly detection, which decodes as it goes
+	Total  float64
+}
+
+// Run is the summary of one recorded aggregation or anomaly run.
+// Per-station results and anomalies are kept alongside it in the run store.
+type Run struct {
+	ID         string
+	Kind       RunKind
+	CreatedAt  time.Time
+	InputName  string
+	InputHash  string
+	InputBytes int64
+	Options    map[string]string // non-default processing options
+	Cached     bool
+	Timings    RunTimings
+	Rows       int64 // rows that produced a reading
+	Stations   int
+	Anomalies  int
+}
+
+// StationDelta is the change of one station (or station bucket) between two results.
+type StationDelta struct {
+	Station    string
+	Start      *time.Time `json:",omitempty"`
+	Base       StationResult
+	Target     StationResult
+	MeanDelta  float64
+	MinDelta   float64
+	MaxDelta   float64
+	CountDelta int64
+}
+
+// Comparison is the per-station difference between a base and a target result.
+type Comparison struct {
+	Changed   []StationDelta  // stations on both sides whose figures differ, by name
+	Added     []StationResult // stations only in the target
+	Removed   []StationResult // stations only in the base
+	Movers    []StationDelta  // the largest absolute mean changes, biggest first
+	Unchanged int
+}
+
+// AggregateInfo describes a named running aggregate.
+type AggregateInfo struct {
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Appends   int   // uploads merged so far
+	Rows      int64 // readings merged so far
+	Stations  int
+	Bucket    string `json:",omitempty"` // time bucket width shared by every append
+}
+
+// AggregateSnapshot is a frozen copy of a named aggregate.
+type AggregateSnapshot struct {
+	ID        string
+	CreatedAt time.Time
+	Aggregate AggregateInfo
+}
+
+// TempSketch is a mergeable histogram of temperatures in tenths of a degree. Merging two
+// sketches adds their bins, so quantiles of a merged result stay exact at that resolution.
+type TempSketch struct {
+	Bins map[int32]uint64
+}
+
+// Partial is the mergeable state of one decode worker.
+type Partial struct {
+	Stations map[string]TempStat
+	Sketches map[string]*TempSketch `json:",omitempty"`
+}
+
+// PartialSet is a versioned export of per-worker partial aggregates, so the merge step can
+// run somewhere else than the decode step.
+type PartialSet struct {
+	Version  int
+	Bucket   string `json:",omitempty"`
+	Partials []Partial
+}
+
+// StationQuantiles are temperature quantiles of one station, read from its merged sketch.
+type StationQuantiles struct {
+	P50 float64
+	P90 float64
+	P99 float64
 }
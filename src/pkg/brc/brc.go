@@ -0,0 +1,413 @@
+// Package brc is the aggregation and anomaly detection engine of the service as a library.
+// It reads plain io.Reader and io.ReaderAt inputs, so other Go programs can use it without
+// going through HTTP uploads:
+//
+//	f, _ := os.Open("measurements.txt")
+//	info, _ := f.Stat()
+//	result, err := brc.Aggregate(ctx, f, info.Size(), brc.WithWorkers(8))
+//
+// The HTTP and gRPC handlers and the ingest command are adapters over this package. It
+// records no metrics or spans of its own; WithObserver passes them to the caller.
+package brc
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Result is the merged aggregate of an input. Bucketed runs key their stations with
+// BucketKey.
+type Result struct {
+	Stations    map[string]*models.TempStat
+	Sketches    map[string]*models.TempSketch // set with WithSketches
+	Rows        int64                         // readings aggregated
+	ParseErrors map[string]int64              // input that could not be used, by category
+}
+
+// AnomalyReport is the outcome of anomaly detection.
+type AnomalyReport struct {
+	Anomalies   []*models.Anomaly
+	Rows        int64 // readings checked
+	Stations    int
+	ParseErrors map[string]int64
+}
+
+// Aggregate computes min, mean and max per station over the first size bytes of r.
+// The input is split at line boundaries and decoded by the configured number of workers.
+func Aggregate(ctx context.Context, r io.ReaderAt, size int64, options ...Option) (*Result, error) {
+	cfg := newConfig(options)
+	op := operation(cfg, OpAggregate)
+	partials, parseErrors, err := decode(ctx, r, size, cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, step := cfg.observer.StartStep(ctx, "merge", Attr{"merge.partials", int64(len(partials))})
+	start := time.Now()
+	result := Merge(partials)
+	cfg.observer.Stage(StageMerge, time.Since(start))
+	step.End(nil)
+	result.ParseErrors = parseErrors
+	cfg.observer.Processed(op, size, result.Rows)
+	cfg.observer.Stations(op, CountStations(result.Stations))
+	return result, nil
+}
+
+// Partials decodes the input like Aggregate but returns the state of every worker unmerged,
+// so it can be exported and merged elsewhere.
+func Partials(ctx context.Context, r io.ReaderAt, size int64, options ...Option) ([]models.Partial, error) {
+	cfg := newConfig(options)
+	op := operation(cfg, OpPartials)
+	partials, _, err := decode(ctx, r, size, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var rows int64
+	for _, p := range partials {
+		for _, stat := range p.Stations {
+			rows += int64(stat.Count)
+		}
+	}
+	cfg.observer.Processed(op, size, rows)
+	return partials, nil
+}
+
+// operation returns the operation a run reports, def unless WithOperation set one.
+func operation(cfg config, def string) string {
+	if cfg.operation != "" {
+		return cfg.operation
+	}
+	return def
+}
+
+// decode splits and decodes the input and returns the worker states with the parse
+// errors of the run.
+func decode(ctx context.Context, r io.ReaderAt, size int64, cfg config) (partials []models.Partial, parseErrors map[string]int64, err error) {
+	obs := cfg.observer
+	opts, err := Prepare(cfg.opts, r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Format == models.FormatColumnar {
+		if cfg.sketches {
+			return nil, nil, fmt.Errorf("%w: sketches need the individual values, which columnar input is not decoded into", ErrInvalidOptions)
+		}
+		start := time.Now()
+		_, step := obs.StartStep(ctx, "decode_columnar", Attr{"input.size", size})
+		f, err := utilities.OpenColumnar(r, size)
+		if err == nil {
+			var results []map[string]models.TempStat
+			if results, err = f.Aggregate(cfg.workers); err == nil {
+				partials = make([]models.Partial, len(results))
+				for i, stations := range results {
+					partials[i] = models.Partial{Stations: stations}
+				}
+			}
+		}
+		step.End(err)
+		if err != nil {
+			return nil, nil, err
+		}
+		obs.Stage(StageDecode, time.Since(start))
+		return partials, map[string]int64{}, ctx.Err()
+	}
+
+	start := time.Now()
+	_, step := obs.StartStep(ctx, "split", Attr{"input.size", size}, Attr{"split.workers", int64(cfg.workers)})
+	parts, err := utilities.SplitReaderAt(r, size, cfg.workers)
+	step.SetAttributes(Attr{"split.parts", int64(len(parts))})
+	step.End(err)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to split input: %w", err)
+	}
+	obs.Stage(StageSplit, time.Since(start))
+	start = time.Now()
+	dec := utilities.NewDecoder(opts)
+	if dec.MemoryBudget > 0 && dec.BudgetPolicy == utilities.BudgetSpill {
+		dec.Spills = utilities.NewSpillSet(os.TempDir(), obs.Spill)
+		defer dec.Spills.Close()
+	}
+	partials = make([]models.Partial, len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i, p := range parts {
+		partials[i] = models.Partial{Stations: make(map[string]models.TempStat)}
+		if cfg.sketches {
+			partials[i].Sketches = make(map[string]*models.TempSketch)
+		}
+		wg.Add(1)
+		// A shared pool may hold the part back until other runs free a goroutine
+		err := cfg.pool.Go(ctx, func() {
+			defer wg.Done()
+			_, step := obs.StartStep(ctx, "decode_part", Attr{"part.index", int64(i)},
+				Attr{"part.offset", p.Offset}, Attr{"part.size", p.Size})
+			section := utilities.ContextReader{Ctx: ctx, R: io.NewSectionReader(r, p.Offset, p.Size)}
+			errs[i] = dec.DecodeSectionSketches(section, p.Offset == 0, partials[i].Stations, partials[i].Sketches)
+			step.SetAttributes(Attr{"part.stations", int64(len(partials[i].Stations))})
+			step.End(errs[i])
+		})
+		if err != nil {
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+	obs.Stage(StageDecode, time.Since(start))
+	parseErrors = dec.Errors.Counts()
+	obs.ParseErrors(parseErrors)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	if dec.Spills.Runs() > 0 {
+		merged, err := mergeSpills(ctx, obs, dec, partials)
+		if err != nil {
+			return nil, nil, err
+		}
+		partials = []models.Partial{{Stations: merged}}
+	}
+	return partials, parseErrors, nil
+}
+
+// mergeSpills merges the runs the workers spilled and the tables they still hold into one
+// table. The budget of the run, that of all its workers together, bounds the merged table
+// as it bounded the tables while decoding, so a result too large fails with ErrMemoryBudget.
+func mergeSpills(ctx context.Context, obs Observer, dec *utilities.Decoder, partials []models.Partial) (map[string]models.TempStat, error) {
+	// The tables only hold the stations seen since their last spill; spilling them too
+	// lets the merge hold each station once
+	for _, p := range partials {
+		if err := dec.Spills.Spill(p.Stations); err != nil {
+			return nil, err
+		}
+		clear(p.Stations)
+	}
+	_, step := obs.StartStep(ctx, "merge_spills", Attr{"spill.runs", int64(dec.Spills.Runs())})
+	merged := make(map[string]models.TempStat)
+	err := dec.Spills.MergeInto(ctx, merged, dec.MemoryBudget*int64(len(partials)))
+	step.SetAttributes(Attr{"spill.stations", int64(len(merged))})
+	step.End(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge spilled tables: %w", err)
+	}
+	return merged, nil
+}
+
+// Merge folds the partials of one or more runs into one result. Sketches are only merged
+// when every partial carries them, since quantiles over part of the rows would mislead.
+func Merge(partials []models.Partial) *Result {
+	stats := make([]map[string]models.TempStat, len(partials))
+	sketches := make([]map[string]*models.TempSketch, len(partials))
+	complete := len(partials) > 0
+	for i, p := range partials {
+		stats[i] = p.Stations
+		sketches[i] = p.Sketches
+		if p.Sketches == nil && len(p.Stations) > 0 {
+			complete = false
+		}
+	}
+	result := &Result{Stations: utilities.MergeResults(stats)}
+	if complete {
+		result.Sketches = utilities.MergeSketches(sketches)
+	}
+	for _, stat := range result.Stations {
+		result.Rows += int64(stat.Count)
+	}
+	return result
+}
+
+// DetectAnomalies streams r and reports readings that break rules. Readings of a station
+// are checked in input order; stations are spread over the configured workers. With
+// OnAnomaly each anomaly is also passed on as soon as it is found; when that callback
+// fails the report is returned together with its error.
+func DetectAnomalies(ctx context.Context, r io.Reader, rules Rules, options ...Option) (_ *AnomalyReport, err error) {
+	cfg := newConfig(options)
+	obs := cfg.observer
+	op := operation(cfg, OpAnomaly)
+	opts := cfg.opts
+	opts.Rules = rules
+	workers := cfg.workers
+	if size := cfg.pool.Size(); size > 0 {
+		workers = min(workers, size)
+	}
+	ctx, step := obs.StartStep(ctx, "detect_anomalies", Attr{"detect.workers", int64(workers)})
+	defer func() { step.End(err) }()
+
+	// Named columns and the columnar magic are read from the start of the stream
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, err := br.Peek(64 * 1024)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if opts, err = Prepare(opts, bytes.NewReader(head), int64(len(head))); err != nil {
+		return nil, err
+	}
+	if opts.Format == models.FormatColumnar {
+		return nil, fmt.Errorf("%w: anomaly detection needs text or NDJSON input", ErrInvalidOptions)
+	}
+
+	// The detectors feed each other through the shard router, so they run together on
+	// goroutines reserved from the pool
+	release, err := cfg.pool.Reserve(ctx, workers)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	report := &AnomalyReport{}
+	dec := utilities.NewDecoder(opts)
+	counted := &countingReader{r: br}
+	queued := utilities.CurrentTuning().ChannelBuffer
+	lines := make(chan []byte, queued)
+	splits := make(chan models.LineSplit, queued)
+	anomalies := make(chan models.Anomaly, queued/10)
+
+	// Shard stationTemps and mutexes per worker to reduce contention
+	shards := make([]chan models.LineSplit, workers)
+	stationTempsShards := make([]map[string]float32, workers)
+	stationTimesShards := make([]map[string]time.Time, workers)
+	statsMuShards := make([]sync.Mutex, workers)
+	anomalyCounts := make([]int32, workers)
+	spikeCounts := make([]int32, workers)
+	workerErrs := make([]error, workers)
+
+	// Read the input line by line
+	readErr := make(chan error, 1)
+	go func() { readErr <- utilities.ReadLines(utilities.ContextReader{Ctx: ctx, R: counted}, lines) }()
+
+	// Split lines into LineSplit entries
+	go utilities.SplitLines(lines, splits, dec)
+
+	// Route every station to a fixed shard so its readings stay in order
+	for i := range shards {
+		shards[i] = make(chan models.LineSplit, queued/10)
+	}
+	go utilities.ShardSplits(splits, shards, &report.Rows)
+
+	// Initialize shards and start one detector per shard
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		stationTempsShards[i] = make(map[string]float32)
+		stationTimesShards[i] = make(map[string]time.Time)
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			workerErrs[idx] = utilities.DetectAnomaliesWithRules(
+				shards[idx],
+				anomalies,
+				stationTempsShards[idx],
+				stationTimesShards[idx],
+				&statsMuShards[idx],
+				&anomalyCounts[idx],
+				&spikeCounts[idx],
+				&dec.Errors,
+				opts,
+			)
+		}(i)
+	}
+
+	// Wait for all workers to finish processing
+	go func() {
+		wg.Wait()
+		close(anomalies)
+	}()
+
+	var emitErr error
+	for anomaly := range anomalies {
+		obs.Anomaly(anomaly.Reason)
+		report.Anomalies = append(report.Anomalies, &anomaly)
+		if cfg.onAnomaly != nil && emitErr == nil {
+			emitErr = cfg.onAnomaly(&anomaly)
+		}
+	}
+	report.ParseErrors = dec.Errors.Counts()
+	obs.ParseErrors(report.ParseErrors)
+	step.SetAttributes(Attr{"detect.rows", report.Rows}, Attr{"detect.anomalies", int64(len(report.Anomalies))})
+	if err := <-readErr; err != nil {
+		return nil, err
+	}
+	obs.Processed(op, counted.n, report.Rows)
+	if err := errors.Join(workerErrs...); err != nil {
+		return nil, err
+	}
+	// Every station lives in exactly one shard
+	for _, temps := range stationTempsShards {
+		report.Stations += len(temps)
+	}
+	obs.Stations(op, report.Stations)
+	return report, emitErr
+}
+
+// Ingest converts text or NDJSON input into the columnar format, writes it to w and
+// returns the number of rows written.
+func Ingest(ctx context.Context, r io.ReaderAt, size int64, w io.Writer, options ...Option) (int64, error) {
+	cfg := newConfig(options)
+	opts, err := Prepare(cfg.opts, r, size)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Format == models.FormatColumnar {
+		return 0, fmt.Errorf("%w: input is already columnar", ErrInvalidOptions)
+	}
+	if opts.Time.Bucket > 0 {
+		return 0, fmt.Errorf("%w: the columnar format has no time dimension", ErrInvalidOptions)
+	}
+	dec := utilities.NewDecoder(opts)
+	_, step := cfg.observer.StartStep(ctx, "ingest", Attr{"input.size", size})
+	rows, err := dec.IngestColumnar(utilities.ContextReader{Ctx: ctx, R: io.NewSectionReader(r, 0, size)}, w)
+	step.SetAttributes(Attr{"ingest.rows", rows})
+	step.End(err)
+	cfg.observer.ParseErrors(dec.Errors.Counts())
+	if err == nil {
+		cfg.observer.Processed(operation(cfg, OpIngest), size, rows)
+	}
+	return rows, err
+}
+
+// CountStations returns the number of distinct stations of a result, whose keys may
+// carry a time bucket.
+func CountStations(stats map[string]*models.TempStat) int {
+	names := make(map[string]struct{}, len(stats))
+	for key := range stats {
+		name, _, _ := SplitBucketKey(key)
+		names[name] = struct{}{}
+	}
+	return len(names)
+}
+
+// BucketKey returns the key of the readings of station in the time bucket starting at start.
+func BucketKey(station string, start time.Time) string {
+	return utilities.BucketKey(station, start)
+}
+
+// SplitBucketKey splits a result key into the station and the start of its time bucket.
+// ok is false for keys of runs without buckets, which are the station itself.
+func SplitBucketKey(key string) (station string, start time.Time, ok bool) {
+	return utilities.SplitBucketKey(key)
+}
+
+// SketchQuantiles reads the median, 90th and 99th percentile of every sketch.
+func SketchQuantiles(sketches map[string]*models.TempSketch) map[string]models.StationQuantiles {
+	return utilities.SketchQuantiles(sketches)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,73 @@
+package brc
+
+import (
+	"context"
+	"time"
+)
+
+// Observer receives the measurements of a run: the steps a trace shows, the time of the
+// pipeline stages and the volume processed. The package records nothing by itself; pass
+// an observer with WithObserver to export metrics or spans.
+type Observer interface {
+	// StartStep starts a step of a run, such as "split" or "decode_part", under ctx.
+	StartStep(ctx context.Context, name string, attrs ...Attr) (context.Context, Step)
+	// Stage records how long a pipeline stage took: split, decode or merge.
+	Stage(stage string, d time.Duration)
+	// Processed adds the input bytes and the readings of a run of operation.
+	Processed(operation string, bytes, rows int64)
+	// Stations reports the distinct stations of the last finished run of operation.
+	Stations(operation string, n int)
+	// ParseErrors adds the input that could not be used, by category.
+	ParseErrors(counts map[string]int64)
+	// Anomaly counts an anomaly found, by reason.
+	Anomaly(reason string)
+	// Spill is called with the size of every table spilled to disk over the memory
+	// budget, and with minus the size of the spill files once they are removed.
+	Spill(bytes int64)
+}
+
+// Step is a step of a run started by an Observer.
+type Step interface {
+	SetAttributes(attrs ...Attr)
+	// End finishes the step; err is nil when it succeeded.
+	End(err error)
+}
+
+// Attr is a numeric attribute of a step, such as the size of its input.
+type Attr struct {
+	Key   string
+	Value int64
+}
+
+// Stage names passed to Observer.Stage.
+const (
+	StageSplit  = "split"
+	StageDecode = "decode"
+	StageMerge  = "merge"
+)
+
+// Operation names passed to the Observer unless WithOperation sets another.
+const (
+	OpAggregate = "aggregate"
+	OpAnomaly   = "anomaly"
+	OpIngest    = "ingest"
+	OpPartials  = "partials"
+)
+
+// nopObserver records nothing; it is the observer of runs without WithObserver.
+type nopObserver struct{}
+
+func (nopObserver) StartStep(ctx context.Context, _ string, _ ...Attr) (context.Context, Step) {
+	return ctx, nopStep{}
+}
+func (nopObserver) Stage(string, time.Duration)    {}
+func (nopObserver) Processed(string, int64, int64) {}
+func (nopObserver) Stations(string, int)           {}
+func (nopObserver) ParseErrors(map[string]int64)   {}
+func (nopObserver) Anomaly(string)                 {}
+func (nopObserver) Spill(int64)                    {}
+
+type nopStep struct{}
+
+func (nopStep) SetAttributes(...Attr) {}
+func (nopStep) End(error)             {}
@@ -0,0 +1,163 @@
+package brc
+
+import (
+	"1brc-challange/admission"
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// ErrInvalidOptions is returned when the options do not fit the input.
+var ErrInvalidOptions = errors.New("invalid processing options")
+
+// Rules are the thresholds of anomaly detection.
+type Rules = models.AnomalyRules
+
+// DefaultDialect is the `station;temperature` layout without header or quoting.
+var DefaultDialect = utilities.DefaultDialect
+
+// DefaultRules flag readings below -50 °C or above 60 °C and jumps of more than 20 °C.
+var DefaultRules = utilities.DefaultAnomalyRules
+
+// Option configures a run.
+type Option func(*config)
+
+type config struct {
+	workers   int
+	opts      models.ProcessOptions
+	sketches  bool
+	onAnomaly func(*models.Anomaly) error
+	operation string
+	pool      *admission.Pool
+	observer  Observer
+}
+
+func newConfig(options []Option) config {
+	cfg := config{
+		workers:  runtime.NumCPU(),
+		opts:     models.ProcessOptions{Dialect: utilities.DefaultDialect, JSON: utilities.DefaultJSONFields},
+		observer: nopObserver{},
+	}
+	for _, o := range options {
+		o(&cfg)
+	}
+	if cfg.workers < 1 {
+		cfg.workers = 1
+	}
+	if cfg.observer == nil {
+		cfg.observer = nopObserver{}
+	}
+	return cfg
+}
+
+// WithWorkers sets how many goroutines decode the input; runtime.NumCPU by default.
+func WithWorkers(n int) Option {
+	return func(c *config) { c.workers = n }
+}
+
+// WithPool takes the decode goroutines from p, so that runs decoding at the same time
+// share its goroutines. DetectAnomalies reserves its detectors from p, at most its size.
+// Without it, or with a nil pool, each run starts its own.
+func WithPool(p *admission.Pool) Option {
+	return func(c *config) { c.pool = p }
+}
+
+// WithFormat sets the input format. Without it columnar input is detected by its magic
+// bytes and anything else is read as text.
+func WithFormat(f models.InputFormat) Option {
+	return func(c *config) { c.opts.Format = f }
+}
+
+// WithDialect sets the layout of text input; DefaultDialect by default.
+// Named columns are resolved against the header row.
+func WithDialect(d models.Dialect) Option {
+	return func(c *config) { c.opts.Dialect = d }
+}
+
+// WithJSONFields sets the field names of NDJSON input.
+func WithJSONFields(f models.JSONFields) Option {
+	return func(c *config) { c.opts.JSON = f }
+}
+
+// WithNumeric sets how empty and NaN values are treated.
+func WithNumeric(n models.NumericOptions) Option {
+	return func(c *config) { c.opts.Numeric = n }
+}
+
+// WithTime sets the timestamp format and the time bucket results are grouped by.
+func WithTime(t models.TimeOptions) Option {
+	return func(c *config) { c.opts.Time = t }
+}
+
+// WithProcessOptions replaces every input option at once, as the HTTP and gRPC APIs parse them.
+func WithProcessOptions(opts models.ProcessOptions) Option {
+	return func(c *config) { c.opts = opts }
+}
+
+// WithSketches keeps a sketch of the values of every station, from which quantiles can be
+// read with SketchQuantiles. Columnar input holds no individual values.
+func WithSketches() Option {
+	return func(c *config) { c.sketches = true }
+}
+
+// OnAnomaly passes every anomaly to fn as soon as it is found. When fn fails, detection
+// still runs to the end but fn is not called again.
+func OnAnomaly(fn func(*models.Anomaly) error) Option {
+	return func(c *config) { c.onAnomaly = fn }
+}
+
+// WithOperation sets the operation the run reports to its Observer. It defaults to the
+// function called, such as OpAggregate or OpPartials.
+func WithOperation(name string) Option {
+	return func(c *config) { c.operation = name }
+}
+
+// WithObserver passes the measurements of the run to o. Without it nothing is recorded.
+func WithObserver(o Observer) Option {
+	return func(c *config) { c.observer = o }
+}
+
+// Prepare validates the input options against the first size bytes of r. It detects
+// columnar input when no format is set and resolves named text columns against the header row.
+func Prepare(opts models.ProcessOptions, r io.ReaderAt, size int64) (models.ProcessOptions, error) {
+	if opts.Format == "" && utilities.IsColumnar(r) {
+		opts.Format = models.FormatColumnar
+	}
+	switch opts.Format {
+	case models.FormatColumnar:
+		if opts.Time.Bucket > 0 {
+			return opts, fmt.Errorf("%w: the columnar format has no time dimension", ErrInvalidOptions)
+		}
+		return opts, nil
+	case "", models.FormatText:
+		dialect, err := utilities.PrepareDialect(opts.Dialect, r, size)
+		if err != nil {
+			return opts, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+		}
+		opts.Dialect = dialect
+	case models.FormatNDJSON:
+		if err := utilities.ValidateJSONFields(opts.JSON); err != nil {
+			return opts, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+		}
+	default:
+		return opts, fmt.Errorf("%w: unknown input format %q", ErrInvalidOptions, opts.Format)
+	}
+	return opts, validateOptions(opts)
+}
+
+// validateOptions checks the numeric, time and anomaly settings of a run.
+func validateOptions(opts models.ProcessOptions) error {
+	if err := utilities.ValidateNumericOptions(opts.Numeric); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	if err := utilities.ValidateTimeOptions(opts.Time, utilities.HasTimestamp(opts)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	if opts.Rules.ExtremeMin > opts.Rules.ExtremeMax {
+		return fmt.Errorf("%w: extreme_min must not exceed extreme_max", ErrInvalidOptions)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"1brc-challange/metrics"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/tracing"
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PipelineObserver records what the engine measures as Prometheus metrics and spans.
+// Every run of the service passes it to the engine.
+var PipelineObserver brc.Observer = pipelineObserver{}
+
+type pipelineObserver struct{}
+
+func (pipelineObserver) StartStep(ctx context.Context, name string, attrs ...brc.Attr) (context.Context, brc.Step) {
+	ctx, span := tracing.Start(ctx, name, spanAttributes(attrs)...)
+	return ctx, spanStep{span}
+}
+
+func (pipelineObserver) Stage(stage string, d time.Duration) {
+	metrics.StageDuration.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+func (pipelineObserver) Processed(operation string, bytes, rows int64) {
+	metrics.Bytes.WithLabelValues(operation).Add(float64(bytes))
+	metrics.Rows.WithLabelValues(operation).Add(float64(rows))
+}
+
+func (pipelineObserver) Stations(operation string, n int) {
+	metrics.Stations.WithLabelValues(operation).Set(float64(n))
+}
+
+func (pipelineObserver) ParseErrors(counts map[string]int64) {
+	for category, n := range counts {
+		metrics.ParseErrors.WithLabelValues(category).Add(float64(n))
+	}
+}
+
+func (pipelineObserver) Anomaly(reason string) {
+	metrics.Anomalies.WithLabelValues(reason).Inc()
+}
+
+func (pipelineObserver) Spill(bytes int64) {
+	metrics.ObserveSpill(bytes)
+}
+
+// spanStep is a step traced as a span.
+type spanStep struct {
+	span trace.Span
+}
+
+func (s spanStep) SetAttributes(attrs ...brc.Attr) {
+	s.span.SetAttributes(spanAttributes(attrs)...)
+}
+
+func (s spanStep) End(err error) {
+	tracing.End(s.span, err)
+}
+
+func spanAttributes(attrs []brc.Attr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		kvs[i] = attribute.Int64(a.Key, a.Value)
+	}
+	return kvs
+}
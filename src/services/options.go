@@ -0,0 +1,218 @@
+package services
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseProcessOptions builds the processing options from named parameters, looked up with
+// get. The HTTP and gRPC transports both parse with it, so they accept the same options
+// under the names of the HTTP query parameters.
+func ParseProcessOptions(get func(key string) string) (models.ProcessOptions, error) {
+	var opts models.ProcessOptions
+	switch v := strings.ToLower(get("input_format")); v {
+	case "":
+		// detected from the upload: columnar files are recognised by their magic bytes
+	case "text", "csv":
+		opts.Format = models.FormatText
+	case "ndjson", "jsonl", "json":
+		opts.Format = models.FormatNDJSON
+	case "columnar", "brc":
+		opts.Format = models.FormatColumnar
+	default:
+		return opts, fmt.Errorf("input_format: unknown format %q", v)
+	}
+
+	dialect, err := parseDialect(get)
+	if err != nil {
+		return opts, err
+	}
+	opts.Dialect = dialect
+	opts.JSON = parseJSONFields(get)
+	if opts.Format == models.FormatNDJSON {
+		if err := utilities.ValidateJSONFields(opts.JSON); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.Numeric = models.NumericOptions{
+		Empty: models.ValuePolicy(strings.ToLower(get("empty_policy"))),
+		NaN:   models.ValuePolicy(strings.ToLower(get("nan_policy"))),
+	}
+	if err := utilities.ValidateNumericOptions(opts.Numeric); err != nil {
+		return opts, err
+	}
+	if opts.Time, err = parseTimeOptions(get, utilities.HasTimestamp(opts)); err != nil {
+		return opts, err
+	}
+	if opts.Rules, err = parseAnomalyRules(get); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseJSONFields reads the NDJSON field names, defaulting to utilities.DefaultJSONFields.
+//
+//	station_field    name of the station field
+//	value_field      name of the temperature field
+//	timestamp_field  name of the optional timestamp field
+func parseJSONFields(get func(string) string) models.JSONFields {
+	f := utilities.DefaultJSONFields
+	if v := get("station_field"); v != "" {
+		f.Station = v
+	}
+	if v := get("value_field"); v != "" {
+		f.Value = v
+	}
+	f.Timestamp = get("timestamp_field")
+	return f
+}
+
+// parseTimeOptions reads the timestamp and bucketing parameters.
+//
+//	time_format  "auto", "rfc3339", "unix" or "unix_ms"
+//	bucket       "hour", "day" or a Go duration such as "15m"
+//	timezone     IANA zone used to align buckets, e.g. "Europe/Berlin"
+func parseTimeOptions(get func(string) string, hasTimestamp bool) (models.TimeOptions, error) {
+	opts := models.TimeOptions{Format: strings.ToLower(get("time_format")), Location: time.UTC}
+	switch v := strings.ToLower(get("bucket")); v {
+	case "":
+	case "hour", "hourly":
+		opts.Bucket = time.Hour
+	case "day", "daily":
+		opts.Bucket = 24 * time.Hour
+	default:
+		width, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("bucket: %w", err)
+		}
+		opts.Bucket = width
+	}
+	if v := get("timezone"); v != "" {
+		loc, err := time.LoadLocation(v)
+		if err != nil {
+			return opts, fmt.Errorf("timezone: %w", err)
+		}
+		opts.Location = loc
+	}
+	return opts, utilities.ValidateTimeOptions(opts, hasTimestamp)
+}
+
+// parseAnomalyRules reads the anomaly thresholds, defaulting to utilities.DefaultAnomalyRules.
+//
+//	extreme_min, extreme_max  bounds in °C outside which a reading is extreme
+//	spike_delta               maximum change in °C between consecutive readings
+//	spike_rate                maximum change in °C per minute, needs a timestamp column
+func parseAnomalyRules(get func(string) string) (models.AnomalyRules, error) {
+	rules := utilities.DefaultAnomalyRules
+	fields := []struct {
+		key string
+		dst *float32
+	}{
+		{"extreme_min", &rules.ExtremeMin},
+		{"extreme_max", &rules.ExtremeMax},
+		{"spike_delta", &rules.SpikeDelta},
+		{"spike_rate", &rules.SpikeRate},
+	}
+	for _, f := range fields {
+		v := get(f.key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return rules, fmt.Errorf("%s: %w", f.key, err)
+		}
+		*f.dst = float32(n)
+	}
+	return rules, nil
+}
+
+// parseDialect reads the input dialect parameters, starting from the canonical `station;temperature` layout.
+//
+//	delimiter         single character, or "tab", "comma", "semicolon", "pipe"
+//	quote             quote character, empty disables quoting
+//	comment           comment prefix character, e.g. "#"
+//	header            "true" when the first line is a header row
+//	station_column    zero-based index or header name of the station column
+//	value_column      zero-based index or header name of the value column
+//	timestamp_column  zero-based index or header name of the optional timestamp column
+func parseDialect(get func(string) string) (models.Dialect, error) {
+	d := utilities.DefaultDialect
+
+	if v := get("delimiter"); v != "" {
+		b, err := parseChar(v)
+		if err != nil {
+			return d, fmt.Errorf("delimiter: %w", err)
+		}
+		d.Delimiter = b
+	}
+	if v := get("quote"); v != "" {
+		b, err := parseChar(v)
+		if err != nil {
+			return d, fmt.Errorf("quote: %w", err)
+		}
+		d.Quote = b
+	}
+	if v := get("comment"); v != "" {
+		b, err := parseChar(v)
+		if err != nil {
+			return d, fmt.Errorf("comment: %w", err)
+		}
+		d.Comment = b
+	}
+	if v := get("header"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return d, fmt.Errorf("header: %w", err)
+		}
+		d.HasHeader = b
+	}
+	if v := get("station_column"); v != "" {
+		if idx, err := strconv.Atoi(v); err == nil {
+			d.StationColumn = idx
+		} else {
+			d.StationName = v
+		}
+	}
+	if v := get("value_column"); v != "" {
+		if idx, err := strconv.Atoi(v); err == nil {
+			d.ValueColumn = idx
+		} else {
+			d.ValueName = v
+		}
+	}
+	if v := get("timestamp_column"); v != "" {
+		d.HasTimestamp = true
+		if idx, err := strconv.Atoi(v); err == nil {
+			d.TimestampColumn = idx
+		} else {
+			d.TimestampName = v
+		}
+	}
+	return d, utilities.ValidateDialect(d)
+}
+
+// parseChar accepts a single ASCII character or one of a few well-known names.
+func parseChar(v string) (byte, error) {
+	switch strings.ToLower(v) {
+	case "tab", `\t`:
+		return '\t', nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	case "pipe":
+		return '|', nil
+	case "space":
+		return ' ', nil
+	}
+	if len(v) != 1 || v[0] > 127 {
+		return 0, fmt.Errorf("expected a single ASCII character, got %q", v)
+	}
+	return v[0], nil
+}
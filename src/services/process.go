@@ -1,37 +1,79 @@
 package services
 
 import (
+	"1brc-challange/admission"
+	"1brc-challange/cache"
+	"1brc-challange/cluster"
+	"1brc-challange/metrics"
 	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/store"
+	"1brc-challange/tracing"
 	"1brc-challange/utilities"
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"mime/multipart"
-	"os"
 	"runtime"
-	"sync"
+	"strconv"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// ErrInvalidOptions is returned when the processing options do not fit the uploaded input.
+var ErrInvalidOptions = brc.ErrInvalidOptions
+
+type bypassCacheKey struct{}
+
+// WithoutCache returns ctx for a run that decodes its input even when the result is
+// cached, as profiling a run needs. The fresh result is still cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func bypassCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
+
 type processService struct {
-	NumCPU int
+	NumCPU  int
+	Cache   *cache.ResultCache
+	Runs    *store.RunStore
+	Cluster *cluster.Coordinator
 }
 
 type ProcessService interface {
-	OneBillionRowChallange(input multipart.File, header *multipart.FileHeader) (map[string]*models.TempStat, error)
-	AnomalyDetection(input multipart.File) ([]*models.Anomaly, error)
+	OneBillionRowChallange(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions) (*models.AggregateResult, error)
+	AnomalyDetection(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions) (*models.AnomalyResult, error)
+	AggregateStream(ctx context.Context, input io.Reader, name string, opts models.ProcessOptions) (*models.AggregateResult, error)
+	StreamAnomalies(ctx context.Context, input io.Reader, name string, opts models.ProcessOptions, emit func(*models.Anomaly) error) (*models.AnomalyResult, error)
+	Ingest(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions, w io.Writer) (int64, error)
+	ExportPartials(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions, sketches bool) (*models.PartialSet, error)
 }
 
-func NewProcessService(numCPU int) ProcessService {
-	fmt.Fprintf(os.Stderr, "🧠 CPU Cores Available : %d\n", numCPU)
-	fmt.Fprintf(os.Stderr, "🧵 Decode Workers       : %d\n", numCPU)
+// NewProcessService creates the processing service. resultCache, runs and coordinator may
+// be nil to disable caching, run history and decoding on cluster workers.
+func NewProcessService(numCPU int, resultCache *cache.ResultCache, runs *store.RunStore, coordinator *cluster.Coordinator) ProcessService {
+	slog.Info("process service ready", "cpus", runtime.NumCPU(), "decode_workers", numCPU,
+		"cache", resultCache != nil, "run_history", runs != nil, "cluster", coordinator != nil)
 
 	return &processService{
-		NumCPU: numCPU,
+		NumCPU:  numCPU,
+		Cache:   resultCache,
+		Runs:    runs,
+		Cluster: coordinator,
 	}
 }
 
-func (ps *processService) OneBillionRowChallange(input multipart.File, header *multipart.FileHeader) (map[string]*models.TempStat, error) {
-	// start := time.Now()
+func (ps *processService) OneBillionRowChallange(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions) (*models.AggregateResult, error) {
+	defer metrics.Track(metrics.OpAggregate)()
+	start := time.Now()
 	// Validate the number of CPU cores
 	if ps.NumCPU <= 0 {
 		return nil, fmt.Errorf("invalid number of CPU cores: %d", ps.NumCPU)
@@ -44,111 +86,382 @@ func (ps *processService) OneBillionRowChallange(input multipart.File, header *m
 	if header.Size <= 0 {
 		return nil, fmt.Errorf("input file is empty or has invalid size: %d", header.Size)
 	}
-	// Split and decode the multipart file
-	workerResults, err := utilities.SplitAndDecodeMultipartFileSmart(input, header, ps.NumCPU)
+	// Validate the options and resolve named columns against the header row
+	opts, err := brc.Prepare(opts, input, header.Size)
+	if err != nil {
+		return nil, err
+	}
+	run := newRun(models.RunAggregate, header, opts)
+
+	var upload *utilities.Upload
+	if opts.Format == models.FormatColumnar {
+		// Columnar uploads are decoded straight from the multipart file, so they are only hashed
+		run.InputHash, err = utilities.HashContent(io.NewSectionReader(input, 0, header.Size))
+	} else {
+		// Spool the upload once, hashing it on the way, and only decode on a cache miss
+		if upload, err = ps.spoolUpload(ctx, input, header); err == nil {
+			defer upload.Close()
+			run.InputHash = upload.Hash
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	return ps.aggregate(ctx, start, run, opts, input, upload)
+}
+
+// AggregateStream aggregates an input of unknown size read from r, such as a streamed
+// upload, like OneBillionRowChallange. The input is spooled once to the spool dir, where
+// cluster workers can read it too.
+func (ps *processService) AggregateStream(ctx context.Context, input io.Reader, name string, opts models.ProcessOptions) (*models.AggregateResult, error) {
+	defer metrics.Track(metrics.OpAggregate)()
+	start := time.Now()
+	if ps.NumCPU <= 0 {
+		return nil, fmt.Errorf("invalid number of CPU cores: %d", ps.NumCPU)
+	}
+	if input == nil {
+		return nil, fmt.Errorf("input is nil")
+	}
+	upload, err := ps.spoolStream(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	defer upload.Close()
+	if upload.Size <= 0 {
+		return nil, fmt.Errorf("input file is empty or has invalid size: %d", upload.Size)
+	}
+	if opts, err = brc.Prepare(opts, upload, upload.Size); err != nil {
+		return nil, err
+	}
+	run := newRun(models.RunAggregate, &multipart.FileHeader{Filename: name, Size: upload.Size}, opts)
+	run.InputHash = upload.Hash
+	return ps.aggregate(ctx, start, run, opts, upload, upload)
+}
+
+// aggregate answers a hashed input from the cache or decodes and merges it, and records
+// the run. Spooled inputs come with their upload; columnar ones are only read from input.
+func (ps *processService) aggregate(ctx context.Context, start time.Time, run *models.Run, opts models.ProcessOptions, input io.ReaderAt, upload *utilities.Upload) (*models.AggregateResult, error) {
+	spooled := time.Now()
+	run.Timings.Spool = spooled.Sub(start).Seconds()
+	metrics.ObserveStage(metrics.StageSpool, start)
+
+	key := cache.Key(run.InputHash, opts)
+	var finalResult map[string]*models.TempStat
+	cached := false
+	if !bypassCache(ctx) {
+		finalResult, cached = ps.Cache.Get(key)
+	}
+	if !cached {
+		var partials []models.Partial
+		var err error
+		if upload == nil {
+			partials, err = ps.decodeLocal(ctx, input, run.InputBytes, opts)
+		} else {
+			partials, err = ps.decode(ctx, upload, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode multipart file: %w", err)
+		}
+		decoded := time.Now()
+		run.Timings.Decode = decoded.Sub(spooled).Seconds()
+
+		// Merge + output
+		_, step := PipelineObserver.StartStep(ctx, "merge", brc.Attr{Key: "merge.partials", Value: int64(len(partials))})
+		finalResult = brc.Merge(partials).Stations
+		step.End(nil)
+		PipelineObserver.Stage(brc.StageMerge, time.Since(decoded))
+		run.Timings.Merge = time.Since(decoded).Seconds()
+		if err := ps.Cache.Put(key, finalResult); err != nil {
+			slog.WarnContext(ctx, "result cache write failed", "error", err)
+		}
+	}
+	run.Cached = cached
+	for _, stat := range finalResult {
+		run.Rows += int64(stat.Count)
+	}
+	// Bucketed results hold one entry per station and bucket
+	run.Stations = brc.CountStations(finalResult)
+	PipelineObserver.Stations(brc.OpAggregate, run.Stations)
+	ps.record(ctx, run, start, finalResult, nil)
+	logRun(ctx, metrics.OpAggregate, start, "run_id", run.ID, "input", run.InputName, "bytes", run.InputBytes,
+		"rows", run.Rows, "stations", run.Stations, "cached", cached)
+	return &models.AggregateResult{Stations: finalResult, InputHash: run.InputHash, Cached: cached, RunID: run.ID}, nil
+}
+
+func (ps *processService) AnomalyDetection(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions) (*models.AnomalyResult, error) {
+	// Validate the input file
+	if input == nil || header == nil {
+		return nil, fmt.Errorf("input file or header is nil")
+	}
+	// Resolve named columns up front, so the run history shows the columns used
+	opts, err := brc.Prepare(opts, input, header.Size)
+	if err != nil {
+		return nil, err
+	}
+	return ps.StreamAnomalies(ctx, io.NewSectionReader(input, 0, header.Size), header.Filename, opts, nil)
+}
+
+// StreamAnomalies detects anomalies in input as it is read, hashing it on the way, and
+// passes each one to emit as soon as it is found. The input is never spooled, so it can be
+// a stream still arriving. When emit fails, detection still finishes and is recorded, but
+// emit is not called again and its error is returned.
+func (ps *processService) StreamAnomalies(ctx context.Context, input io.Reader, name string, opts models.ProcessOptions, emit func(*models.Anomaly) error) (*models.AnomalyResult, error) {
+	defer metrics.Track(metrics.OpAnomaly)()
+	start := time.Now()
+	if input == nil {
+		return nil, fmt.Errorf("input is nil")
+	}
+	hash := sha256.New()
+	counted := &countingReader{r: io.TeeReader(input, hash)}
+	report, err := brc.DetectAnomalies(ctx, counted, opts.Rules,
+		brc.WithWorkers(ps.NumCPU), brc.WithProcessOptions(opts), brc.OnAnomaly(emit), brc.WithPool(admission.PoolFrom(ctx)),
+		brc.WithObserver(PipelineObserver))
+	if report == nil {
+		return nil, err
+	}
+	run := newRun(models.RunAnomaly, &multipart.FileHeader{Filename: name, Size: counted.n}, opts)
+	run.InputHash = hex.EncodeToString(hash.Sum(nil))
+	run.Timings.Detect = time.Since(start).Seconds()
+	run.Rows = report.Rows
+	run.Stations = report.Stations
+	run.Anomalies = len(report.Anomalies)
+	ps.record(ctx, run, start, nil, report.Anomalies)
+	logRun(ctx, metrics.OpAnomaly, start, "run_id", run.ID, "input", run.InputName, "bytes", run.InputBytes,
+		"rows", run.Rows, "stations", run.Stations, "anomalies", run.Anomalies)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AnomalyResult{Anomalies: report.Anomalies, RunID: run.ID}, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Ingest converts a text or NDJSON upload into the columnar format and writes it to w.
+func (ps *processService) Ingest(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions, w io.Writer) (int64, error) {
+	defer metrics.Track(metrics.OpIngest)()
+	start := time.Now()
+	if input == nil || header == nil {
+		return 0, fmt.Errorf("input file or header is nil")
+	}
+	rows, err := brc.Ingest(ctx, input, header.Size, w, brc.WithProcessOptions(opts), brc.WithObserver(PipelineObserver))
+	if err != nil {
+		return rows, err
+	}
+	logRun(ctx, metrics.OpIngest, start, "input", header.Filename, "bytes", header.Size, "rows", rows)
+	return rows, nil
+}
+
+// ExportPartials decodes an upload without merging it and returns the state of every
+// decode worker, so the merge can happen elsewhere. With sketches set each station also
+// carries a sketch of its values.
+func (ps *processService) ExportPartials(ctx context.Context, input multipart.File, header *multipart.FileHeader, opts models.ProcessOptions, sketches bool) (*models.PartialSet, error) {
+	defer metrics.Track(metrics.OpPartials)()
+	start := time.Now()
+	if input == nil || header == nil {
+		return nil, fmt.Errorf("input file or header is nil")
+	}
+	if header.Size <= 0 {
+		return nil, fmt.Errorf("input file is empty or has invalid size: %d", header.Size)
+	}
+	options := []brc.Option{brc.WithWorkers(ps.NumCPU), brc.WithProcessOptions(opts), brc.WithPool(admission.PoolFrom(ctx)),
+		brc.WithObserver(PipelineObserver)}
+	if sketches {
+		options = append(options, brc.WithSketches())
+	}
+	partials, err := brc.Partials(ctx, input, header.Size, options...)
+	if errors.Is(err, ErrInvalidOptions) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode multipart file: %w", err)
 	}
+	set := &models.PartialSet{Version: utilities.PartialsVersion, Partials: partials}
+	if opts.Time.Bucket > 0 {
+		set.Bucket = opts.Time.Bucket.String()
+	}
+	var rows int64
+	for _, p := range partials {
+		for _, stat := range p.Stations {
+			rows += int64(stat.Count)
+		}
+	}
+	logRun(ctx, metrics.OpPartials, start, "input", header.Filename, "bytes", header.Size, "rows", rows, "partials", len(partials))
+	return set, nil
+}
+
+// spoolDir is where uploads are spooled: the directory shared with the cluster workers
+// when they are sent paths, and the temp dir otherwise.
+func (ps *processService) spoolDir() string {
+	if ps.Cluster == nil || !ps.Cluster.Config().SharedPath {
+		return ""
+	}
+	return ps.Cluster.Config().SharedDir
+}
+
+// spoolUpload spools a multipart upload to the spool dir when it is too large to decode
+// from memory. The spool is traced and counted in the temp file gauge while on disk.
+func (ps *processService) spoolUpload(ctx context.Context, input multipart.File, header *multipart.FileHeader) (*utilities.Upload, error) {
+	_, span := tracing.Start(ctx, "spool", attribute.Int64("upload.size", header.Size))
+	upload, err := utilities.SpoolUploadIn(ctx, ps.spoolDir(), input, header, observeTempFile)
+	if err == nil {
+		span.SetAttributes(attribute.Bool("upload.spooled", upload.Path() != ""))
+	}
+	tracing.End(span, err)
+	return upload, err
+}
+
+// spoolStream spools an input of unknown size to the spool dir, like spoolUpload.
+func (ps *processService) spoolStream(ctx context.Context, input io.Reader) (*utilities.Upload, error) {
+	_, span := tracing.Start(ctx, "spool")
+	upload, err := utilities.SpoolStream(ctx, ps.spoolDir(), input, observeTempFile)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("upload.size", upload.Size), attribute.Bool("upload.spooled", true))
+	}
+	tracing.End(span, err)
+	return upload, err
+}
+
+// observeTempFile tracks spooled uploads in the temp file gauge.
+func observeTempFile(bytes int64) {
+	metrics.TempFileBytes.Add(float64(bytes))
+}
 
-	// Merge + output
-	finalResult := utilities.MergeResults(workerResults)
-
-	// Temporary commented out logging to avoid interleaving
-	// totalDone := time.Since(start)
-	// var logBuf bytes.Buffer
-	// showUsage(totalDone, &logBuf)
-	// fmt.Print(logBuf.String())
-	return finalResult, nil
-}
-
-func (ps *processService) AnomalyDetection(input multipart.File) ([]*models.Anomaly, error) {
-	// start := time.Now()
-	lines := make(chan []byte, 10000)
-	splits := make(chan models.LineSplit, 10000)
-	anomalies := make(chan models.Anomaly, 1000)
-
-	// Shard stationTemps and mutexes per worker to reduce contention
-	stationTempsShards := make([]map[string]float32, ps.NumCPU)
-	statsMuShards := make([]sync.Mutex, ps.NumCPU)
-	var anomalyCount int32
-	var spikeCount int32
-
-	// Initialize shards and mutexes
-	go utilities.ReadMultipartFile(input, lines)
-
-	// Split lines into LineSplit entries
-	go utilities.SplitLines(lines, splits)
-
-	// Initialize shards and mutexes
-	var wg sync.WaitGroup
-	for i := 0; i < ps.NumCPU; i++ {
-		stationTempsShards[i] = make(map[string]float32)
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			for entry := range splits {
-				// Simple sharding: assign by hash of station name
-				shard := int(entry.Station[0]) % ps.NumCPU
-				if shard != idx {
-					continue
+// decode decodes a spooled upload on the cluster workers when any are registered, and
+// locally otherwise or when the cluster loses all of its workers.
+func (ps *processService) decode(ctx context.Context, upload *utilities.Upload, opts models.ProcessOptions) ([]models.Partial, error) {
+	// Columnar uploads are decoded locally, the workers only read text and NDJSON ranges
+	if path := upload.Path(); path != "" && opts.Format != models.FormatColumnar && ps.Cluster.Slots() > 0 {
+		start := time.Now()
+		spanCtx, span := tracing.Start(ctx, "cluster_decode", attribute.Int64("input.size", upload.Size))
+		results, err := ps.Cluster.Decode(spanCtx, path, cluster.SpecFromOptions(opts))
+		tracing.End(span, err)
+		if !errors.Is(err, cluster.ErrNoWorkers) {
+			if err == nil {
+				metrics.ObserveStage(metrics.StageDecode, start)
+				metrics.Bytes.WithLabelValues(metrics.OpAggregate).Add(float64(upload.Size))
+				var rows int64
+				for _, stations := range results {
+					for _, stat := range stations {
+						rows += int64(stat.Count)
+					}
 				}
-				utilities.DetectAnomalies(
-					makeSingleEntryChan(entry),
-					anomalies,
-					stationTempsShards[idx],
-					&statsMuShards[idx],
-					&anomalyCount,
-					&spikeCount,
-				)
+				metrics.Rows.WithLabelValues(metrics.OpAggregate).Add(float64(rows))
 			}
-		}(i)
+			partials := make([]models.Partial, len(results))
+			for i, stations := range results {
+				partials[i] = models.Partial{Stations: stations}
+			}
+			return partials, err
+		}
+		slog.WarnContext(ctx, "cluster unavailable, decoding locally", "error", err)
 	}
+	return ps.decodeLocal(ctx, upload, upload.Size, opts)
+}
 
-	// Wait for all workers to finish processing
-	go func() {
-		wg.Wait()
-		close(anomalies)
-	}()
+// decodeLocal decodes an input with the engine and returns the per-worker results.
+func (ps *processService) decodeLocal(ctx context.Context, input io.ReaderAt, size int64, opts models.ProcessOptions) ([]models.Partial, error) {
+	return brc.Partials(ctx, input, size,
+		brc.WithWorkers(ps.NumCPU), brc.WithProcessOptions(opts), brc.WithOperation(metrics.OpAggregate),
+		brc.WithPool(admission.PoolFrom(ctx)), brc.WithObserver(PipelineObserver))
+}
 
-	// Wait for all anomalies to be processed
-	var detectedAnomalies []*models.Anomaly
-	for anomaly := range anomalies {
-		detectedAnomalies = append(detectedAnomalies, &anomaly)
+// newRun starts the history record of a run.
+func newRun(kind models.RunKind, header *multipart.FileHeader, opts models.ProcessOptions) *models.Run {
+	run := &models.Run{
+		Kind:       kind,
+		InputName:  header.Filename,
+		InputBytes: header.Size,
+		Options:    describeOptions(opts),
 	}
+	if kind == models.RunAnomaly {
+		describeRules(run.Options, opts.Rules)
+	}
+	return run
+}
 
-	// Temporary commented out logging to avoid interleaving
-	// // Buffered logging to avoid log interleaving
-	// var logBuf bytes.Buffer
-	// logBuf.WriteString("✅ Anomaly Detection Complete\n")
-	// logBuf.WriteString(fmt.Sprintf("📊 Total Anomalies Detected : %d\n", anomalyCount))
-	// logBuf.WriteString(fmt.Sprintf("⚠️  Spike Anomalies         : %d\n", spikeCount))
-
-	// // Calculate and log the total time taken
-	// totalDone := time.Since(start)
-	// showUsage(totalDone, &logBuf)
-	// fmt.Print(logBuf.String())
-	return detectedAnomalies, nil
+// record stores a finished run in the run history. A failure is logged and does not fail the run.
+func (ps *processService) record(ctx context.Context, run *models.Run, start time.Time, stations map[string]*models.TempStat, anomalies []*models.Anomaly) {
+	run.Timings.Total = time.Since(start).Seconds()
+	if err := ps.Runs.Record(run, stations, anomalies); err != nil {
+		slog.WarnContext(ctx, "recording run failed", "run_id", run.ID, "error", err)
+	}
 }
 
-// makeSingleEntryChan returns a channel that yields a single LineSplit entry and then closes.
-func makeSingleEntryChan(entry models.LineSplit) <-chan models.LineSplit {
-	ch := make(chan models.LineSplit, 1)
-	ch <- entry
-	close(ch)
-	return ch
+// describeOptions lists the options of a run that differ from the defaults, keyed by request parameter name.
+func describeOptions(opts models.ProcessOptions) map[string]string {
+	desc := map[string]string{"input_format": string(opts.Format)}
+	if opts.Format == "" {
+		desc["input_format"] = string(models.FormatText)
+	}
+	set := func(key, value, def string) {
+		if value != def {
+			desc[key] = value
+		}
+	}
+	switch opts.Format {
+	case models.FormatNDJSON:
+		set("station_field", opts.JSON.Station, utilities.DefaultJSONFields.Station)
+		set("value_field", opts.JSON.Value, utilities.DefaultJSONFields.Value)
+		set("timestamp_field", opts.JSON.Timestamp, "")
+	case models.FormatColumnar:
+	default:
+		d, def := opts.Dialect, utilities.DefaultDialect
+		set("delimiter", string(d.Delimiter), string(def.Delimiter))
+		if d.Quote != 0 {
+			desc["quote"] = string(d.Quote)
+		}
+		if d.Comment != 0 {
+			desc["comment"] = string(d.Comment)
+		}
+		set("header", strconv.FormatBool(d.HasHeader), "false")
+		set("station_column", strconv.Itoa(d.StationColumn), strconv.Itoa(def.StationColumn))
+		set("value_column", strconv.Itoa(d.ValueColumn), strconv.Itoa(def.ValueColumn))
+		if d.HasTimestamp {
+			desc["timestamp_column"] = strconv.Itoa(d.TimestampColumn)
+		}
+	}
+	set("empty_policy", string(opts.Numeric.Empty), "")
+	set("nan_policy", string(opts.Numeric.NaN), "")
+	set("time_format", opts.Time.Format, "")
+	if opts.Time.Bucket > 0 {
+		desc["bucket"] = opts.Time.Bucket.String()
+	}
+	if opts.Time.Location != nil && opts.Time.Location != time.UTC {
+		desc["timezone"] = opts.Time.Location.String()
+	}
+	return desc
 }
 
-// showUsage logs the total time taken for the operation and memory usage statistics.
-func showUsage(totalDone time.Duration, logBuf *bytes.Buffer) {
-	// Log the total time taken for the operation
-	logBuf.WriteString(fmt.Sprintf("✅ Total Time Elapsed         : %.3fs\n", totalDone.Seconds()))
+// describeRules adds the anomaly thresholds of a detection run to its option list.
+func describeRules(desc map[string]string, rules models.AnomalyRules) {
+	desc["extreme_min"] = strconv.FormatFloat(float64(rules.ExtremeMin), 'g', -1, 32)
+	desc["extreme_max"] = strconv.FormatFloat(float64(rules.ExtremeMax), 'g', -1, 32)
+	desc["spike_delta"] = strconv.FormatFloat(float64(rules.SpikeDelta), 'g', -1, 32)
+	if rules.SpikeRate > 0 {
+		desc["spike_rate"] = strconv.FormatFloat(float64(rules.SpikeRate), 'g', -1, 32)
+	}
+}
 
-	// Memory usage statistics
+// logRun writes the summary line of a finished run, with the memory statistics of the process.
+func logRun(ctx context.Context, operation string, start time.Time, args ...any) {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
-	logBuf.WriteString("💾 Memory Usage:\n")
-	logBuf.WriteString(fmt.Sprintf("   Alloc        = %.2f MB\n", float64(mem.Alloc)/(1024*1024)))
-	logBuf.WriteString(fmt.Sprintf("   TotalAlloc   = %.2f MB\n", float64(mem.TotalAlloc)/(1024*1024)))
-	logBuf.WriteString(fmt.Sprintf("   Sys          = %.2f MB\n", float64(mem.Sys)/(1024*1024)))
-	logBuf.WriteString(fmt.Sprintf("   NumGC        = %v\n", mem.NumGC))
+	args = append([]any{"operation", operation, "duration_ms", time.Since(start).Milliseconds()}, args...)
+	args = append(args, slog.Group("memory",
+		"alloc_bytes", mem.Alloc,
+		"total_alloc_bytes", mem.TotalAlloc,
+		"sys_bytes", mem.Sys,
+		"num_gc", mem.NumGC,
+	))
+	slog.InfoContext(ctx, "run finished", args...)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// cancelGrace is how long cancelled runs get to return and remove their temp files
+// before their connections are closed.
+const cancelGrace = 5 * time.Second
+
+// newServer returns an HTTP server whose requests run under jobs, so cancelling jobs
+// cancels every request in flight.
+func newServer(addr string, handler http.Handler, jobs context.Context) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return jobs },
+	}
+}
+
+// drain stops the servers from taking new requests and waits up to timeout for the
+// requests in flight. Runs still going then are cancelled through cancelJobs; the gRPC
+// server, which may be nil, cancels its own streams.
+func drain(servers []*http.Server, grpcServer *grpc.Server, timeout time.Duration, cancelJobs context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			err := srv.Shutdown(ctx)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				return
+			}
+			slog.Warn("drain deadline passed, cancelling requests in flight", "addr", srv.Addr)
+			cancelJobs()
+			grace, cancel := context.WithTimeout(context.Background(), cancelGrace)
+			defer cancel()
+			if err := srv.Shutdown(grace); err != nil {
+				srv.Close()
+			}
+		}(srv)
+	}
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				slog.Warn("drain deadline passed, cancelling gRPC calls in flight")
+				grpcServer.Stop()
+				<-stopped
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("drained", "duration_ms", time.Since(start).Milliseconds())
+}
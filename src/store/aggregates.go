@@ -0,0 +1,449 @@
+package store
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrAggregateNotFound is returned when no aggregate has the requested name.
+	ErrAggregateNotFound = errors.New("aggregate not found")
+	// ErrSnapshotNotFound is returned when an aggregate has no snapshot with the requested ID.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrInvalidName is returned for aggregate names that cannot be used in a URL path.
+	ErrInvalidName = errors.New("aggregate names must be 1-64 letters, digits, '.', '_' or '-'")
+	// ErrDuplicateInput is returned when an upload with the same content was already appended.
+	ErrDuplicateInput = errors.New("this input was already appended")
+	// ErrBucketMismatch is returned when an append uses another time bucket width than the aggregate.
+	ErrBucketMismatch = errors.New("time bucket differs from the aggregate")
+)
+
+var (
+	aggregatesBucket = []byte("aggregates") // name -> bucket with the keys below
+
+	metaKey      = []byte("meta")      // JSON models.AggregateInfo, or models.AggregateSnapshot in a snapshot
+	aggStations  = []byte("stations")  // result key -> JSON models.TempStat
+	aggInputs    = []byte("inputs")    // content hash -> JSON appendRecord
+	aggSnapshots = []byte("snapshots") // snapshot ID -> bucket with meta and stations
+
+	// snapshotSubkeys are the sub-buckets frozen by a snapshot.
+	snapshotSubkeys = [][]byte{aggStations}
+)
+
+var validAggregate = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// appendRecord remembers one upload merged into an aggregate.
+type appendRecord struct {
+	Name       string
+	Rows       int64
+	AppendedAt time.Time
+}
+
+// AggregateStore keeps named running aggregates. A nil store finds nothing.
+type AggregateStore struct {
+	db *bolt.DB
+}
+
+// OpenAggregates opens or creates the aggregate database. It returns a nil store when path is empty.
+func OpenAggregates(path string) (*AggregateStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+	db, err := openDB(path, aggregatesBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &AggregateStore{db: db}, nil
+}
+
+// Ping checks that the database file is still there and can be read.
+func (s *AggregateStore) Ping() error {
+	if s == nil {
+		return nil
+	}
+	return pingDB(s.db)
+}
+
+// Close closes the database.
+func (s *AggregateStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// ValidAggregateName reports whether name can be used for an aggregate.
+func ValidAggregateName(name string) bool {
+	return validAggregate.MatchString(name)
+}
+
+// Append merges stats into the named aggregate, creating it on first use. inputHash
+// identifies the upload so the same content is not counted twice unless force is set.
+// bucket is the time bucket width of stats ("" when not bucketed) and must match earlier appends.
+func (s *AggregateStore) Append(name, inputHash, inputName, bucket string, stats map[string]*models.TempStat, force bool) (*models.AggregateInfo, error) {
+	if s == nil {
+		return nil, ErrAggregateNotFound
+	}
+	if !ValidAggregateName(name) {
+		return nil, ErrInvalidName
+	}
+	var info models.AggregateInfo
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		agg, err := tx.Bucket(aggregatesBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if data := agg.Get(metaKey); data != nil {
+			if err := json.Unmarshal(data, &info); err != nil {
+				return err
+			}
+			if info.Appends > 0 && info.Bucket != bucket {
+				return fmt.Errorf("%w: aggregate uses %q", ErrBucketMismatch, info.Bucket)
+			}
+		} else {
+			info = models.AggregateInfo{Name: name, CreatedAt: now, Bucket: bucket}
+		}
+
+		inputs, err := agg.CreateBucketIfNotExists(aggInputs)
+		if err != nil {
+			return err
+		}
+		if inputHash != "" && inputs.Get([]byte(inputHash)) != nil && !force {
+			return ErrDuplicateInput
+		}
+
+		stations, err := agg.CreateBucketIfNotExists(aggStations)
+		if err != nil {
+			return err
+		}
+		current, err := readStations(stations)
+		if err != nil {
+			return err
+		}
+		incoming := make(map[string]models.TempStat, len(stats))
+		var rows int64
+		for key, stat := range stats {
+			incoming[key] = *stat
+			rows += int64(stat.Count)
+		}
+		// Reduce the stored state and the new upload exactly like per-worker partials
+		merged := utilities.MergeResults([]map[string]models.TempStat{current, incoming})
+		if err := writeStations(stations, merged); err != nil {
+			return err
+		}
+
+		if inputHash != "" {
+			record, err := json.Marshal(appendRecord{Name: inputName, Rows: rows, AppendedAt: now})
+			if err != nil {
+				return err
+			}
+			if err := inputs.Put([]byte(inputHash), record); err != nil {
+				return err
+			}
+		}
+		info.Appends++
+		info.Rows += rows
+		info.Stations = countStations(merged)
+		info.UpdatedAt = now
+		return putJSON(agg, metaKey, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// CheckInput returns ErrDuplicateInput when an upload with inputHash was already appended
+// to the named aggregate, so a duplicate can be refused before it is decoded. An aggregate
+// that does not exist yet has no inputs.
+func (s *AggregateStore) CheckInput(name, inputHash string) error {
+	if s == nil {
+		return ErrAggregateNotFound
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+		if agg == nil {
+			return nil
+		}
+		if inputs := agg.Bucket(aggInputs); inputs != nil && inputs.Get([]byte(inputHash)) != nil {
+			return ErrDuplicateInput
+		}
+		return nil
+	})
+}
+
+// List returns every aggregate, ordered by name.
+func (s *AggregateStore) List() ([]models.AggregateInfo, error) {
+	if s == nil {
+		return nil, nil
+	}
+	var list []models.AggregateInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aggregatesBucket).ForEachBucket(func(name []byte) error {
+			var info models.AggregateInfo
+			data := tx.Bucket(aggregatesBucket).Bucket(name).Get(metaKey)
+			if err := json.Unmarshal(data, &info); err != nil {
+				return fmt.Errorf("aggregate %s: %w", name, err)
+			}
+			list = append(list, info)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// Get returns the current state of a named aggregate.
+func (s *AggregateStore) Get(name string) (*models.AggregateInfo, map[string]*models.TempStat, error) {
+	if s == nil {
+		return nil, nil, ErrAggregateNotFound
+	}
+	var info models.AggregateInfo
+	var stats map[string]*models.TempStat
+	err := s.db.View(func(tx *bolt.Tx) error {
+		agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+		if agg == nil {
+			return ErrAggregateNotFound
+		}
+		var err error
+		stats, err = readInfoAndStations(agg, &info)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &info, stats, nil
+}
+
+// Delete removes a named aggregate with its snapshots.
+func (s *AggregateStore) Delete(name string) error {
+	if s == nil {
+		return ErrAggregateNotFound
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(aggregatesBucket).DeleteBucket([]byte(name))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return ErrAggregateNotFound
+		}
+		return err
+	})
+}
+
+// Snapshot freezes the current state of an aggregate under a new, time-ordered ID.
+func (s *AggregateStore) Snapshot(name string) (*models.AggregateSnapshot, error) {
+	if s == nil {
+		return nil, ErrAggregateNotFound
+	}
+	var snap models.AggregateSnapshot
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+		if agg == nil {
+			return ErrAggregateNotFound
+		}
+		if err := json.Unmarshal(agg.Get(metaKey), &snap.Aggregate); err != nil {
+			return err
+		}
+		snap.CreatedAt = time.Now().UTC()
+		snap.ID = NewRunID(snap.CreatedAt)
+
+		snapshots, err := agg.CreateBucketIfNotExists(aggSnapshots)
+		if err != nil {
+			return err
+		}
+		dst, err := snapshots.CreateBucket([]byte(snap.ID))
+		if err != nil {
+			return err
+		}
+		if err := putJSON(dst, metaKey, snap); err != nil {
+			return err
+		}
+		return copyBuckets(agg, dst, snapshotSubkeys)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Snapshots lists the snapshots of an aggregate, oldest first.
+func (s *AggregateStore) Snapshots(name string) ([]models.AggregateSnapshot, error) {
+	if s == nil {
+		return nil, ErrAggregateNotFound
+	}
+	list := []models.AggregateSnapshot{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+		if agg == nil {
+			return ErrAggregateNotFound
+		}
+		snapshots := agg.Bucket(aggSnapshots)
+		if snapshots == nil {
+			return nil
+		}
+		return snapshots.ForEachBucket(func(id []byte) error {
+			var snap models.AggregateSnapshot
+			if err := json.Unmarshal(snapshots.Bucket(id).Get(metaKey), &snap); err != nil {
+				return err
+			}
+			list = append(list, snap)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// GetSnapshot returns the state of an aggregate frozen in a snapshot.
+func (s *AggregateStore) GetSnapshot(name, id string) (*models.AggregateSnapshot, map[string]*models.TempStat, error) {
+	if s == nil {
+		return nil, nil, ErrAggregateNotFound
+	}
+	var snap models.AggregateSnapshot
+	var stats map[string]*models.TempStat
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := snapshotBucket(tx, name, id)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b.Get(metaKey), &snap); err != nil {
+			return err
+		}
+		current, err := readStations(b.Bucket(aggStations))
+		stats = pointers(current)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &snap, stats, nil
+}
+
+// Restore replaces the current state of an aggregate with a snapshot. Appended input
+// hashes are kept, so uploads made after the snapshot can be appended again only with force.
+func (s *AggregateStore) Restore(name, id string) (*models.AggregateInfo, error) {
+	if s == nil {
+		return nil, ErrAggregateNotFound
+	}
+	var info models.AggregateInfo
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		src, err := snapshotBucket(tx, name, id)
+		if err != nil {
+			return err
+		}
+		var snap models.AggregateSnapshot
+		if err := json.Unmarshal(src.Get(metaKey), &snap); err != nil {
+			return err
+		}
+		agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+		if err := agg.DeleteBucket(aggStations); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		if err := copyBuckets(src, agg, snapshotSubkeys); err != nil {
+			return err
+		}
+		info = snap.Aggregate
+		info.UpdatedAt = time.Now().UTC()
+		return putJSON(agg, metaKey, info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func snapshotBucket(tx *bolt.Tx, name, id string) (*bolt.Bucket, error) {
+	agg := tx.Bucket(aggregatesBucket).Bucket([]byte(name))
+	if agg == nil {
+		return nil, ErrAggregateNotFound
+	}
+	snapshots := agg.Bucket(aggSnapshots)
+	if snapshots == nil || snapshots.Bucket([]byte(id)) == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return snapshots.Bucket([]byte(id)), nil
+}
+
+func readInfoAndStations(agg *bolt.Bucket, info *models.AggregateInfo) (map[string]*models.TempStat, error) {
+	if err := json.Unmarshal(agg.Get(metaKey), info); err != nil {
+		return nil, err
+	}
+	current, err := readStations(agg.Bucket(aggStations))
+	return pointers(current), err
+}
+
+// readStations loads a stations bucket in the value form MergeResults takes.
+func readStations(b *bolt.Bucket) (map[string]models.TempStat, error) {
+	stats := make(map[string]models.TempStat)
+	if b == nil {
+		return stats, nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		var stat models.TempStat
+		if err := json.Unmarshal(v, &stat); err != nil {
+			return fmt.Errorf("station %q: %w", k, err)
+		}
+		stats[string(k)] = stat
+		return nil
+	})
+	return stats, err
+}
+
+func writeStations(b *bolt.Bucket, stats map[string]*models.TempStat) error {
+	for key, stat := range stats {
+		if err := putJSON(b, []byte(key), stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pointers(stats map[string]models.TempStat) map[string]*models.TempStat {
+	out := make(map[string]*models.TempStat, len(stats))
+	for key, stat := range stats {
+		stat := stat
+		out[key] = &stat
+	}
+	return out
+}
+
+// countStations counts distinct stations, folding the buckets of a bucketed result.
+func countStations(stats map[string]*models.TempStat) int {
+	names := make(map[string]struct{}, len(stats))
+	for key := range stats {
+		name, _, _ := utilities.SplitBucketKey(key)
+		names[name] = struct{}{}
+	}
+	return len(names)
+}
+
+// copyBuckets copies the named flat sub-buckets of src into dst.
+func copyBuckets(src, dst *bolt.Bucket, names [][]byte) error {
+	for _, name := range names {
+		from := src.Bucket(name)
+		if from == nil {
+			continue
+		}
+		to, err := dst.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		if err := from.ForEach(func(k, v []byte) error { return to.Put(k, v) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
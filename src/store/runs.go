@@ -0,0 +1,348 @@
+// Package store keeps run history and named running aggregates in embedded bbolt
+// databases so results outlive the HTTP response that produced them.
+package store
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrRunNotFound is returned when no run has the requested ID.
+	ErrRunNotFound = errors.New("run not found")
+	// ErrStationNotFound is returned when a run has no results or anomalies for a station.
+	ErrStationNotFound = errors.New("station not found in run")
+)
+
+var (
+	runsBucket      = []byte("runs")      // run ID -> JSON models.Run
+	stationsBucket  = []byte("stations")  // run ID -> bucket of result key -> JSON models.TempStat
+	anomaliesBucket = []byte("anomalies") // run ID -> bucket of sequence -> JSON models.Anomaly
+)
+
+// Config locates the database and limits how much history is kept.
+type Config struct {
+	Path    string        // database file, "" disables run history
+	MaxRuns int           // 0 keeps any number of runs
+	MaxAge  time.Duration // 0 keeps runs forever
+}
+
+// RunStore is the run history. A nil store records nothing and finds nothing.
+type RunStore struct {
+	db  *bolt.DB
+	cfg Config
+}
+
+// Open opens or creates the run database and applies the retention limits once.
+// It returns a nil store when cfg.Path is empty.
+func Open(cfg Config) (*RunStore, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	db, err := openDB(cfg.Path, runsBucket, stationsBucket, anomaliesBucket)
+	if err != nil {
+		return nil, err
+	}
+	s := &RunStore{db: db, cfg: cfg}
+	if err := s.db.Update(s.prune); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openDB opens or creates a bbolt database with the given top-level buckets.
+func openDB(path string, buckets ...[]byte) (*bolt.DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("store directory: %w", err)
+		}
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialise store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Ping checks that the database file is still there and can be read.
+func (s *RunStore) Ping() error {
+	if s == nil {
+		return nil
+	}
+	return pingDB(s.db)
+}
+
+// pingDB checks that the file of db still exists and a read transaction succeeds.
+func pingDB(db *bolt.DB) error {
+	if _, err := os.Stat(db.Path()); err != nil {
+		return err
+	}
+	return db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Close closes the database.
+func (s *RunStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// NewRunID returns a unique ID that sorts by creation time.
+func NewRunID(created time.Time) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return fmt.Sprintf("%016x%s", created.UnixNano(), hex.EncodeToString(suffix[:]))
+}
+
+// runTime recovers the creation time encoded in a run ID.
+func runTime(id []byte) (time.Time, bool) {
+	if len(id) < 16 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(string(id[:16]), 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// Record stores a run with its per-station results and anomalies, then drops runs
+// beyond the retention limits. It assigns the ID and creation time when they are empty.
+func (s *RunStore) Record(run *models.Run, stations map[string]*models.TempStat, anomalies []*models.Anomaly) error {
+	if s == nil {
+		return nil
+	}
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = time.Now().UTC()
+	}
+	if run.ID == "" {
+		run.ID = NewRunID(run.CreatedAt)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		id := []byte(run.ID)
+		if err := tx.Bucket(runsBucket).Put(id, data); err != nil {
+			return err
+		}
+
+		if len(stations) > 0 {
+			b, err := tx.Bucket(stationsBucket).CreateBucketIfNotExists(id)
+			if err != nil {
+				return err
+			}
+			for key, stat := range stations {
+				data, err := json.Marshal(stat)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte(key), data); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(anomalies) > 0 {
+			b, err := tx.Bucket(anomaliesBucket).CreateBucketIfNotExists(id)
+			if err != nil {
+				return err
+			}
+			for i, a := range anomalies {
+				data, err := json.Marshal(a)
+				if err != nil {
+					return err
+				}
+				if err := b.Put(sequenceKey(i), data); err != nil {
+					return err
+				}
+			}
+		}
+		return s.prune(tx)
+	})
+}
+
+func sequenceKey(i int) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(i))
+	return key[:]
+}
+
+// prune deletes the oldest runs until the store fits MaxRuns and MaxAge.
+func (s *RunStore) prune(tx *bolt.Tx) error {
+	if s.cfg.MaxRuns <= 0 && s.cfg.MaxAge <= 0 {
+		return nil
+	}
+	c := tx.Bucket(runsBucket).Cursor()
+	excess := 0
+	if s.cfg.MaxRuns > 0 {
+		// Bucket stats only cover committed pages, so count the keys of this transaction directly
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			excess++
+		}
+		excess -= s.cfg.MaxRuns
+	}
+	var expired [][]byte
+	// IDs sort by creation time, so the oldest runs come first
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		created, ok := runTime(k)
+		tooOld := s.cfg.MaxAge > 0 && ok && time.Since(created) > s.cfg.MaxAge
+		if excess <= 0 && !tooOld {
+			break
+		}
+		expired = append(expired, bytes.Clone(k))
+		excess--
+	}
+	for _, id := range expired {
+		if err := deleteRun(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteRun(tx *bolt.Tx, id []byte) error {
+	if err := tx.Bucket(runsBucket).Delete(id); err != nil {
+		return err
+	}
+	for _, name := range [][]byte{stationsBucket, anomaliesBucket} {
+		if err := tx.Bucket(name).DeleteBucket(id); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns run summaries newest first, optionally only of one kind, together with
+// the number of matching runs before paging. A limit of 0 returns every run.
+func (s *RunStore) List(kind models.RunKind, offset, limit int) ([]models.Run, int, error) {
+	if s == nil {
+		return nil, 0, nil
+	}
+	var runs []models.Run
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var run models.Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return fmt.Errorf("run %s: %w", k, err)
+			}
+			if kind != "" && run.Kind != kind {
+				continue
+			}
+			total++
+			if total <= offset || (limit > 0 && len(runs) >= limit) {
+				continue
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, total, err
+}
+
+// Get returns a run with all of its per-station results and anomalies.
+func (s *RunStore) Get(id string) (*models.Run, map[string]*models.TempStat, []*models.Anomaly, error) {
+	return s.load(id, "")
+}
+
+// Station returns a run with the results and anomalies of one station. Bucketed runs
+// return every bucket of the station.
+func (s *RunStore) Station(id, station string) (*models.Run, map[string]*models.TempStat, []*models.Anomaly, error) {
+	if station == "" {
+		return nil, nil, nil, ErrStationNotFound
+	}
+	return s.load(id, station)
+}
+
+// load reads a run and its details, limited to one station when station is not empty.
+func (s *RunStore) load(id, station string) (*models.Run, map[string]*models.TempStat, []*models.Anomaly, error) {
+	if s == nil {
+		return nil, nil, nil, ErrRunNotFound
+	}
+	var run models.Run
+	stats := make(map[string]*models.TempStat)
+	var anomalies []*models.Anomaly
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(runsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrRunNotFound
+		}
+		if err := json.Unmarshal(data, &run); err != nil {
+			return err
+		}
+
+		if b := tx.Bucket(stationsBucket).Bucket([]byte(id)); b != nil {
+			c := b.Cursor()
+			k, v := c.First()
+			if station != "" {
+				k, v = c.Seek([]byte(station))
+			}
+			for ; k != nil; k, v = c.Next() {
+				if station != "" {
+					if !bytes.HasPrefix(k, []byte(station)) {
+						break
+					}
+					if name, _, _ := utilities.SplitBucketKey(string(k)); name != station {
+						continue
+					}
+				}
+				var stat models.TempStat
+				if err := json.Unmarshal(v, &stat); err != nil {
+					return err
+				}
+				stats[string(k)] = &stat
+			}
+		}
+
+		if b := tx.Bucket(anomaliesBucket).Bucket([]byte(id)); b != nil {
+			return b.ForEach(func(_, v []byte) error {
+				var a models.Anomaly
+				if err := json.Unmarshal(v, &a); err != nil {
+					return err
+				}
+				if station == "" || a.Station == station {
+					anomalies = append(anomalies, &a)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if station != "" && len(stats) == 0 && len(anomalies) == 0 {
+		return nil, nil, nil, ErrStationNotFound
+	}
+	return &run, stats, anomalies, nil
+}
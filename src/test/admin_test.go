@@ -0,0 +1,103 @@
+package test
+
+import (
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func adminRequest(router http.Handler, method, target string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutesOnlyWhenEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := http_delivery.NewClientHandler(2, nil, nil, nil, nil)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+	if w := adminRequest(api.Router, http.MethodGet, "/debug/pprof/heap", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("pprof served without being enabled: %d", w.Code)
+	}
+
+	admin := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	admin.SetupAdminServer()
+	for _, target := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+		if w := adminRequest(admin.Router, http.MethodGet, target, nil, ""); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET %s = %d", target, w.Code)
+		}
+	}
+	if w := adminRequest(admin.Router, http.MethodGet, "/one-billion-row-challenge", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("The admin router serves the API: %d", w.Code)
+	}
+
+	w := adminRequest(admin.Router, http.MethodGet, "/debug/runtime", nil, "")
+	var stats struct {
+		GoVersion  string `json:"go_version"`
+		Goroutines int    `json:"goroutines"`
+		Memory     struct {
+			Alloc uint64 `json:"alloc_bytes"`
+		} `json:"memory"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || stats.GoVersion == "" || stats.Goroutines == 0 || stats.Memory.Alloc == 0 {
+		t.Errorf("Unexpected runtime stats %s: %v", w.Body, err)
+	}
+}
+
+func TestProfileRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	admin := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, nil, nil, nil)}
+	admin.SetupAdminServer()
+
+	upload := func(query string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "measurements.txt")
+		part.Write([]byte(strings.Repeat("A;1.0\nB;75.0\n", 1000)))
+		mw.Close()
+		return adminRequest(admin.Router, http.MethodPost, "/debug/pprof/run"+query, &body, mw.FormDataContentType())
+	}
+
+	for _, query := range []string{"", "?operation=anomaly"} {
+		w := upload(query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /debug/pprof/run%s = %d: %s", query, w.Code, w.Body)
+		}
+		// Profiles are gzipped protocol buffers
+		if b := w.Body.Bytes(); len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+			t.Errorf("%s: the answer is not a profile", query)
+		}
+		if !strings.Contains(w.Header().Get("Content-Disposition"), "measurements-cpu.pprof") {
+			t.Errorf("%s: Content-Disposition %q", query, w.Header().Get("Content-Disposition"))
+		}
+	}
+
+	if w := upload("?operation=ingest"); w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown operation = %d", w.Code)
+	}
+
+	// Only one CPU profile at a time
+	if err := pprof.StartCPUProfile(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	w := upload("")
+	pprof.StopCPUProfile()
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while profiling, got %d", w.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package test
+
+import (
+	"1brc-challange/admission"
+	"1brc-challange/brcpb"
+	"1brc-challange/delivery"
+	grpc_delivery "1brc-challange/delivery/grpc"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/health"
+	"1brc-challange/metrics"
+	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/services"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdmission(t *testing.T) {
+	c := admission.New(admission.Config{MaxRuns: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	release, err := c.Admit(context.Background())
+	if err != nil {
+		t.Fatalf("Admit error: %v", err)
+	}
+
+	// The second run waits in the queue, the third is turned away at once
+	queued := make(chan error, 1)
+	go func() {
+		_, err := c.Admit(context.Background())
+		queued <- err
+	}()
+	waitFor(t, func() bool { return c.Load() == 2 })
+	if _, err := c.Admit(context.Background()); !errors.Is(err, admission.ErrQueueFull) || !errors.Is(err, admission.ErrSaturated) {
+		t.Errorf("Admit with a full queue = %v, want ErrQueueFull", err)
+	}
+	if err := <-queued; !errors.Is(err, admission.ErrQueueTimeout) {
+		t.Errorf("queued Admit = %v, want ErrQueueTimeout", err)
+	}
+
+	// A queued run takes the slot once it is released
+	go func() {
+		rel, err := c.Admit(context.Background())
+		if err == nil {
+			defer rel()
+		}
+		queued <- err
+	}()
+	waitFor(t, func() bool { return c.Load() == 2 })
+	release()
+	release() // releasing twice frees one slot only
+	if err := <-queued; err != nil {
+		t.Errorf("queued Admit = %v", err)
+	}
+
+	// A caller that gives up leaves the queue with its own error
+	release, _ = c.Admit(context.Background())
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Admit(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Admit = %v", err)
+	}
+	if c.Load() != 1 || c.Capacity() != 2 || c.RetryAfter() != time.Second {
+		t.Errorf("Load %d, Capacity %d, RetryAfter %s", c.Load(), c.Capacity(), c.RetryAfter())
+	}
+
+	// Without limits every run is admitted
+	var none *admission.Controller
+	if release, err := none.Admit(context.Background()); err != nil {
+		t.Errorf("nil Controller: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool := admission.NewPool(2)
+	var busy, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		err := pool.Go(context.Background(), func() {
+			defer wg.Done()
+			n := busy.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(5 * time.Millisecond)
+			busy.Add(-1)
+		})
+		if err != nil {
+			t.Fatalf("Go error: %v", err)
+		}
+	}
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Errorf("%d tasks ran at once on a pool of 2", peak.Load())
+	}
+
+	// The engine decodes the same result on a pool smaller than its workers
+	input := brcSample()
+	want, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(4), brc.WithPool(admission.NewPool(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameStats(t, got.Stations, want.Stations)
+}
+
+func TestAnomalyDetectionOnPool(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 200000; i++ {
+		fmt.Fprintf(&sb, "Station-%d;%.1f\n", i%40, float64(i%301)/10-10)
+	}
+	sb.WriteString("Station-3;99.0\n")
+	input := sb.String()
+
+	// Detectors only make progress together, so concurrent runs wider than the pool must
+	// neither exceed it nor wait on each other
+	pool := admission.NewPool(2)
+	ctx := admission.WithPool(context.Background(), pool)
+	ps := services.NewProcessService(4, nil, nil, nil)
+	opts := models.ProcessOptions{Dialect: utilities.DefaultDialect, Rules: models.AnomalyRules{ExtremeMin: -50, ExtremeMax: 50, SpikeDelta: 100}}
+
+	done := make(chan struct{})
+	var peak atomic.Int64
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if n := int64(testutil.ToFloat64(metrics.PoolBusy)); n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(50 * time.Microsecond)
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := ps.StreamAnomalies(ctx, strings.NewReader(input), "pool", opts, nil)
+			if err != nil {
+				t.Errorf("StreamAnomalies error: %v", err)
+				return
+			}
+			if len(result.Anomalies) != 1 || result.Anomalies[0].Temp != 99 {
+				t.Errorf("Unexpected anomalies: %v", result.Anomalies)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	if p := peak.Load(); p == 0 || p > 2 {
+		t.Errorf("Expected the detectors on the pool of 2, at most %d goroutines of it were busy", p)
+	}
+}
+
+func TestAdmitHTTP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := http_delivery.NewClientHandler(2, nil, nil, nil, nil)
+	handler.Admission = admission.New(admission.Config{MaxRuns: 1, PoolSize: 2})
+	handler.Readiness = health.NewChecker(time.Second, health.Saturation("queue", handler.Admission.Load, handler.Admission.Capacity()))
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+	postTo := func(path string) *http.Response {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "measurements.txt")
+		part.Write([]byte("A;1.0\nB;2.0\n"))
+		mw.Close()
+		return adminRequest(api.Router, http.MethodPost, path, &body, mw.FormDataContentType()).Result()
+	}
+	post := func() *http.Response { return postTo("/one-billion-row-challenge") }
+
+	// Another run holds the only slot
+	release, _ := handler.Admission.Admit(context.Background())
+	resp := post()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("saturated run = %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	// Imports hold whole partials files in memory, so they are admitted like decoding runs
+	if resp := postTo("/partials/import"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("saturated import = %d", resp.StatusCode)
+	}
+	if code, report := probe(t, handler); code != http.StatusServiceUnavailable || report.Checks["queue"].Status != health.StatusFail {
+		t.Errorf("GET /readyz while saturated = %d %+v", code, report)
+	}
+
+	release()
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Errorf("admitted run = %d", resp.StatusCode)
+	}
+	if handler.Admission.Load() != 0 {
+		t.Errorf("Load = %d after the run", handler.Admission.Load())
+	}
+}
+
+func TestAdmitGRPC(t *testing.T) {
+	c := admission.New(admission.Config{MaxRuns: 1})
+	client := grpcClient(t, grpc.ChainStreamInterceptor(grpc_delivery.AdmitStream(c)))
+	release, _ := c.Admit(context.Background())
+	defer release()
+
+	stream, err := client.Aggregate(context.Background())
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	stream.Send(&brcpb.AggregateRequest{Filename: "measurements.txt", Chunk: []byte("A;1.0\n")})
+	_, err = stream.CloseAndRecv()
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted, got %v", err)
+	}
+	if header, _ := stream.Header(); strings.Join(header.Get("retry-after"), "") != "1" {
+		t.Errorf("retry-after header %v", header)
+	}
+}
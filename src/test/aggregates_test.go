@@ -0,0 +1,124 @@
+package test
+
+import (
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func openAggregates(t *testing.T) *store.AggregateStore {
+	t.Helper()
+	aggs, err := store.OpenAggregates(filepath.Join(t.TempDir(), "aggregates.db"))
+	if err != nil {
+		t.Fatalf("OpenAggregates error: %v", err)
+	}
+	t.Cleanup(func() { aggs.Close() })
+	return aggs
+}
+
+func TestAggregateAppendMerges(t *testing.T) {
+	aggs := openAggregates(t)
+	first := map[string]*models.TempStat{"Oslo": {Sum: 3, Min: 1, Max: 2, Count: 2}}
+	second := map[string]*models.TempStat{
+		"Oslo":  {Sum: -4, Min: -4, Max: -4, Count: 1},
+		"Paris": {Sum: 9, Min: 9, Max: 9, Count: 1},
+	}
+	if _, err := aggs.Append("daily", "h1", "a.txt", "", first, false); err != nil {
+		t.Fatalf("Append error: %v", err)
+	}
+	info, err := aggs.Append("daily", "h2", "b.txt", "", second, false)
+	if err != nil {
+		t.Fatalf("Append error: %v", err)
+	}
+	if info.Appends != 2 || info.Rows != 4 || info.Stations != 2 {
+		t.Errorf("Info after two appends = %+v", info)
+	}
+
+	_, stats, err := aggs.Get("daily")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if oslo := stats["Oslo"]; oslo.Count != 3 || oslo.Sum != -1 || oslo.Min != -4 || oslo.Max != 2 {
+		t.Errorf("Merged Oslo = %+v", *oslo)
+	}
+
+	if _, err := aggs.Append("daily", "h1", "a.txt", "", first, false); !errors.Is(err, store.ErrDuplicateInput) {
+		t.Errorf("Expected ErrDuplicateInput, got %v", err)
+	}
+	if _, err := aggs.Append("daily", "h3", "c.txt", "1h0m0s", first, false); !errors.Is(err, store.ErrBucketMismatch) {
+		t.Errorf("Expected ErrBucketMismatch, got %v", err)
+	}
+	if _, err := aggs.Append("no/slash", "h1", "a.txt", "", first, false); !errors.Is(err, store.ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+}
+
+func TestAggregateSnapshotRestoreDelete(t *testing.T) {
+	aggs := openAggregates(t)
+	aggs.Append("daily", "h1", "a.txt", "", map[string]*models.TempStat{"Oslo": {Sum: 1, Min: 1, Max: 1, Count: 1}}, false)
+	snap, err := aggs.Snapshot("daily")
+	if err != nil {
+		t.Fatalf("Snapshot error: %v", err)
+	}
+	aggs.Append("daily", "h2", "b.txt", "", map[string]*models.TempStat{"Lima": {Sum: 20, Min: 20, Max: 20, Count: 1}}, false)
+
+	if _, stats, err := aggs.GetSnapshot("daily", snap.ID); err != nil || len(stats) != 1 {
+		t.Errorf("GetSnapshot = %v, %v", stats, err)
+	}
+	info, err := aggs.Restore("daily", snap.ID)
+	if err != nil || info.Appends != 1 {
+		t.Fatalf("Restore = %+v, %v", info, err)
+	}
+	if _, stats, _ := aggs.Get("daily"); len(stats) != 1 || stats["Lima"] != nil {
+		t.Errorf("State after restore = %v", stats)
+	}
+	if list, _ := aggs.Snapshots("daily"); len(list) != 1 {
+		t.Errorf("Snapshots = %+v", list)
+	}
+
+	if err := aggs.Delete("daily"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if _, _, err := aggs.Get("daily"); !errors.Is(err, store.ErrAggregateNotFound) {
+		t.Errorf("Expected ErrAggregateNotFound, got %v", err)
+	}
+}
+
+func TestAppendRefusesDuplicateBeforeDecoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runs := openRuns(t, store.Config{})
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, runs, openAggregates(t), nil)}
+	api.SetupRoutes()
+	appendFile := func(query string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "measurements.txt")
+		part.Write([]byte("Oslo;1.0\nLima;20.0\n"))
+		mw.Close()
+		return adminRequest(api.Router, http.MethodPost, "/aggregates/daily/append"+query, &body, mw.FormDataContentType())
+	}
+
+	if w := appendFile(""); w.Code != http.StatusOK {
+		t.Fatalf("First append = %d: %s", w.Code, w.Body)
+	}
+	if w := appendFile(""); w.Code != http.StatusConflict {
+		t.Fatalf("Duplicate append = %d: %s", w.Code, w.Body)
+	}
+	// The duplicate was refused before it was decoded, so it left no run behind
+	if _, total, _ := runs.List(models.RunAggregate, 0, 10); total != 1 {
+		t.Errorf("Runs after a refused duplicate = %d, want 1", total)
+	}
+	if w := appendFile("?force=true"); w.Code != http.StatusOK {
+		t.Errorf("Forced append = %d: %s", w.Code, w.Body)
+	}
+}
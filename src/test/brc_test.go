@@ -0,0 +1,129 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func brcSample() []byte {
+	var sb strings.Builder
+	sb.WriteString("city;temp\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&sb, "Station-%d;%.1f\n", i%7, float64(i%301)/10-10)
+	}
+	return []byte(sb.String())
+}
+
+func TestBRCAggregateIndependentOfWorkers(t *testing.T) {
+	data := brcSample()
+	dialect := utilities.DefaultDialect
+	dialect.HasHeader = true
+	dialect.StationName, dialect.ValueName = "city", "temp"
+
+	var want map[string]*models.TempStat
+	for _, workers := range []int{1, 3, 16} {
+		result, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)),
+			brc.WithWorkers(workers), brc.WithDialect(dialect))
+		if err != nil {
+			t.Fatalf("workers=%d: Aggregate error: %v", workers, err)
+		}
+		if result.Rows != 5000 || len(result.Stations) != 7 {
+			t.Fatalf("workers=%d: %d rows in %d stations", workers, result.Rows, len(result.Stations))
+		}
+		if want == nil {
+			want = result.Stations
+			continue
+		}
+		assertSameStats(t, result.Stations, want)
+	}
+}
+
+func TestBRCAggregateSketches(t *testing.T) {
+	data := []byte("A;1.0\nA;2.0\nA;3.0\nA;4.0\nB;5.0\n")
+	result, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)), brc.WithWorkers(2), brc.WithSketches())
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	q := utilities.SketchQuantiles(result.Sketches)
+	if q["A"].P50 != 2 || q["B"].P99 != 5 {
+		t.Errorf("Unexpected quantiles: %+v", q)
+	}
+
+	// columnar input holds no individual values to sketch
+	var columnar bytes.Buffer
+	if _, err := brc.Ingest(context.Background(), bytes.NewReader(data), int64(len(data)), &columnar); err != nil {
+		t.Fatalf("Ingest error: %v", err)
+	}
+	_, err = brc.Aggregate(context.Background(), bytes.NewReader(columnar.Bytes()), int64(columnar.Len()), brc.WithSketches())
+	if !errors.Is(err, brc.ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions, got %v", err)
+	}
+	result, err = brc.Aggregate(context.Background(), bytes.NewReader(columnar.Bytes()), int64(columnar.Len()))
+	if err != nil || result.Rows != 5 || result.Stations["A"].Max != 4 {
+		t.Errorf("Columnar Aggregate = %+v, %v", result, err)
+	}
+}
+
+func TestBRCDetectAnomalies(t *testing.T) {
+	data := "A;10.0\nA;70.0\nB;1.0\nB;30.0\nA;12.0\n"
+	var streamed []string
+	report, err := brc.DetectAnomalies(context.Background(), strings.NewReader(data), brc.DefaultRules,
+		brc.WithWorkers(2), brc.OnAnomaly(func(a *models.Anomaly) error {
+			streamed = append(streamed, a.Station+":"+a.Reason)
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("DetectAnomalies error: %v", err)
+	}
+	if report.Rows != 5 || report.Stations != 2 || len(report.Anomalies) != 3 || len(streamed) != 3 {
+		t.Errorf("Unexpected report %+v, streamed %v", report, streamed)
+	}
+
+	// a failing callback is not called again, but the report is complete
+	calls := 0
+	stop := errors.New("stop")
+	report, err = brc.DetectAnomalies(context.Background(), strings.NewReader(data), brc.DefaultRules,
+		brc.OnAnomaly(func(*models.Anomaly) error { calls++; return stop }))
+	if !errors.Is(err, stop) || calls != 1 || report == nil || len(report.Anomalies) != 3 {
+		t.Errorf("Expected the callback error after one call, got %v after %d calls", err, calls)
+	}
+
+	_, err = brc.DetectAnomalies(context.Background(), strings.NewReader(data), brc.Rules{ExtremeMin: 5, ExtremeMax: 1})
+	if !errors.Is(err, brc.ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestBRCCanceled(t *testing.T) {
+	data := brcSample()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := brc.Aggregate(ctx, bytes.NewReader(data), int64(len(data))); !errors.Is(err, context.Canceled) {
+		t.Errorf("Aggregate: expected context.Canceled, got %v", err)
+	}
+	if _, err := brc.DetectAnomalies(ctx, bytes.NewReader(data), brc.DefaultRules); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetectAnomalies: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSplitReaderAtShortInput(t *testing.T) {
+	data := []byte("A;1\nB;2")
+	parts, err := utilities.SplitReaderAt(bytes.NewReader(data), int64(len(data)), 8)
+	if err != nil {
+		t.Fatalf("SplitReaderAt error: %v", err)
+	}
+	var total int64
+	for _, p := range parts {
+		total += p.Size
+	}
+	if len(parts) != 2 || parts[1].Offset != 4 || total != int64(len(data)) {
+		t.Errorf("Unexpected parts: %+v", parts)
+	}
+}
@@ -0,0 +1,211 @@
+package test
+
+import (
+	"1brc-challange/cluster"
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/metrics"
+	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/services"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// uniqueStations returns an input of n stations that each appear twice, far apart, like
+// the random names of an adversarial file.
+func uniqueStations(n int) []byte {
+	var sb strings.Builder
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, "%08x-%d;%d.%d\n", i*2654435761, i, i%50+pass, pass)
+		}
+	}
+	return []byte(sb.String())
+}
+
+// withBudget sets the memory budget of the decode workers, and spills to a temp dir of
+// the test.
+func withBudget(t *testing.T, budget int64, policy string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	tuning := utilities.DefaultTuning()
+	tuning.MemoryBudget, tuning.BudgetPolicy = budget, policy
+	utilities.Configure(tuning)
+	t.Cleanup(func() { utilities.Configure(utilities.DefaultTuning()) })
+	return dir
+}
+
+func TestMemoryBudgetSpill(t *testing.T) {
+	input := uniqueStations(20000)
+	withBudget(t, 0, utilities.BudgetSpill)
+	want, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each worker sees 5000 stations, about 3500 of which fit its budget; the merged
+	// result of about 3MB fits the 4MB of the 8 workers together
+	dir := withBudget(t, 512<<10, utilities.BudgetSpill)
+	spilled := testutil.ToFloat64(metrics.SpillRuns)
+	got, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(8),
+		brc.WithObserver(services.PipelineObserver))
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	if runs := testutil.ToFloat64(metrics.SpillRuns) - spilled; runs < 8 {
+		t.Errorf("Expected the workers to spill, got %v runs", runs)
+	}
+	assertSameStats(t, got.Stations, want.Stations)
+	if got.Rows != 40000 {
+		t.Errorf("Rows = %d, want 40000", got.Rows)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Spill files left behind: %v", files)
+	}
+}
+
+func TestMemoryBudgetMergedResult(t *testing.T) {
+	// 20000 stations take about 3MB merged, over the 256KB of 4 workers with 64KB each
+	input := uniqueStations(20000)
+	dir := withBudget(t, 64<<10, utilities.BudgetSpill)
+	_, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(4))
+	if !errors.Is(err, utilities.ErrMemoryBudget) || !strings.Contains(err.Error(), "merged result") {
+		t.Errorf("Aggregate = %v, want ErrMemoryBudget for the merged result", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Spill files left behind: %v", files)
+	}
+
+	w := uploadAggregate(t, input)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "merged result") {
+		t.Errorf("POST over budget = %d %s", w.Code, w.Body)
+	}
+
+	// A cluster worker answers its range in one piece, so the range must fit the budget
+	path := dir + "/input.txt"
+	os.WriteFile(path, input, 0o644)
+	if _, err := cluster.DecodeRange(spec(), path, 0, int64(len(input)), nil); !errors.Is(err, utilities.ErrMemoryBudget) {
+		t.Errorf("DecodeRange = %v, want ErrMemoryBudget", err)
+	}
+	small := []byte("A;1.0\nB;2.0\nA;3.0\n")
+	stats, err := cluster.DecodeRange(spec(), "", 0, int64(len(small)), bytes.NewReader(small))
+	if err != nil || len(stats) != 2 {
+		t.Errorf("DecodeRange within the budget = %v, %v", stats, err)
+	}
+}
+
+func TestSpillSetFanIn(t *testing.T) {
+	dir := t.TempDir()
+	var observed, spills int64
+	set := utilities.NewSpillSet(dir, func(n int64) {
+		observed += n
+		if n > 0 {
+			spills++
+		}
+	})
+	defer set.Close()
+
+	// More runs than are merged at once, each holding every station once
+	const runs, stations = 200, 50
+	for r := 0; r < runs; r++ {
+		table := make(map[string]models.TempStat)
+		for i := 0; i < stations; i++ {
+			v := float32(r % 10)
+			table[fmt.Sprintf("station-%03d", (i*7+r)%stations)] = models.TempStat{Sum: v, Min: v, Max: v, Count: 1}
+		}
+		if err := set.Spill(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if set.Runs() != runs || spills != runs {
+		t.Fatalf("Runs = %d, observed %d spills, want %d", set.Runs(), spills, runs)
+	}
+
+	merged := make(map[string]models.TempStat)
+	if err := set.MergeInto(context.Background(), merged, 0); err != nil {
+		t.Fatalf("MergeInto error: %v", err)
+	}
+	if len(merged) != stations {
+		t.Fatalf("%d stations merged, want %d", len(merged), stations)
+	}
+	for station, stat := range merged {
+		if stat.Count != runs || stat.Min != 0 || stat.Max != 9 || stat.Sum != 900 {
+			t.Errorf("%s = %+v", station, stat)
+		}
+	}
+	// The passes replaced the spilled runs with a few intermediate ones
+	if files, _ := os.ReadDir(dir); len(files) > 4 {
+		t.Errorf("%d run files after merging, want at most 4", len(files))
+	}
+	if observed != 0 {
+		t.Errorf("%d spilled bytes still reported after merging", observed)
+	}
+
+	// A limit bounds the merged table
+	err := set.MergeInto(context.Background(), make(map[string]models.TempStat), 10*(128+11))
+	if !errors.Is(err, utilities.ErrMemoryBudget) {
+		t.Errorf("MergeInto over the limit = %v, want ErrMemoryBudget", err)
+	}
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Run files left behind: %v", files)
+	}
+}
+
+// uploadAggregate posts input to the aggregation endpoint of a handler without stores.
+func uploadAggregate(t *testing.T, input []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, nil, nil, nil)}
+	api.SetupRoutes()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "measurements.txt")
+	part.Write(input)
+	mw.Close()
+	return adminRequest(api.Router, http.MethodPost, "/one-billion-row-challenge", &body, mw.FormDataContentType())
+}
+
+func TestMemoryBudgetFail(t *testing.T) {
+	input := uniqueStations(20000)
+	withBudget(t, 64<<10, utilities.BudgetFail)
+	_, err := brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(4))
+	if !errors.Is(err, utilities.ErrMemoryBudget) || !strings.Contains(err.Error(), "policy is fail") {
+		t.Errorf("Aggregate = %v, want ErrMemoryBudget", err)
+	}
+
+	// Sketches keep every value, which a sorted run of stats cannot hold
+	withBudget(t, 64<<10, utilities.BudgetSpill)
+	_, err = brc.Aggregate(context.Background(), bytes.NewReader(input), int64(len(input)), brc.WithWorkers(4), brc.WithSketches())
+	if !errors.Is(err, utilities.ErrMemoryBudget) {
+		t.Errorf("Aggregate with sketches = %v, want ErrMemoryBudget", err)
+	}
+
+	// Inputs within the budget are not affected
+	withBudget(t, 64<<10, utilities.BudgetFail)
+	small := []byte("A;1.0\nB;2.0\nA;3.0\n")
+	if _, err := brc.Aggregate(context.Background(), bytes.NewReader(small), int64(len(small))); err != nil {
+		t.Errorf("Aggregate within the budget: %v", err)
+	}
+
+	w := uploadAggregate(t, input)
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "memory budget exceeded") {
+		t.Errorf("POST over budget = %d %s", w.Code, w.Body)
+	}
+}
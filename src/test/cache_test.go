@@ -0,0 +1,92 @@
+package test
+
+import (
+	"1brc-challange/cache"
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"testing"
+	"time"
+)
+
+func cachedStats(sum float32) map[string]*models.TempStat {
+	return map[string]*models.TempStat{"Oslo": {Sum: sum, Min: -3.5, Max: 4.25, Count: 3}}
+}
+
+func TestCacheKeyDependsOnOptions(t *testing.T) {
+	base := models.ProcessOptions{Format: models.FormatText, Dialect: utilities.DefaultDialect}
+	other := base
+	other.Numeric.Empty = models.PolicyZero
+	if cache.Key("abc", base) != cache.Key("abc", base) {
+		t.Error("Key is not deterministic")
+	}
+	if cache.Key("abc", base) == cache.Key("abd", base) || cache.Key("abc", base) == cache.Key("abc", other) {
+		t.Error("Key ignores the content hash or the options")
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	c, err := cache.New(cache.Config{MaxEntries: 2})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	c.Put("a", cachedStats(1))
+	c.Put("b", cachedStats(2))
+	c.Get("a") // a is now more recent than b
+	c.Put("c", cachedStats(3))
+	if _, ok := c.Get("b"); ok {
+		t.Error("Least recently used entry was not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("Recently used entry was evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+}
+
+func TestCacheDiskTierAndPurge(t *testing.T) {
+	cfg := cache.Config{Dir: t.TempDir(), TTL: time.Hour}
+	first, err := cache.New(cfg)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if err := first.Put("k", cachedStats(1.5)); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	// a fresh instance, as after a restart, finds the result on disk
+	second, _ := cache.New(cfg)
+	got, ok := second.Get("k")
+	if !ok || *got["Oslo"] != *cachedStats(1.5)["Oslo"] {
+		t.Fatalf("Disk tier returned %v, %v", got, ok)
+	}
+
+	if _, disk, err := second.Purge(); err != nil || disk != 1 {
+		t.Errorf("Purge removed %d files, err %v", disk, err)
+	}
+	if _, ok := second.Get("k"); ok {
+		t.Error("Entry survived purge")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, _ := cache.New(cache.Config{MaxEntries: 4, Dir: t.TempDir(), TTL: 20 * time.Millisecond})
+	c.Put("k", cachedStats(1))
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("k"); ok {
+		t.Error("Expired entry was served")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c, err := cache.New(cache.Config{})
+	if err != nil || c != nil {
+		t.Fatalf("New with no tiers = %v, %v; want nil cache", c, err)
+	}
+	if err := c.Put("k", cachedStats(1)); err != nil {
+		t.Errorf("Put on nil cache: %v", err)
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Error("Nil cache hit")
+	}
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"1brc-challange/client"
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiServer serves the real router with run history and returns its URL. The first
+// `failures` requests are answered with 503 before they reach the router.
+func apiServer(t *testing.T, failures int32) (string, *int32) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	runs := openRuns(t, store.Config{})
+	router := delivery.RouteConfig{
+		Router:        gin.New(),
+		ClientHandler: http_delivery.NewClientHandler(2, nil, runs, nil, nil),
+	}
+	router.SetupRoutes()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"error":"busy"}`, http.StatusServiceUnavailable)
+			return
+		}
+		router.Router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &requests
+}
+
+func clientSample(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	var sb strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&sb, "Station-%d;%.1f\n", i%4, float64(i%50))
+	}
+	sb.WriteString("Station-0;75.0\n")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientAggregateAndRuns(t *testing.T) {
+	url, _ := apiServer(t, 0)
+	c := client.New(url)
+	ctx := context.Background()
+
+	if err := c.Health(ctx); err != nil {
+		t.Fatalf("Health error: %v", err)
+	}
+	result, err := c.AggregateFile(ctx, clientSample(t), client.Options{})
+	if err != nil {
+		t.Fatalf("AggregateFile error: %v", err)
+	}
+	if len(result.Stations) != 4 || result.RunID == "" || result.InputHash == "" {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if s := result.Stations["Station-0"]; s.Count != 251 || s.Max != 75 || s.Min != 0 {
+		t.Errorf("Station-0 = %+v", s)
+	}
+
+	runs, total, err := c.ListRuns(ctx, client.RunQuery{Kind: models.RunAggregate})
+	if err != nil || total != 1 || runs[0].ID != result.RunID || runs[0].InputName != "measurements.txt" {
+		t.Fatalf("ListRuns = %+v, %d, %v", runs, total, err)
+	}
+	detail, err := c.GetRunStation(ctx, result.RunID, "Station-1")
+	if err != nil || len(detail.Result) != 1 || detail.Result[0].Count != 250 {
+		t.Errorf("GetRunStation = %+v, %v", detail, err)
+	}
+	_, err = c.GetRun(ctx, "missing", nil)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 APIError, got %v", err)
+	}
+}
+
+func TestClientAggregateBuckets(t *testing.T) {
+	url, _ := apiServer(t, 0)
+	data := "2024-03-01T10:05:00Z;Oslo;1.0\n2024-03-01T11:05:00Z;Oslo;3.0\n2024-03-01T11:30:00Z;Oslo;5.0\n"
+	result, err := client.New(url).Aggregate(context.Background(), strings.NewReader(data),
+		client.Options{TimestampColumn: "0", StationColumn: "1", ValueColumn: "2", Bucket: "hour"})
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	if result.Bucket != "1h0m0s" || len(result.Buckets) != 2 || result.Buckets[1].Count != 2 || result.Buckets[1].Max != 5 {
+		t.Errorf("Unexpected buckets: %+v", result)
+	}
+}
+
+func TestClientDetectAnomalies(t *testing.T) {
+	url, _ := apiServer(t, 0)
+	f, err := os.Open(clientSample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rules := &models.AnomalyRules{ExtremeMin: -50, ExtremeMax: 60, SpikeDelta: 100}
+	result, err := client.New(url).DetectAnomalies(context.Background(), f, client.Options{}, rules)
+	if err != nil {
+		t.Fatalf("DetectAnomalies error: %v", err)
+	}
+	if len(result.Anomalies) != 1 || result.Anomalies[0].Station != "Station-0" || result.Anomalies[0].Reason != "extreme" {
+		t.Errorf("Unexpected anomalies: %+v", result.Anomalies)
+	}
+
+	_, err = client.New(url).Aggregate(context.Background(), strings.NewReader("A;1.0\n"), client.Options{InputFormat: "xml"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 APIError, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	// a file can be sent again, so the upload succeeds on the third attempt
+	url, requests := apiServer(t, 2)
+	c := &client.Client{BaseURL: url, Backoff: time.Millisecond}
+	result, err := c.AggregateFile(context.Background(), clientSample(t), client.Options{})
+	if err != nil || len(result.Stations) != 4 || atomic.LoadInt32(requests) != 3 {
+		t.Fatalf("AggregateFile = %+v, %v after %d requests", result, err, atomic.LoadInt32(requests))
+	}
+
+	// a plain reader is consumed by the first attempt and is not retried
+	url, requests = apiServer(t, 1)
+	c = &client.Client{BaseURL: url, Backoff: time.Millisecond}
+	_, err = c.Aggregate(context.Background(), io.MultiReader(strings.NewReader("A;1.0\n")), client.Options{})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("Expected one 503, got %v after %d requests", err, atomic.LoadInt32(requests))
+	}
+
+	// GETs are retried until MaxAttempts
+	url, requests = apiServer(t, 5)
+	c = &client.Client{BaseURL: url, MaxAttempts: 2, Backoff: time.Millisecond}
+	if err := c.Health(context.Background()); err == nil || atomic.LoadInt32(requests) != 2 {
+		t.Errorf("Expected failure after 2 requests, got %v after %d", err, atomic.LoadInt32(requests))
+	}
+}
+
+func TestClientPropagatesTraceContext(t *testing.T) {
+	var traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	if err := client.New(srv.URL).Health(ctx); err != nil {
+		t.Fatalf("Health error: %v", err)
+	}
+	if got, want := traceparent.Load(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Errorf("Expected traceparent %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,359 @@
+package test
+
+import (
+	"1brc-challange/cluster"
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clusterSample writes a measurements file and returns its path and locally merged result.
+func clusterSample(t *testing.T) (string, map[string]*models.TempStat) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(f, "Station-%02d;%.1f\n", i%40, float64(i%1999)/10-99.9)
+	}
+	f.Close()
+
+	local := make(map[string]models.TempStat)
+	dec := utilities.NewDecoder(models.ProcessOptions{Dialect: utilities.DefaultDialect})
+	if err := dec.DecodePart(path, 0, 1<<30, local); err != nil {
+		t.Fatalf("DecodePart error: %v", err)
+	}
+	return path, utilities.MergeResults([]map[string]models.TempStat{local})
+}
+
+// clusterToken is the secret the coordinators and workers of the tests share.
+const clusterToken = "test-token"
+
+// startWorker serves the worker decode endpoint on a local port.
+func startWorker(t *testing.T, sharedDir string) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(cluster.DecodePath, (&http_delivery.WorkerHandler{SharedDir: sharedDir, Token: clusterToken}).DecodeRange)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func register(t *testing.T, co *cluster.Coordinator, addr string, slots int) string {
+	t.Helper()
+	a, err := co.Register(cluster.Registration{Addr: addr, Slots: slots})
+	if err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+	return a.ID
+}
+
+func spec() cluster.DecodeSpec {
+	return cluster.SpecFromOptions(models.ProcessOptions{Dialect: utilities.DefaultDialect})
+}
+
+func TestClusterDecodeMatchesLocal(t *testing.T) {
+	path, want := clusterSample(t)
+	for _, shared := range []bool{false, true} {
+		co := cluster.NewCoordinator(cluster.Config{SharedPath: shared, Token: clusterToken})
+		register(t, co, startWorker(t, filepath.Dir(path)).URL, 2)
+		register(t, co, startWorker(t, filepath.Dir(path)).URL, 3)
+
+		results, err := co.Decode(context.Background(), path, spec())
+		if err != nil {
+			t.Fatalf("shared=%v: Decode error: %v", shared, err)
+		}
+		if len(results) != 10 {
+			t.Errorf("shared=%v: expected 10 ranges, got %d", shared, len(results))
+		}
+		assertSameStats(t, utilities.MergeResults(results), want)
+		for _, w := range co.Workers() {
+			if w.Done == 0 || w.Busy != 0 {
+				t.Errorf("shared=%v: worker %s did %d ranges, %d still busy", shared, w.Addr, w.Done, w.Busy)
+			}
+		}
+	}
+}
+
+func TestClusterReassignsFailedRanges(t *testing.T) {
+	path, want := clusterSample(t)
+	co := cluster.NewCoordinator(cluster.Config{HeartbeatInterval: 20 * time.Millisecond, HeartbeatTimeout: 200 * time.Millisecond, Token: clusterToken})
+	good := register(t, co, startWorker(t, "").URL, 1)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"disk on fire"}`, http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	register(t, co, broken.URL, 2)
+
+	// A worker that accepts ranges and then goes silent
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+	register(t, co, hung.URL, 2)
+
+	// Only the good worker keeps sending heartbeats
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				co.Heartbeat(good)
+			}
+		}
+	}()
+
+	results, err := co.Decode(context.Background(), path, spec())
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	assertSameStats(t, utilities.MergeResults(results), want)
+	for _, w := range co.Workers() {
+		switch w.Addr {
+		case broken.URL, hung.URL:
+			if w.Alive || w.Done != 0 {
+				t.Errorf("Worker %s should be dead with no ranges done: %+v", w.Addr, w)
+			}
+		}
+	}
+}
+
+func TestClusterRejectedRangeFailsRun(t *testing.T) {
+	// The first of many ranges is rejected while the others are still in flight
+	dir := t.TempDir()
+	path := filepath.Join(dir, "empty-values.txt")
+	var sb strings.Builder
+	sb.WriteString("Oslo;1.0\nOslo;\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "Station-%02d;%d.5\n", i%40, i%30)
+	}
+	os.WriteFile(path, []byte(sb.String()), 0o600)
+	co := cluster.NewCoordinator(cluster.Config{Token: clusterToken})
+	register(t, co, startWorker(t, "").URL, 4)
+
+	opts := models.ProcessOptions{Dialect: utilities.DefaultDialect, Numeric: models.NumericOptions{Empty: models.PolicyFail}}
+	if _, err := co.Decode(context.Background(), path, cluster.SpecFromOptions(opts)); !errors.Is(err, cluster.ErrRangeRejected) {
+		t.Errorf("Expected ErrRangeRejected, got %v", err)
+	}
+	assertIdle(t, co)
+
+	// A cancelled run gives its slots back as well
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := co.Decode(ctx, path, spec()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	assertIdle(t, co)
+
+	// The worker stays in rotation and decodes the next run
+	results, err := co.Decode(context.Background(), path, spec())
+	if err != nil || len(results) != 8 {
+		t.Fatalf("Decode after the rejected run = %d results, %v", len(results), err)
+	}
+	assertIdle(t, co)
+}
+
+// assertIdle checks that every worker of co is alive with all of its slots free.
+func assertIdle(t *testing.T, co *cluster.Coordinator) {
+	t.Helper()
+	for _, w := range co.Workers() {
+		if w.Busy != 0 || !w.Alive {
+			t.Errorf("Worker %s: %d slots busy, alive %v", w.Addr, w.Busy, w.Alive)
+		}
+	}
+}
+
+func TestClusterAgentRegistersAndLeaves(t *testing.T) {
+	co := cluster.NewCoordinator(cluster.Config{HeartbeatInterval: 20 * time.Millisecond, Token: clusterToken})
+	if _, err := co.Decode(context.Background(), "unused", spec()); !errors.Is(err, cluster.ErrNoWorkers) {
+		t.Fatalf("Expected ErrNoWorkers, got %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	ch := &http_delivery.ClientHandler{Cluster: co}
+	r.POST(cluster.WorkersPath, ch.RegisterWorker)
+	r.POST(cluster.WorkersPath+"/:id/heartbeat", ch.WorkerHeartbeat)
+	r.DELETE(cluster.WorkersPath+"/:id", ch.RemoveWorker)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// Without the token nobody can join the cluster
+	resp, err := http.Post(srv.URL+cluster.WorkersPath, "application/json", strings.NewReader(`{"Addr":"http://127.0.0.1:2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || co.Slots() != 0 {
+		t.Fatalf("Registration without token = %d, %d slots", resp.StatusCode, co.Slots())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cluster.RunAgent(ctx, cluster.AgentConfig{
+			Coordinator:  srv.URL,
+			Token:        clusterToken,
+			Registration: cluster.Registration{Addr: "http://127.0.0.1:1", Slots: 4},
+		})
+	}()
+	waitFor(t, func() bool { return co.Slots() == 4 })
+
+	// A coordinator that forgot the worker gets a fresh registration on the next heartbeat
+	for _, w := range co.Workers() {
+		co.Remove(w.ID)
+	}
+	waitFor(t, func() bool { return co.Slots() == 4 })
+
+	cancel()
+	<-done
+	if n := len(co.Workers()); n != 0 {
+		t.Errorf("Expected the worker to leave, %d still registered", n)
+	}
+}
+
+// assertSameStats compares merged results; sums may differ in the last float32 bits
+// because ranges are added up in a different order.
+func assertSameStats(t *testing.T, got, want map[string]*models.TempStat) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Got %d stations, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		g := got[key]
+		if g == nil || g.Count != w.Count || g.Min != w.Min || g.Max != w.Max || math.Abs(float64(g.Sum-w.Sum)) > 0.01*math.Abs(float64(w.Sum))+0.5 {
+			t.Errorf("%s = %+v, want %+v", key, g, w)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	waitForTimeout(t, 2*time.Second, cond)
+}
+
+func waitForTimeout(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestClusterWorkerProcesses runs the cluster as it is deployed: the `worker` subcommand
+// of the service binary in processes of their own, registering with a coordinator.
+func TestClusterWorkerProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the service binary")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	bin := filepath.Join(t.TempDir(), "app")
+	if out, err := exec.Command(goTool, "build", "-o", bin, "1brc-challange").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	path, want := clusterSample(t)
+	co := cluster.NewCoordinator(cluster.Config{HeartbeatInterval: 50 * time.Millisecond, Token: clusterToken})
+	gin.SetMode(gin.TestMode)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, nil, nil, co)}
+	api.SetupRoutes()
+	srv := httptest.NewServer(api.Router)
+	defer srv.Close()
+
+	var workers []string
+	for i := 0; i < 3; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+		cmd := exec.Command(bin, "worker", "-coordinator", srv.URL, "-listen", addr, "-slots", "2")
+		cmd.Env = append(os.Environ(), "CLUSTER_TOKEN="+clusterToken, "CLUSTER_SHARED_DIR="+filepath.Dir(path), "LOG_LEVEL=warn")
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		exited := make(chan struct{})
+		go func() { cmd.Wait(); close(exited) }()
+		t.Cleanup(func() {
+			cmd.Process.Signal(os.Interrupt)
+			select {
+			case <-exited:
+			case <-time.After(5 * time.Second):
+				cmd.Process.Kill()
+				<-exited
+			}
+			if t.Failed() && stderr.Len() > 0 {
+				t.Logf("worker %s:\n%s", addr, stderr.String())
+			}
+		})
+		workers = append(workers, "http://"+addr)
+	}
+	waitForTimeout(t, 10*time.Second, func() bool { return co.Slots() == 6 })
+
+	// Workers refuse ranges without the token
+	resp, err := http.Post(workers[0]+cluster.DecodePath+"?offset=0&size=0&spec={}", "application/octet-stream", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Range without token = %d", resp.StatusCode)
+	}
+
+	for _, shared := range []bool{false, true} {
+		co := cluster.NewCoordinator(cluster.Config{SharedPath: shared, Token: clusterToken})
+		for _, addr := range workers {
+			register(t, co, addr, 2)
+		}
+		results, err := co.Decode(context.Background(), path, spec())
+		if err != nil {
+			t.Fatalf("shared=%v: Decode error: %v", shared, err)
+		}
+		assertSameStats(t, utilities.MergeResults(results), want)
+		for _, w := range co.Workers() {
+			if w.Done == 0 {
+				t.Errorf("shared=%v: worker %s decoded no range", shared, w.Addr)
+			}
+		}
+	}
+}
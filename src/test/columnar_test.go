@@ -0,0 +1,84 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func ingestSample(t *testing.T) ([]byte, map[string]*models.TempStat) {
+	t.Helper()
+	var buf bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "Station-%03d;%.1f\n", i%300, float64(i%1999)/10-99.9)
+	}
+	input := buf.Bytes()
+	dec := utilities.NewDecoder(models.ProcessOptions{Dialect: utilities.DefaultDialect})
+
+	var out bytes.Buffer
+	rows, err := dec.IngestColumnar(bytes.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("IngestColumnar error: %v", err)
+	}
+	if rows != 10000 {
+		t.Fatalf("Expected 10000 rows, got %d", rows)
+	}
+
+	text := make(map[string]models.TempStat)
+	if err := dec.DecodeReader(bytes.NewReader(input), text); err != nil {
+		t.Fatalf("DecodeReader error: %v", err)
+	}
+	return out.Bytes(), utilities.MergeResults([]map[string]models.TempStat{text})
+}
+
+func TestColumnarRoundTrip(t *testing.T) {
+	data, want := ingestSample(t)
+	if !utilities.IsColumnar(bytes.NewReader(data)) {
+		t.Fatal("IsColumnar did not recognise the ingested file")
+	}
+
+	f, err := utilities.OpenColumnar(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenColumnar error: %v", err)
+	}
+	if f.Rows() != 10000 || len(f.Stations) != len(want) {
+		t.Fatalf("Got %d rows and %d stations, want 10000 and %d", f.Rows(), len(f.Stations), len(want))
+	}
+	parts, err := f.Aggregate(4)
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	got := utilities.MergeResults(parts)
+	for station, w := range want {
+		g, ok := got[station]
+		if !ok {
+			t.Fatalf("Station %q missing from columnar result", station)
+		}
+		if g.Count != w.Count || g.Min != w.Min || g.Max != w.Max || math.Abs(float64(g.Sum-w.Sum)) > 0.05 {
+			t.Errorf("Station %q: got %+v, want %+v", station, *g, *w)
+		}
+	}
+}
+
+func TestColumnarDetectsCorruption(t *testing.T) {
+	data, _ := ingestSample(t)
+	corrupt := bytes.Clone(data)
+	corrupt[40] ^= 0xFF // inside the first block
+
+	f, err := utilities.OpenColumnar(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if err != nil {
+		t.Fatalf("OpenColumnar error: %v", err)
+	}
+	if _, err := f.Aggregate(1); !errors.Is(err, utilities.ErrColumnarCorrupt) {
+		t.Errorf("Expected ErrColumnarCorrupt, got %v", err)
+	}
+
+	truncated := data[:len(data)-3]
+	if _, err := utilities.OpenColumnar(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, utilities.ErrColumnarCorrupt) {
+		t.Errorf("Expected ErrColumnarCorrupt for truncated file, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompareResults(t *testing.T) {
+	base := map[string]*models.TempStat{
+		"Berlin":  {Sum: 20, Min: 20, Max: 20, Count: 1},
+		"Oslo":    {Sum: -3, Min: -3, Max: -3, Count: 1},
+		"Hamburg": {Sum: 26, Min: 12, Max: 14, Count: 2},
+		"Bergen":  {Sum: 5, Min: 5, Max: 5, Count: 1},
+		"Lima":    {Sum: 18.02, Min: 18.02, Max: 18.02, Count: 1},
+	}
+	target := map[string]*models.TempStat{
+		"Berlin":  {Sum: 10, Min: 10, Max: 10, Count: 1},
+		"Oslo":    {Sum: -2, Min: -3, Max: 1, Count: 2},
+		"Hamburg": {Sum: 26, Min: 12, Max: 14, Count: 2},
+		"Paris":   {Sum: 9, Min: 9, Max: 9, Count: 1},
+		"Lima":    {Sum: 18.04, Min: 18.04, Max: 18.04, Count: 1}, // below the displayed precision
+	}
+
+	cmp := utilities.CompareResults(base, target, utilities.DefaultResultOptions, 1)
+	if cmp.Unchanged != 2 {
+		t.Errorf("Unchanged = %d, want 2", cmp.Unchanged)
+	}
+	if len(cmp.Added) != 1 || cmp.Added[0].Station != "Paris" || len(cmp.Removed) != 1 || cmp.Removed[0].Station != "Bergen" {
+		t.Errorf("Added %+v, removed %+v", cmp.Added, cmp.Removed)
+	}
+	if len(cmp.Changed) != 2 || cmp.Changed[0].Station != "Berlin" || cmp.Changed[1].Station != "Oslo" {
+		t.Fatalf("Changed = %+v", cmp.Changed)
+	}
+	oslo := cmp.Changed[1]
+	if oslo.MeanDelta != 2 || oslo.MinDelta != 0 || oslo.MaxDelta != 4 || oslo.CountDelta != 1 {
+		t.Errorf("Oslo delta = %+v", oslo)
+	}
+	if len(cmp.Movers) != 1 || cmp.Movers[0].Station != "Berlin" || cmp.Movers[0].MeanDelta != -10 {
+		t.Errorf("Movers = %+v", cmp.Movers)
+	}
+
+	// filters apply to both sides
+	filtered := utilities.CompareResults(base, target, models.ResultOptions{Decimals: 1, Prefix: "Ber"}, utilities.DefaultMovers)
+	if len(filtered.Changed) != 1 || len(filtered.Removed) != 1 || len(filtered.Added) != 0 {
+		t.Errorf("Filtered comparison = %+v", filtered)
+	}
+}
+
+func TestCompareRejectsPaging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	runs := openRuns(t, store.Config{})
+	base := &models.Run{Kind: models.RunAggregate}
+	target := &models.Run{Kind: models.RunAggregate}
+	runs.Record(base, map[string]*models.TempStat{"Oslo": {Sum: 1, Min: 1, Max: 1, Count: 1}}, nil)
+	runs.Record(target, map[string]*models.TempStat{"Oslo": {Sum: 2, Min: 2, Max: 2, Count: 1}}, nil)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, runs, nil, nil)}
+	api.SetupRoutes()
+
+	path := "/compare?base_run=" + base.ID + "&target_run=" + target.ID
+	if w := adminRequest(api.Router, http.MethodPost, path, nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d: %s", path, w.Code, w.Body)
+	}
+	// Ordering, paging and output formats are refused instead of silently ignored
+	for _, query := range []string{"limit=5", "offset=1", "sort=mean", "order=desc", "hottest=3", "format=csv"} {
+		if w := adminRequest(api.Router, http.MethodPost, path+"&"+query, nil, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("POST /compare with %s = %d, want 400", query, w.Code)
+		}
+	}
+}
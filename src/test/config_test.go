@@ -0,0 +1,181 @@
+package test
+
+import (
+	"1brc-challange/config"
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/utilities"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envOf returns a lookup over vars, standing in for os.LookupEnv.
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	cfg, err := config.Load(nil, envOf(nil))
+	if err != nil {
+		t.Fatalf("Defaults are invalid: %v", err)
+	}
+	if cfg.Server.Addr() != ":8080" || cfg.Server.GRPCAddr != ":50051" || cfg.Runs.Path != "data/runs.db" ||
+		cfg.Processing.Tuning() != utilities.DefaultTuning() || cfg.Anomaly.Rules() != utilities.DefaultAnomalyRules {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+
+	path := writeConfigFile(t, `
+server:
+  port: 9000
+  grpc_addr: ":6000"
+processing:
+  workers: 3
+  max_line_length: 4096
+cache:
+  ttl: 90m
+anomaly:
+  extreme_max: 45
+`)
+	// The file is read first, the environment overrides it and flags override both
+	env := envOf(map[string]string{"CONFIG_FILE": path, "PORT": "9100", "GRPC_ADDR": "", "CACHE_TTL": "2h"})
+	cfg, err = config.Load([]string{"-server.port", "9200"}, env)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.Server.Port != 9200 || cfg.Server.GRPCAddr != "" || cfg.Cache.TTL != 2*time.Hour ||
+		cfg.Processing.WorkerCount() != 3 || cfg.Processing.MaxLineLength != 4096 || cfg.Anomaly.ExtremeMax != 45 {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+	// Settings absent from every source keep their defaults
+	if cfg.Cache.MaxEntries != 64 || cfg.Anomaly.ExtremeMin != -50 {
+		t.Errorf("Defaults were lost: %+v", cfg)
+	}
+
+	// -config wins over CONFIG_FILE
+	other := writeConfigFile(t, "processing:\n  workers: 5\n")
+	if cfg, err = config.Load([]string{"-config", other}, env); err != nil || cfg.Processing.Workers != 5 || cfg.Server.Port != 9100 {
+		t.Errorf("Unexpected configuration with -config: %+v, %v", cfg, err)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		file string
+		want string
+	}{
+		"bad env value":  {env: map[string]string{"PORT": "http"}, want: "PORT"},
+		"bad flag value": {args: []string{"-cache.ttl", "soon"}, want: "-cache.ttl"},
+		"unknown flag":   {args: []string{"-port", "1"}, want: "port"},
+		"port range":     {env: map[string]string{"PORT": "70000"}, want: "server.port"},
+		"extremes":       {env: map[string]string{"ANOMALY_EXTREME_MIN": "80"}, want: "extreme_min"},
+		"buffer":         {env: map[string]string{"READ_BUFFER": "16"}, want: "processing.read_buffer"},
+		"exporter":       {env: map[string]string{"TRACE_EXPORTER": "zipkin"}, want: "tracing.exporter"},
+		"headers":        {env: map[string]string{"TRACE_HEADERS": "token"}, want: "tracing.headers"},
+		"log level":      {env: map[string]string{"LOG_LEVEL": "loud"}, want: "logging"},
+		"cluster token":  {env: map[string]string{"CLUSTER_COORDINATOR": "true"}, want: "cluster.token"},
+		"shared dir":     {env: map[string]string{"CLUSTER_SHARED_PATH": "true"}, want: "cluster.shared_dir"},
+		"temp dir":       {env: map[string]string{"CLUSTER_SHARED_DIR": os.TempDir()}, want: "dedicated directory"},
+		"unknown key":    {file: "cache:\n  max_entry: 3\n", want: "max_entry"},
+		"missing file":   {args: []string{"-config", "/nonexistent/config.yaml"}, want: "config file"},
+	}
+	for name, tc := range cases {
+		env := tc.env
+		if tc.file != "" {
+			env = map[string]string{"CONFIG_FILE": writeConfigFile(t, tc.file)}
+		}
+		_, err := config.Load(tc.args, envOf(env))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error naming %q, got %v", name, tc.want, err)
+		}
+	}
+
+	// Every problem is reported at once
+	_, err := config.Load(nil, envOf(map[string]string{"RUNS_MAX": "-1", "CLUSTER_MAX_ATTEMPTS": "0"}))
+	if err == nil || !strings.Contains(err.Error(), "runs.max_runs") || !strings.Contains(err.Error(), "cluster.max_attempts") {
+		t.Errorf("Expected both problems, got %v", err)
+	}
+}
+
+func TestConfigEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg, err := config.Load(nil, envOf(map[string]string{"TRACE_HEADERS": "authorization=Bearer s3cret", "CACHE_TTL": "90m"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers := cfg.Tracing.Config("test").Headers; headers["authorization"] != "Bearer s3cret" {
+		t.Errorf("Unexpected trace headers %v", headers)
+	}
+	handler := http_delivery.NewClientHandler(2, nil, nil, nil, nil)
+	handler.Config = &cfg
+	admin := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	admin.SetupAdminServer()
+
+	w := adminRequest(admin.Router, http.MethodGet, "/config", nil, "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "s3cret") {
+		t.Fatalf("GET /config = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Server  map[string]any `json:"server"`
+		Cache   map[string]any `json:"cache"`
+		Tracing map[string]any `json:"tracing"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Server["port"] != float64(8080) || body.Cache["ttl"] != "1h30m0s" || body.Tracing["headers"] != "[redacted]" {
+		t.Errorf("Unexpected configuration %s", w.Body)
+	}
+
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+	if w := adminRequest(api.Router, http.MethodGet, "/config", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("The configuration is served on the API: %d", w.Code)
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	tuning := utilities.DefaultTuning()
+	tuning.MaxLineLength = 64
+	utilities.Configure(tuning)
+	t.Cleanup(func() { utilities.Configure(utilities.DefaultTuning()) })
+	url, _ := apiServer(t, 0)
+
+	for _, endpoint := range []string{"/one-billion-row-challenge", "/anomaly-detection"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "measurements.txt")
+		part.Write([]byte("A;1.0\n" + strings.Repeat("B", 100) + ";2.0\n"))
+		mw.Close()
+		resp, err := http.Post(url+endpoint, mw.FormDataContentType(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422 for a long line, got %d", endpoint, resp.StatusCode)
+		}
+	}
+}
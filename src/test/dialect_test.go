@@ -0,0 +1,107 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitRecordDialects(t *testing.T) {
+	csv := utilities.DefaultDialect
+	csv.Delimiter = ','
+	csv.Quote = '"'
+
+	tsv := utilities.DefaultDialect
+	tsv.Delimiter = '\t'
+	tsv.StationColumn = 2
+	tsv.ValueColumn = 1
+
+	tests := []struct {
+		name    string
+		line    string
+		dialect models.Dialect
+		station string
+		temp    string
+		ok      bool
+	}{
+		{"canonical", "Hamburg;12.0", utilities.DefaultDialect, "Hamburg", "12.0", true},
+		{"crlf", "Hamburg;12.0\r", utilities.DefaultDialect, "Hamburg", "12.0", true},
+		{"quoted", `"St. John's, NL",-3.4`, csv, "St. John's, NL", "-3.4", true},
+		{"escaped quote", `"The ""Hub""",1.0`, csv, `The "Hub"`, "1.0", true},
+		{"extra columns", "2024-01-01T00:00:00Z\t8.5\tOslo\tsensor-7", tsv, "Oslo", "8.5", true},
+		{"missing column", "2024-01-01T00:00:00Z\t8.5", tsv, "", "", false},
+		{"blank", "", utilities.DefaultDialect, "", "", false},
+	}
+	for _, tt := range tests {
+		d := tt.dialect
+		got, ok := utilities.SplitRecord([]byte(tt.line), &d)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && (string(got.Station) != tt.station || string(got.Temperature) != tt.temp) {
+			t.Errorf("%s: got %q/%q, want %q/%q", tt.name, got.Station, got.Temperature, tt.station, tt.temp)
+		}
+	}
+}
+
+func TestDecodeReaderWithHeaderAndComments(t *testing.T) {
+	input := "# exported by sensor gateway\r\n" +
+		"timestamp,city,reading\r\n" +
+		"2024-01-01T00:00:00Z,Hamburg,10.0\r\n" +
+		"# maintenance window\r\n" +
+		"2024-01-01T01:00:00Z,Hamburg,14.0\r\n" +
+		"2024-01-01T01:00:00Z,Oslo,-2.0"
+
+	d := utilities.DefaultDialect
+	d.Delimiter = ','
+	d.Comment = '#'
+	d.HasHeader = true
+	d.StationName = "city"
+	d.ValueName = "reading"
+
+	reader := strings.NewReader(input)
+	d, err := utilities.PrepareDialect(d, reader, int64(len(input)))
+	if err != nil {
+		t.Fatalf("PrepareDialect error: %v", err)
+	}
+	if d.StationColumn != 1 || d.ValueColumn != 2 {
+		t.Fatalf("PrepareDialect got columns %d/%d, want 1/2", d.StationColumn, d.ValueColumn)
+	}
+
+	result := make(map[string]models.TempStat)
+	dec := utilities.NewDecoder(models.ProcessOptions{Dialect: d})
+	if err := dec.DecodeReader(reader, result); err != nil {
+		t.Fatalf("DecodeReader error: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 stations, got %d: %v", len(result), result)
+	}
+	if h := result["Hamburg"]; h.Count != 2 || h.Min != 10 || h.Max != 14 {
+		t.Errorf("Hamburg stats wrong: %+v", h)
+	}
+}
+
+func TestPrepareDialectResolvesHeader(t *testing.T) {
+	input := []byte("station\tvalue\tsensor\nOslo\t1.5\ts1\n")
+	d := utilities.DefaultDialect
+	d.Delimiter = '\t'
+	d.HasHeader = true
+	d.StationName = "station"
+	d.ValueName = "VALUE"
+
+	got, err := utilities.PrepareDialect(d, bytes.NewReader(input), int64(len(input)))
+	if err != nil {
+		t.Fatalf("PrepareDialect error: %v", err)
+	}
+	if got.StationColumn != 0 || got.ValueColumn != 1 {
+		t.Errorf("PrepareDialect got columns %d/%d, want 0/1", got.StationColumn, got.ValueColumn)
+	}
+
+	d.ValueName = "missing"
+	if _, err := utilities.PrepareDialect(d, bytes.NewReader(input), int64(len(input))); err == nil {
+		t.Error("Expected error for unknown column name")
+	}
+}
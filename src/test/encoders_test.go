@@ -0,0 +1,102 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encoderSample() map[string]*models.TempStat {
+	return map[string]*models.TempStat{
+		"Oslo":    {Sum: -3.25, Min: -3.25, Max: -3.25, Count: 1},
+		"Hamburg": {Sum: 26.05, Min: 12.05, Max: 14.0, Count: 2},
+		"A,b":     {Sum: 1, Min: 1, Max: 1, Count: 1},
+	}
+}
+
+func encode(t *testing.T, format string, opts models.ResultOptions) string {
+	t.Helper()
+	enc, ok := utilities.LookupEncoder(format)
+	if !ok {
+		t.Fatalf("No encoder registered for %q", format)
+	}
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, utilities.BuildRows(encoderSample(), opts), opts); err != nil {
+		t.Fatalf("Encode(%s) error: %v", format, err)
+	}
+	return buf.String()
+}
+
+func TestRoundHalfUp(t *testing.T) {
+	tests := []struct {
+		in       float64
+		decimals int
+		want     float64
+	}{
+		{1.25, 1, 1.3},
+		{-1.25, 1, -1.2},
+		{13.025, 0, 13},
+		{0.05, 1, 0.1},
+	}
+	for _, tt := range tests {
+		if got := utilities.RoundHalfUp(tt.in, tt.decimals); got != tt.want {
+			t.Errorf("RoundHalfUp(%v, %d) = %v, want %v", tt.in, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	opts := utilities.DefaultResultOptions
+	if got, want := encode(t, "text", opts), "{A,b=1.0/1.0/1.0, Hamburg=12.1/13.0/14.0, Oslo=-3.2/-3.2/-3.2}\n"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	wantCSV := "station,mean,min,max,count\n\"A,b\",1.0,1.0,1.0,1\nHamburg,13.0,12.1,14.0,2\nOslo,-3.2,-3.2,-3.2,1\n"
+	if got := encode(t, "CSV", opts); got != wantCSV {
+		t.Errorf("csv = %q, want %q", got, wantCSV)
+	}
+
+	opts.Decimals = 2
+	opts.Descending = true
+	lines := strings.Split(strings.TrimSpace(encode(t, "tsv", opts)), "\n")
+	if len(lines) != 4 || lines[1] != "Oslo\t-3.25\t-3.25\t-3.25\t1" || !strings.HasPrefix(lines[3], "A,b\t") {
+		t.Errorf("tsv rows wrong: %q", lines)
+	}
+	if got := encode(t, "ndjson", opts); strings.Count(got, "\n") != 3 || !strings.HasPrefix(got, `{"Station":"Oslo"`) {
+		t.Errorf("ndjson = %q", got)
+	}
+}
+
+func TestBuildRowsBuckets(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	stats := map[string]*models.TempStat{
+		utilities.BucketKey("Oslo", day.Add(time.Hour)): {Sum: 2, Min: 2, Max: 2, Count: 1},
+		utilities.BucketKey("Oslo", day):                {Sum: 1, Min: 1, Max: 1, Count: 1},
+	}
+	rows := utilities.BuildRows(stats, utilities.DefaultResultOptions)
+	if len(rows) != 2 || rows[0].Start == nil || !rows[0].Start.Equal(day) || rows[0].Station != "Oslo" {
+		t.Fatalf("Bucketed rows wrong: %+v", rows)
+	}
+}
+
+func TestNegotiateEncoder(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"text/csv", "csv", true},
+		{"text/csv;q=0.5, text/plain", "text", true},
+		{"application/x-ndjson, */*", "ndjson", true},
+		{"*/*", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		name, _, ok := utilities.NegotiateEncoder(tt.accept)
+		if ok != tt.ok || name != tt.want {
+			t.Errorf("NegotiateEncoder(%q) = %q, %v; want %q, %v", tt.accept, name, ok, tt.want, tt.ok)
+		}
+	}
+}
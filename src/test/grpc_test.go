@@ -0,0 +1,270 @@
+package test
+
+import (
+	"1brc-challange/brcpb"
+	"1brc-challange/cluster"
+	grpc_delivery "1brc-challange/delivery/grpc"
+	"1brc-challange/metrics"
+	"1brc-challange/services"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves the BRC service in memory with the server options opts and returns a
+// client for it.
+func grpcClient(t *testing.T, opts ...grpc.ServerOption) brcpb.BRCClient {
+	t.Helper()
+	return grpcServiceClient(t, services.NewProcessService(2, nil, nil, nil), opts...)
+}
+
+// grpcServiceClient is grpcClient for a service of the test's own.
+func grpcServiceClient(t *testing.T, ps services.ProcessService, opts ...grpc.ServerOption) brcpb.BRCClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc_delivery.NewServer(ps, opts...)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return brcpb.NewBRCClient(conn)
+}
+
+// chunks splits data into pieces of n bytes, cutting through lines on purpose.
+func chunks(data string, n int) [][]byte {
+	var out [][]byte
+	for len(data) > n {
+		out = append(out, []byte(data[:n]))
+		data = data[n:]
+	}
+	return append(out, []byte(data))
+}
+
+func TestGRPCAggregate(t *testing.T) {
+	client := grpcClient(t)
+	var sb strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "Station-%d;%.1f\n", i%3, float64(i%100)/10)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "grpc-7")
+	stream, err := client.Aggregate(ctx)
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	for i, chunk := range chunks(sb.String(), 1000) {
+		req := &brcpb.AggregateRequest{Chunk: chunk}
+		if i == 0 {
+			req.Filename = "measurements.txt"
+			req.Options = &brcpb.InputOptions{}
+		}
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv error: %v", err)
+	}
+	if len(resp.Stations) != 3 || resp.InputSha256 == "" {
+		t.Fatalf("Unexpected response: %v", resp)
+	}
+	if header, err := stream.Header(); err != nil || len(header.Get("x-request-id")) != 1 || header.Get("x-request-id")[0] != "grpc-7" {
+		t.Errorf("Expected the request ID in the header, got %v, %v", header, err)
+	}
+	var rows int64
+	for i, s := range resp.Stations {
+		if s.Station != fmt.Sprintf("Station-%d", i) {
+			t.Errorf("Station %d is %q", i, s.Station)
+		}
+		if s.Min != 0 || s.Max < 9.89 || s.Max > 9.91 {
+			t.Errorf("%s: min %v max %v", s.Station, s.Min, s.Max)
+		}
+		rows += s.Count
+	}
+	if rows != 3000 {
+		t.Errorf("Expected 3000 rows, got %d", rows)
+	}
+}
+
+func TestGRPCAggregateInvalidOptions(t *testing.T) {
+	client := grpcClient(t)
+	stream, err := client.Aggregate(context.Background())
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	stream.Send(&brcpb.AggregateRequest{Options: &brcpb.InputOptions{InputFormat: "xml"}, Chunk: []byte("A;1.0\n")})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
+
+// Input errors are the client's fault over gRPC as they are over HTTP
+func TestGRPCAggregateBadInput(t *testing.T) {
+	client := grpcClient(t)
+	for _, tc := range []struct {
+		name    string
+		options *brcpb.InputOptions
+		chunk   string
+		want    string
+	}{
+		{"empty value", &brcpb.InputOptions{EmptyPolicy: "fail"}, "A;1.0\nB;\n", "empty temperature value"},
+		{"corrupt columnar", &brcpb.InputOptions{InputFormat: "columnar"}, "1BRCCOL\x00garbage", "corrupt columnar file"},
+	} {
+		stream, err := client.Aggregate(context.Background())
+		if err != nil {
+			t.Fatalf("Aggregate error: %v", err)
+		}
+		stream.Send(&brcpb.AggregateRequest{Options: tc.options, Chunk: []byte(tc.chunk)})
+		_, err = stream.CloseAndRecv()
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected InvalidArgument with %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestGRPCDetectAnomaliesStreams(t *testing.T) {
+	client := grpcClient(t)
+	stream, err := client.DetectAnomalies(context.Background())
+	if err != nil {
+		t.Fatalf("DetectAnomalies error: %v", err)
+	}
+	max := float32(50)
+	data := "Oslo;10.0\nOslo;80.0\nLima;20.0\nLima;99.5\nOslo;12.0\n"
+	for i, chunk := range chunks(data, 7) {
+		req := &brcpb.DetectRequest{Chunk: chunk}
+		if i == 0 {
+			req.Rules = &brcpb.AnomalyRules{ExtremeMax: &max}
+		}
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	stream.CloseSend()
+
+	extremes := map[string]float32{}
+	for {
+		a, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv error: %v", err)
+		}
+		if a.Reason == "extreme" {
+			extremes[a.Station] = a.Temp
+		}
+	}
+	if len(extremes) != 2 || extremes["Oslo"] != 80 || extremes["Lima"] != 99.5 {
+		t.Errorf("Unexpected extremes: %v", extremes)
+	}
+}
+
+func TestGRPCAggregateSpoolsToSharedDir(t *testing.T) {
+	path, want := clusterSample(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The worker only opens files below the shared dir, so it only decodes ranges when the
+	// stream was spooled there
+	shared := t.TempDir()
+	co := cluster.NewCoordinator(cluster.Config{SharedPath: true, SharedDir: shared, Token: clusterToken})
+	register(t, co, startWorker(t, shared).URL, 2)
+	client := grpcServiceClient(t, services.NewProcessService(2, nil, nil, co))
+	onDisk := testutil.ToFloat64(metrics.TempFileBytes)
+
+	stream, err := client.Aggregate(context.Background())
+	if err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	for _, chunk := range chunks(string(data), 64*1024) {
+		if err := stream.Send(&brcpb.AggregateRequest{Chunk: chunk}); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv error: %v", err)
+	}
+	if len(resp.Stations) != len(want) {
+		t.Errorf("Expected %d stations, got %d", len(want), len(resp.Stations))
+	}
+	for _, s := range resp.Stations {
+		if w := want[s.Station]; w == nil || s.Count != int64(w.Count) {
+			t.Errorf("%s: count %d, want %v", s.Station, s.Count, w)
+		}
+	}
+	if w := co.Workers()[0]; w.Done == 0 {
+		t.Errorf("Expected the worker to decode ranges of the shared spool, it did none (%d failed)", w.Failed)
+	}
+	if entries, _ := os.ReadDir(shared); len(entries) != 0 {
+		t.Errorf("Expected the spool to be removed, found %d files", len(entries))
+	}
+	if left := testutil.ToFloat64(metrics.TempFileBytes) - onDisk; left != 0 {
+		t.Errorf("Expected the removed spool to leave the temp file gauge, %v bytes remain", left)
+	}
+}
+
+func TestGRPCDetectAnomaliesBeforeStreamEnds(t *testing.T) {
+	client := grpcClient(t)
+	stream, err := client.DetectAnomalies(context.Background())
+	if err != nil {
+		t.Fatalf("DetectAnomalies error: %v", err)
+	}
+	defer stream.CloseSend()
+	// The detector reads the first 64KB before it decodes, so send more than that
+	var sb strings.Builder
+	for sb.Len() < 128*1024 {
+		sb.WriteString("Oslo;10.0\n")
+	}
+	sb.WriteString("Oslo;80.0\n")
+	max := float32(50)
+	for i, chunk := range chunks(sb.String(), 16*1024) {
+		req := &brcpb.DetectRequest{Chunk: chunk}
+		if i == 0 {
+			req.Rules = &brcpb.AnomalyRules{ExtremeMax: &max}
+		}
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+
+	// The send side is still open, so the anomaly can only come from the part received so far
+	got := make(chan *brcpb.Anomaly, 1)
+	go func() {
+		a, err := stream.Recv()
+		if err != nil {
+			t.Errorf("Recv error: %v", err)
+		}
+		got <- a
+	}()
+	select {
+	case a := <-got:
+		if a.GetStation() != "Oslo" || a.GetReason() != "extreme" || a.GetTemp() != 80 {
+			t.Errorf("Unexpected anomaly: %v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No anomaly arrived while the stream was open")
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/health"
+	"1brc-challange/store"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// probe serves the readiness checks through the API routes and decodes /readyz.
+func probe(t *testing.T, handler *http_delivery.ClientHandler) (int, health.Report) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+	w := adminRequest(api.Router, http.MethodGet, "/readyz", nil, "")
+	var report health.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("/readyz answered %s: %v", w.Body, err)
+	}
+	return w.Code, report
+}
+
+func TestReadyz(t *testing.T) {
+	dir := t.TempDir()
+	runs := openRuns(t, store.Config{})
+	var queued int64
+	handler := http_delivery.NewClientHandler(2, nil, runs, nil, nil)
+	handler.Readiness = health.NewChecker(time.Second,
+		health.TempDir(dir),
+		health.DiskSpace(dir, 1),
+		health.Memory(0, 0),
+		health.Saturation("queue", func() int64 { return queued }, 2),
+		health.Ping("run_history", runs.Ping),
+	)
+
+	code, report := probe(t, handler)
+	if code != http.StatusOK || report.Status != health.StatusOK {
+		t.Fatalf("GET /readyz = %d %+v", code, report)
+	}
+	for name, want := range map[string]string{"temp_dir": "ok", "disk_space": "ok", "memory": "skipped", "queue": "ok", "run_history": "ok"} {
+		if got := report.Checks[name].Status; got != want {
+			t.Errorf("%s: status %q, want %q", name, got, want)
+		}
+	}
+	if free, ok := report.Checks["disk_space"].Details["free_bytes"].(float64); !ok || free <= 0 {
+		t.Errorf("disk_space details %v", report.Checks["disk_space"].Details)
+	}
+
+	// A saturated queue and a closed store fail their checks
+	queued = 2
+	runs.Close()
+	code, report = probe(t, handler)
+	if code != http.StatusServiceUnavailable || report.Status != health.StatusFail ||
+		report.Checks["queue"].Status != health.StatusFail || report.Checks["run_history"].Status != health.StatusFail ||
+		report.Checks["temp_dir"].Status != health.StatusOK {
+		t.Errorf("GET /readyz = %d %+v", code, report)
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	checker := health.NewChecker(50*time.Millisecond,
+		health.TempDir(filepath.Join(t.TempDir(), "missing")),
+		health.DiskSpace(t.TempDir(), 1<<62),
+		health.Memory(1<<20, 0),
+		health.Check{Name: "slow", Run: func(ctx context.Context) (health.Details, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		}},
+	)
+	report := checker.Run(context.Background())
+	if report.Ready() {
+		t.Fatal("Expected the checks to fail")
+	}
+	for _, name := range []string{"temp_dir", "disk_space", "memory", "slow"} {
+		if result := report.Checks[name]; result.Status != health.StatusFail || result.Error == "" {
+			t.Errorf("%s: %+v", name, result)
+		}
+	}
+	if used, _ := report.Checks["memory"].Details["used_bytes"].(int64); used <= 0 {
+		t.Errorf("memory details %v", report.Checks["memory"].Details)
+	}
+}
+
+func TestReadyzWhileDraining(t *testing.T) {
+	handler := http_delivery.NewClientHandler(2, nil, nil, nil, nil)
+	handler.StartDraining()
+	code, report := probe(t, handler)
+	if code != http.StatusServiceUnavailable || report.Checks["draining"].Status != health.StatusFail {
+		t.Errorf("GET /readyz while draining = %d %+v", code, report)
+	}
+
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+	if w := adminRequest(api.Router, http.MethodGet, "/livez", nil, ""); w.Code != http.StatusOK {
+		t.Errorf("GET /livez while draining = %d", w.Code)
+	}
+}
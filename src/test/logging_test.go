@@ -0,0 +1,134 @@
+package test
+
+import (
+	"1brc-challange/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logBuffer collects log output written from handler goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the JSON records logged with message msg.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Log line is not JSON: %q", line)
+		}
+		if rec["msg"] == msg {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// captureLogs makes a JSON logger writing to the returned buffer the default for the test.
+func captureLogs(t *testing.T, level string) *logBuffer {
+	t.Helper()
+	buf := &logBuffer{}
+	logger, err := logging.New(buf, logging.Config{Level: level})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return buf
+}
+
+func TestLoggingConfig(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{Level: "warn", Format: "text"})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	logger.InfoContext(logging.WithRequestID(context.Background(), "r1"), "dropped")
+	logger.WarnContext(logging.WithRequestID(context.Background(), "r2"), "kept")
+	if out := buf.String(); strings.Contains(out, "dropped") || !strings.Contains(out, "msg=kept") || !strings.Contains(out, "request_id=r2") {
+		t.Errorf("Unexpected output: %q", out)
+	}
+
+	for _, cfg := range []logging.Config{{Level: "loud"}, {Format: "xml"}} {
+		if _, err := logging.New(&buf, cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+
+	if id := logging.RequestIDFrom("abc-123"); id != "abc-123" {
+		t.Errorf("RequestIDFrom kept %q", id)
+	}
+	for _, bad := range []string{"", "has space", strings.Repeat("x", 200)} {
+		if id := logging.RequestIDFrom(bad); id == bad || len(id) != 32 {
+			t.Errorf("RequestIDFrom(%q) = %q, want a new ID", bad, id)
+		}
+	}
+}
+
+func TestRequestIDAndRunSummary(t *testing.T) {
+	logs := captureLogs(t, "debug")
+	recordSpans(t)
+	url, _ := apiServer(t, 0)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "measurements.txt")
+	part.Write([]byte("A;1.0\nB;2.0\nA;3.0\n"))
+	mw.Close()
+	req, _ := http.NewRequest(http.MethodPost, url+"/one-billion-row-challenge", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Request-ID", "req-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Request-ID") != "req-42" {
+		t.Fatalf("Status %d, X-Request-ID %q", resp.StatusCode, resp.Header.Get("X-Request-ID"))
+	}
+
+	runs := logs.records(t, "run finished")
+	if len(runs) != 1 {
+		t.Fatalf("Expected one run summary, got %v", runs)
+	}
+	run := runs[0]
+	memory, _ := run["memory"].(map[string]any)
+	if run["request_id"] != "req-42" || run["operation"] != "aggregate" || run["rows"] != float64(3) ||
+		run["bytes"] != float64(18) || run["stations"] != float64(2) || memory["alloc_bytes"] == nil || run["trace_id"] == nil {
+		t.Errorf("Unexpected run summary: %v", run)
+	}
+	requests := logs.records(t, "request")
+	if len(requests) != 1 || requests[0]["request_id"] != "req-42" || requests[0]["status"] != float64(200) || requests[0]["trace_id"] == nil {
+		t.Errorf("Unexpected access log: %v", requests)
+	}
+
+	// Without a header the ID is generated
+	resp, err = http.Get(url + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Request-ID"); len(id) != 32 {
+		t.Errorf("Expected a generated request ID, got %q", id)
+	}
+}
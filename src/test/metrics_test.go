@@ -0,0 +1,100 @@
+package test
+
+import (
+	"1brc-challange/metrics"
+	"1brc-challange/models"
+	"1brc-challange/pkg/brc"
+	"1brc-challange/services"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseErrorCategories(t *testing.T) {
+	data := []byte("A;1.0\nno separator\nB;\nC;NaN\nD;abc\nA;3.0\n")
+	for _, workers := range []int{1, 3} {
+		result, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)), brc.WithWorkers(workers))
+		if err != nil {
+			t.Fatalf("workers=%d: Aggregate error: %v", workers, err)
+		}
+		want := map[string]int64{"malformed": 1, "empty": 1, "nan": 1, "invalid_value": 1}
+		if len(result.ParseErrors) != len(want) {
+			t.Fatalf("workers=%d: ParseErrors = %v", workers, result.ParseErrors)
+		}
+		for category, n := range want {
+			if result.ParseErrors[category] != n {
+				t.Errorf("workers=%d: %s = %d, want %d", workers, category, result.ParseErrors[category], n)
+			}
+		}
+		if result.Rows != 2 {
+			t.Errorf("workers=%d: %d rows, want 2", workers, result.Rows)
+		}
+	}
+
+	dialect := utilities.DefaultDialect
+	dialect.HasTimestamp, dialect.TimestampColumn = true, 0
+	dialect.StationColumn, dialect.ValueColumn = 1, 2
+	data = []byte("2024-03-01T10:00:00Z;A;1.0\nyesterday;A;2.0\n")
+	result, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)), brc.WithDialect(dialect), brc.WithTime(models.TimeOptions{Bucket: time.Hour}))
+	if err != nil || result.ParseErrors["timestamp"] != 1 || result.Rows != 1 {
+		t.Errorf("Timestamp errors: %+v, %v", result, err)
+	}
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	metrics.Register()
+	data := []byte("A;1.0\nB;bad\nA;3.0\n")
+	op := "metrics_test"
+	invalid := testutil.ToFloat64(metrics.ParseErrors.WithLabelValues("invalid_value"))
+
+	// The library records nothing unless it is given an observer
+	if _, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)), brc.WithOperation(op)); err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.Rows.WithLabelValues(op)); got != 0 {
+		t.Errorf("rows = %v without an observer, want 0", got)
+	}
+
+	if _, err := brc.Aggregate(context.Background(), bytes.NewReader(data), int64(len(data)), brc.WithOperation(op),
+		brc.WithObserver(services.PipelineObserver)); err != nil {
+		t.Fatalf("Aggregate error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.Rows.WithLabelValues(op)); got != 2 {
+		t.Errorf("rows = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.Bytes.WithLabelValues(op)); got != float64(len(data)) {
+		t.Errorf("bytes = %v, want %d", got, len(data))
+	}
+	if got := testutil.ToFloat64(metrics.Stations.WithLabelValues(op)); got != 1 {
+		t.Errorf("stations = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ParseErrors.WithLabelValues("invalid_value")) - invalid; got != 1 {
+		t.Errorf("invalid_value errors grew by %v, want 1", got)
+	}
+	if testutil.CollectAndCount(metrics.StageDuration) == 0 {
+		t.Error("Expected stage durations to be recorded")
+	}
+
+	extreme := testutil.ToFloat64(metrics.Anomalies.WithLabelValues("extreme"))
+	if _, err := brc.DetectAnomalies(context.Background(), strings.NewReader("A;10.0\nA;90.0\n"), brc.DefaultRules,
+		brc.WithObserver(services.PipelineObserver)); err != nil {
+		t.Fatalf("DetectAnomalies error: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.Anomalies.WithLabelValues("extreme")) - extreme; got != 1 {
+		t.Errorf("extreme anomalies grew by %v, want 1", got)
+	}
+
+	done := metrics.Track(op)
+	if got := testutil.ToFloat64(metrics.InFlight.WithLabelValues(op)); got != 1 {
+		t.Errorf("in flight = %v, want 1", got)
+	}
+	done()
+	if got := testutil.ToFloat64(metrics.InFlight.WithLabelValues(op)); got != 0 {
+		t.Errorf("in flight = %v after done, want 0", got)
+	}
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitJSONRecord(t *testing.T) {
+	fields := models.JSONFields{Station: "station", Value: "temp", Timestamp: "ts"}
+	tests := []struct {
+		line    string
+		station string
+		temp    string
+		ts      string
+		ok      bool
+	}{
+		{`{"station":"Hamburg","temp":12.0}`, "Hamburg", "12.0", "", true},
+		{` { "temp" : -3.5 , "station" : "Oslo" } `, "Oslo", "-3.5", "", true},
+		{`{"station":"São Paulo","temp":"21.4","ts":"2024-03-01T10:00:00Z"}`, "São Paulo", "21.4", "2024-03-01T10:00:00Z", true},
+		{`{"station":"The \"Hub\"","meta":{"tags":["a","}"]},"temp":1e1}`, `The "Hub"`, "1e1", "", true},
+		{`{"station":"Lima","temp":null}`, "Lima", "", "", true},
+		{`{"temp":1.0}`, "", "", "", false},
+		{`not json`, "", "", "", false},
+		{`{"station":"Cut","temp":1.0`, "", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := utilities.SplitJSONRecord([]byte(tt.line), &fields)
+		if ok != tt.ok {
+			t.Errorf("SplitJSONRecord(%s) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if ok && (string(got.Station) != tt.station || string(got.Temperature) != tt.temp || string(got.Timestamp) != tt.ts) {
+			t.Errorf("SplitJSONRecord(%s) = %q/%q/%q, want %q/%q/%q", tt.line, got.Station, got.Temperature, got.Timestamp, tt.station, tt.temp, tt.ts)
+		}
+	}
+}
+
+func TestDecodeReaderNDJSON(t *testing.T) {
+	input := "{\"city\":\"Hamburg\",\"reading\":10.0}\r\n" +
+		"\n" +
+		"{\"city\":\"Hamburg\",\"reading\":14.0}\n" +
+		"{\"city\":\"Oslo\",\"reading\":-2.0}"
+	opts := models.ProcessOptions{
+		Format: models.FormatNDJSON,
+		JSON:   models.JSONFields{Station: "city", Value: "reading"},
+	}
+	result := make(map[string]models.TempStat)
+	if err := utilities.NewDecoder(opts).DecodeReader(strings.NewReader(input), result); err != nil {
+		t.Fatalf("DecodeReader error: %v", err)
+	}
+	if h := result["Hamburg"]; h.Count != 2 || h.Sum != 24 {
+		t.Errorf("Hamburg stats wrong: %+v", h)
+	}
+	if o := result["Oslo"]; o.Count != 1 || o.Min != -2 {
+		t.Errorf("Oslo stats wrong: %+v", o)
+	}
+}
+
+func benchmarkInput(ndjson bool) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		station := fmt.Sprintf("Station-%03d", i%400)
+		temp := fmt.Sprintf("%.1f", float64(i%1000)/10-50)
+		if ndjson {
+			fmt.Fprintf(&buf, "{\"station\":%q,\"temp\":%s}\n", station, temp)
+		} else {
+			fmt.Fprintf(&buf, "%s;%s\n", station, temp)
+		}
+	}
+	return buf.Bytes()
+}
+
+func benchmarkDecode(b *testing.B, opts models.ProcessOptions, input []byte) {
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		result := make(map[string]models.TempStat)
+		if err := utilities.NewDecoder(opts).DecodeReader(bytes.NewReader(input), result); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeText(b *testing.B) {
+	benchmarkDecode(b, models.ProcessOptions{Dialect: utilities.DefaultDialect}, benchmarkInput(false))
+}
+
+func BenchmarkDecodeNDJSON(b *testing.B) {
+	opts := models.ProcessOptions{Format: models.FormatNDJSON, JSON: utilities.DefaultJSONFields}
+	benchmarkDecode(b, opts, benchmarkInput(true))
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func samplePartials(t *testing.T) *models.PartialSet {
+	t.Helper()
+	dec := utilities.NewDecoder(models.ProcessOptions{Dialect: utilities.DefaultDialect})
+	set := &models.PartialSet{Version: utilities.PartialsVersion}
+	for _, input := range []string{"Oslo;1.0\nOslo;3.0\nLima;-2.5\n", "Oslo;2.0\nCairo;40.1\nLima;4.5\n"} {
+		p := models.Partial{Stations: make(map[string]models.TempStat), Sketches: make(map[string]*models.TempSketch)}
+		if err := dec.DecodeReaderSketches(strings.NewReader(input), p.Stations, p.Sketches); err != nil {
+			t.Fatalf("DecodeReaderSketches error: %v", err)
+		}
+		set.Partials = append(set.Partials, p)
+	}
+	return set
+}
+
+func TestPartialsRoundTrip(t *testing.T) {
+	set := samplePartials(t)
+	for _, enc := range []utilities.PartialsEncoding{utilities.PartialsBinary, utilities.PartialsJSON} {
+		var buf bytes.Buffer
+		if err := utilities.EncodePartials(&buf, set, enc); err != nil {
+			t.Fatalf("%s: EncodePartials error: %v", enc, err)
+		}
+		// Partials are decoded as they arrive, also in the smallest reads
+		got, err := utilities.DecodePartials(iotest.OneByteReader(&buf))
+		if err != nil {
+			t.Fatalf("%s: DecodePartials error: %v", enc, err)
+		}
+		if !reflect.DeepEqual(got, set) {
+			t.Errorf("%s: round trip = %+v, want %+v", enc, got, set)
+		}
+	}
+}
+
+func TestMergePartials(t *testing.T) {
+	set := samplePartials(t)
+	stats, sketches, _, err := utilities.MergePartials([]*models.PartialSet{set})
+	if err != nil {
+		t.Fatalf("MergePartials error: %v", err)
+	}
+	want := utilities.MergeResults([]map[string]models.TempStat{set.Partials[0].Stations, set.Partials[1].Stations})
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Merged stats = %v, want %v", stats, want)
+	}
+	if q := utilities.SketchQuantile(sketches["Oslo"], 0.5); q != 2 {
+		t.Errorf("Oslo median = %v, want 2", q)
+	}
+	if q := utilities.SketchQuantile(sketches["Lima"], 0.99); q != 4.5 {
+		t.Errorf("Lima p99 = %v, want 4.5", q)
+	}
+
+	// A partial without sketches drops the quantiles instead of skewing them
+	bare := &models.PartialSet{Version: utilities.PartialsVersion, Partials: []models.Partial{{Stations: set.Partials[0].Stations}}}
+	if _, sketches, _, _ := utilities.MergePartials([]*models.PartialSet{set, bare}); sketches != nil {
+		t.Errorf("Expected no sketches, got %v", sketches)
+	}
+
+	bucketed := &models.PartialSet{Version: utilities.PartialsVersion, Bucket: "1h0m0s"}
+	if _, _, _, err := utilities.MergePartials([]*models.PartialSet{set, bucketed}); !errors.Is(err, utilities.ErrPartialsMismatch) {
+		t.Errorf("Expected ErrPartialsMismatch, got %v", err)
+	}
+}
+
+func TestDecodePartialsRejectsBadInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := utilities.EncodePartials(&buf, samplePartials(t), utilities.PartialsBinary); err != nil {
+		t.Fatalf("EncodePartials error: %v", err)
+	}
+	data := buf.Bytes()
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+	truncated := data[:len(data)-7]
+	future := bytes.Clone(data)
+	future[8] = 9
+	// A station count far beyond the data must fail once the data runs out, not allocate
+	huge := append([]byte("1BRCPRT\x00\x01\x00\x00\x00\x00\x01"), binary.AppendUvarint(nil, 1<<60)...)
+	longKey := append([]byte("1BRCPRT\x00\x01\x00\x00\x00"), binary.AppendUvarint(nil, 1<<40)...)
+
+	cases := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"flipped byte", flipped, utilities.ErrPartialsCorrupt},
+		{"truncated", truncated, utilities.ErrPartialsCorrupt},
+		{"not partials", []byte("Oslo;1.0\n"), utilities.ErrPartialsCorrupt},
+		{"binary version", future, utilities.ErrPartialsVersion},
+		{"huge count", huge, utilities.ErrPartialsCorrupt},
+		{"long key", longKey, utilities.ErrPartialsCorrupt},
+		{"trailing bytes", append(bytes.Clone(data), 0), utilities.ErrPartialsCorrupt},
+		{"json version", []byte(`{"Version":2,"Partials":[]}`), utilities.ErrPartialsVersion},
+	}
+	for _, tc := range cases {
+		if _, err := utilities.DecodePartials(bytes.NewReader(tc.input)); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
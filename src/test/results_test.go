@@ -0,0 +1,70 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"regexp"
+	"testing"
+)
+
+func resultSample() map[string]*models.TempStat {
+	return map[string]*models.TempStat{
+		"Hamburg": {Sum: 26, Min: 12, Max: 14, Count: 2},
+		"Oslo":    {Sum: -3, Min: -3, Max: -3, Count: 1},
+		"Berlin":  {Sum: 19, Min: -1, Max: 20, Count: 2},
+		"Bergen":  {Sum: 5, Min: 5, Max: 5, Count: 1},
+		"Bremen":  {Sum: 5, Min: 5, Max: 5, Count: 1},
+	}
+}
+
+func stationNames(rows []models.StationResult) []string {
+	names := make([]string, len(rows))
+	for i, r := range rows {
+		names[i] = r.Station
+	}
+	return names
+}
+
+func TestBuildRowsQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		opts models.ResultOptions
+		want []string
+	}{
+		{"default", models.ResultOptions{}, []string{"Bergen", "Berlin", "Bremen", "Hamburg", "Oslo"}},
+		{"name desc", models.ResultOptions{Sort: models.SortName, Descending: true}, []string{"Oslo", "Hamburg", "Bremen", "Berlin", "Bergen"}},
+		// equal means keep name order in either direction
+		{"mean desc", models.ResultOptions{Sort: models.SortMean, Descending: true}, []string{"Hamburg", "Berlin", "Bergen", "Bremen", "Oslo"}},
+		{"range", models.ResultOptions{Sort: models.SortRange}, []string{"Bergen", "Bremen", "Oslo", "Hamburg", "Berlin"}},
+		{"count desc", models.ResultOptions{Sort: models.SortCount, Descending: true}, []string{"Berlin", "Hamburg", "Bergen", "Bremen", "Oslo"}},
+		{"include/exclude", models.ResultOptions{Include: []string{"Oslo", "Bergen", "Nowhere"}, Exclude: []string{"Oslo"}}, []string{"Bergen"}},
+		{"prefix", models.ResultOptions{Prefix: "Ber"}, []string{"Bergen", "Berlin"}},
+		{"regex", models.ResultOptions{Match: regexp.MustCompile(`^B.*en$`)}, []string{"Bergen", "Bremen"}},
+	}
+	for _, tt := range tests {
+		got := stationNames(utilities.BuildRows(resultSample(), tt.opts))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPageRows(t *testing.T) {
+	rows := utilities.BuildRows(resultSample(), utilities.DefaultResultOptions)
+	if got := stationNames(utilities.PageRows(rows, models.ResultOptions{Offset: 1, Limit: 2})); len(got) != 2 || got[0] != "Berlin" || got[1] != "Bremen" {
+		t.Errorf("offset 1 limit 2 = %v", got)
+	}
+	if got := utilities.PageRows(rows, models.ResultOptions{Offset: 10}); len(got) != 0 {
+		t.Errorf("offset past the end = %v", stationNames(got))
+	}
+	if got := utilities.PageRows(rows, models.ResultOptions{Offset: 3}); len(got) != 2 {
+		t.Errorf("offset 3 without limit = %v", stationNames(got))
+	}
+}
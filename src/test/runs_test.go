@@ -0,0 +1,101 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/store"
+	"1brc-challange/utilities"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openRuns(t *testing.T, cfg store.Config) *store.RunStore {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "runs.db")
+	}
+	runs, err := store.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	t.Cleanup(func() { runs.Close() })
+	return runs
+}
+
+func TestRunStoreRecordAndGet(t *testing.T) {
+	runs := openRuns(t, store.Config{})
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	stats := map[string]*models.TempStat{
+		"Oslo":                             {Sum: 3, Min: 1, Max: 2, Count: 2},
+		"Oslo Airport":                     {Sum: 5, Min: 5, Max: 5, Count: 1},
+		utilities.BucketKey("Oslo", start): {Sum: 1, Min: 1, Max: 1, Count: 1},
+	}
+	anomalies := []*models.Anomaly{{Station: "Oslo", Temp: 99, Reason: "extreme"}, {Station: "Lima", Temp: -80, Reason: "extreme"}}
+	run := &models.Run{Kind: models.RunAggregate, InputHash: "abc", Rows: 3}
+	if err := runs.Record(run, stats, anomalies); err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+	if run.ID == "" || run.CreatedAt.IsZero() {
+		t.Fatalf("Record did not assign ID and time: %+v", run)
+	}
+
+	got, gotStats, gotAnomalies, err := runs.Get(run.ID)
+	if err != nil || got.InputHash != "abc" || len(gotStats) != 3 || len(gotAnomalies) != 2 {
+		t.Fatalf("Get = %+v, %d stats, %d anomalies, %v", got, len(gotStats), len(gotAnomalies), err)
+	}
+
+	// a station lookup returns the station and its buckets, but not stations sharing its prefix
+	_, oslo, osloAnomalies, err := runs.Station(run.ID, "Oslo")
+	if err != nil || len(oslo) != 2 || oslo["Oslo"].Count != 2 || len(osloAnomalies) != 1 {
+		t.Errorf("Station(Oslo) = %v, %d anomalies, %v", oslo, len(osloAnomalies), err)
+	}
+	if _, _, _, err := runs.Station(run.ID, "Bergen"); !errors.Is(err, store.ErrStationNotFound) {
+		t.Errorf("Expected ErrStationNotFound, got %v", err)
+	}
+	if _, _, _, err := runs.Get("missing"); !errors.Is(err, store.ErrRunNotFound) {
+		t.Errorf("Expected ErrRunNotFound, got %v", err)
+	}
+}
+
+func TestRunStoreListAndRetention(t *testing.T) {
+	runs := openRuns(t, store.Config{MaxRuns: 3})
+	base := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		kind := models.RunAggregate
+		if i%2 == 1 {
+			kind = models.RunAnomaly
+		}
+		run := &models.Run{Kind: kind, CreatedAt: base.Add(time.Duration(i) * time.Second), Rows: int64(i)}
+		if err := runs.Record(run, nil, nil); err != nil {
+			t.Fatalf("Record error: %v", err)
+		}
+	}
+
+	list, total, err := runs.List("", 0, 0)
+	if err != nil || total != 3 || len(list) != 3 {
+		t.Fatalf("List = %d runs of %d, %v; want the 3 newest", len(list), total, err)
+	}
+	if list[0].Rows != 4 || list[2].Rows != 2 {
+		t.Errorf("List is not newest first: %+v", list)
+	}
+
+	list, total, _ = runs.List(models.RunAggregate, 1, 1)
+	if total != 2 || len(list) != 1 || list[0].Rows != 2 {
+		t.Errorf("Filtered page = %+v of %d", list, total)
+	}
+}
+
+func TestRunStoreMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.db")
+	runs := openRuns(t, store.Config{Path: path})
+	runs.Record(&models.Run{Kind: models.RunAggregate, CreatedAt: time.Now().Add(-2 * time.Hour)}, nil, nil)
+	runs.Record(&models.Run{Kind: models.RunAggregate}, nil, nil)
+	runs.Close()
+
+	// retention is applied again when the store is reopened
+	runs = openRuns(t, store.Config{Path: path, MaxAge: time.Hour})
+	if _, total, _ := runs.List("", 0, 0); total != 1 {
+		t.Errorf("Expected the old run to be pruned, %d runs left", total)
+	}
+}
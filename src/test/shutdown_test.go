@@ -0,0 +1,98 @@
+package test
+
+import (
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/utilities"
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSweepTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	files := map[string]bool{ // name: removed
+		"upload-123.tmp":   true,
+		"ingest-9.brc":     true,
+		"multipart-77":     true,
+		"spill-5.run":      true,
+		"upload-new.tmp":   false, // may belong to a run in flight
+		"measurements.txt": false,
+	}
+	for name, removed := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if removed || name == "measurements.txt" {
+			os.Chtimes(path, old, old)
+		}
+	}
+
+	n, err := utilities.SweepTempFiles(dir, time.Hour)
+	if err != nil || n != 4 {
+		t.Fatalf("SweepTempFiles = %d, %v; want 4 removed", n, err)
+	}
+	for name, removed := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists == removed {
+			t.Errorf("%s: exists = %v", name, exists)
+		}
+	}
+}
+
+func TestHealthCheckWhileDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := http_delivery.NewClientHandler(2, nil, nil, nil, nil)
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: handler}
+	api.SetupRoutes()
+
+	if w := adminRequest(api.Router, http.MethodGet, "/health", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("GET /health = %d", w.Code)
+	}
+	handler.StartDraining()
+	w := adminRequest(api.Router, http.MethodGet, "/health", nil, "")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "draining") {
+		t.Errorf("GET /health while draining = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCancelledRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Spool every upload, so cancellation is also seen while copying to disk
+	tuning := utilities.DefaultTuning()
+	tuning.MemoryThreshold = 0
+	utilities.Configure(tuning)
+	t.Cleanup(func() { utilities.Configure(utilities.DefaultTuning()) })
+	api := delivery.RouteConfig{Router: gin.New(), ClientHandler: http_delivery.NewClientHandler(2, nil, nil, nil, nil)}
+	api.SetupRoutes()
+
+	for _, endpoint := range []string{"/one-billion-row-challenge", "/anomaly-detection"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("file", "measurements.txt")
+		part.Write([]byte(strings.Repeat("A;1.0\nB;2.0\n", 1000)))
+		mw.Close()
+
+		// A request whose context is cancelled, as when the drain deadline passes
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest(http.MethodPost, endpoint, &body).WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		api.Router.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: cancelled run = %d %s", endpoint, w.Code, w.Body)
+		}
+	}
+}
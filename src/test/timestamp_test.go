@@ -0,0 +1,108 @@
+package test
+
+import (
+	"1brc-challange/models"
+	"1brc-challange/utilities"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		input  string
+		format string
+	}{
+		{"2024-03-01T12:30:00Z", models.TimeFormatRFC3339},
+		{"2024-03-01T13:30:00+01:00", models.TimeFormatAuto},
+		{"1709296200", models.TimeFormatAuto},
+		{"1709296200000", models.TimeFormatAuto},
+		{"1709296200000", models.TimeFormatUnixMs},
+		{"1709296200.0", models.TimeFormatUnix},
+	}
+	for _, tt := range tests {
+		got, err := utilities.ParseTimestamp([]byte(tt.input), tt.format)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q, %q) error: %v", tt.input, tt.format, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTimestamp(%q, %q) = %v, want %v", tt.input, tt.format, got, want)
+		}
+	}
+}
+
+func TestBucketStartTimeZone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	ts := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC) // 00:30 on 2 March in Berlin
+	day := utilities.BucketStart(ts, 24*time.Hour, berlin)
+	if want := time.Date(2024, 3, 2, 0, 0, 0, 0, berlin); !day.Equal(want) {
+		t.Errorf("daily bucket = %v, want %v", day, want)
+	}
+	hour := utilities.BucketStart(ts, time.Hour, time.UTC)
+	if want := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC); !hour.Equal(want) {
+		t.Errorf("hourly bucket = %v, want %v", hour, want)
+	}
+}
+
+func TestDecodeHourlyBuckets(t *testing.T) {
+	input := "Hamburg;2024-03-01T10:05:00Z;10.0\n" +
+		"Hamburg;2024-03-01T10:55:00Z;12.0\n" +
+		"Hamburg;2024-03-01T11:10:00Z;20.0\n" +
+		"Oslo;1709287200;-1.0\n"
+
+	d := utilities.DefaultDialect
+	d.HasTimestamp = true
+	d.TimestampColumn = 1
+	d.ValueColumn = 2
+	opts := models.ProcessOptions{Dialect: d, Time: models.TimeOptions{Bucket: time.Hour}}
+
+	result := make(map[string]models.TempStat)
+	if err := utilities.NewDecoder(opts).DecodeReader(strings.NewReader(input), result); err != nil {
+		t.Fatalf("DecodeReader error: %v", err)
+	}
+	buckets := utilities.GroupBuckets(utilities.MergeResults([]map[string]models.TempStat{result}), time.UTC)
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d: %+v", len(buckets), buckets)
+	}
+	first := buckets[0]
+	if first.Station != "Hamburg" || first.Count != 2 || first.Sum != 22 || first.Start.Hour() != 10 {
+		t.Errorf("first bucket wrong: %+v", first)
+	}
+	if last := buckets[2]; last.Station != "Oslo" || last.Start.Hour() != 10 {
+		t.Errorf("Oslo bucket wrong: %+v", last)
+	}
+}
+
+func TestDetectAnomaliesSpikeRate(t *testing.T) {
+	in := make(chan models.LineSplit, 3)
+	out := make(chan models.Anomaly, 3)
+	var mu sync.Mutex
+	var total, spikes int32
+
+	// +15 °C over an hour is slow, +15 °C within a minute is a spike.
+	in <- models.LineSplit{Station: []byte("A"), Temperature: []byte("0.0"), Timestamp: []byte("2024-03-01T10:00:00Z")}
+	in <- models.LineSplit{Station: []byte("A"), Temperature: []byte("15.0"), Timestamp: []byte("2024-03-01T11:00:00Z")}
+	in <- models.LineSplit{Station: []byte("A"), Temperature: []byte("30.0"), Timestamp: []byte("2024-03-01T11:01:00Z")}
+	close(in)
+
+	rules := utilities.DefaultAnomalyRules
+	rules.SpikeRate = 5
+	err := utilities.DetectAnomaliesWithRules(in, out, map[string]float32{}, map[string]time.Time{}, &mu, &total, &spikes, nil, models.ProcessOptions{Rules: rules})
+	close(out)
+	if err != nil {
+		t.Fatalf("DetectAnomaliesWithRules error: %v", err)
+	}
+	var got []models.Anomaly
+	for a := range out {
+		got = append(got, a)
+	}
+	if len(got) != 1 || got[0].Temp != 30 || got[0].Reason != "spike" || got[0].Time == nil {
+		t.Errorf("Expected a single timed spike at 30.0, got %+v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"1brc-challange/client"
+	"1brc-challange/tracing"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps every finished span in memory.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingAggregateRequest(t *testing.T) {
+	recorder := recordSpans(t)
+	url, _ := apiServer(t, 0)
+
+	// The client sends the trace of its context as a W3C traceparent header
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "caller")
+	if _, err := client.New(url).AggregateFile(ctx, clientSample(t), client.Options{}); err != nil {
+		t.Fatalf("AggregateFile error: %v", err)
+	}
+	parent.End()
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			continue
+		}
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	handler := byName["POST /one-billion-row-challenge"]
+	if len(handler) != 1 || handler[0].SpanKind() != trace.SpanKindServer || handler[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("Expected one server span under the caller, got %v", byName)
+	}
+	for _, name := range []string{"spool", "split", "merge"} {
+		if len(byName[name]) != 1 {
+			t.Errorf("Expected one %s span, got %d", name, len(byName[name]))
+		}
+	}
+	parts := byName["decode_part"]
+	if len(parts) == 0 {
+		t.Fatal("Expected decode_part spans")
+	}
+	var size int64
+	for _, span := range parts {
+		v, ok := spanAttr(span, "part.size")
+		if _, hasOffset := spanAttr(span, "part.offset"); !ok || !hasOffset {
+			t.Fatalf("decode_part without offset and size: %v", span.Attributes())
+		}
+		size += v.AsInt64()
+	}
+	info, _ := os.Stat(clientSample(t))
+	if size != info.Size() {
+		t.Errorf("decode_part spans cover %d bytes, want %d", size, info.Size())
+	}
+}
+
+func TestTracingFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("Setup error: %v", err)
+	}
+	_, span := tracing.Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), `"Name":"exported"`) {
+		t.Errorf("Expected the span in %s, got %q, %v", path, data, err)
+	}
+
+	if _, err := tracing.Setup(context.Background(), tracing.Config{Exporter: "zipkin"}); err == nil {
+		t.Error("Expected an unknown exporter to fail")
+	}
+}
@@ -1,10 +1,18 @@
 package test
 
 import (
+	"1brc-challange/cluster"
 	"1brc-challange/models"
+	"1brc-challange/store"
 	"1brc-challange/utilities"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -102,3 +110,75 @@ func TestWriteCSVAndRead(t *testing.T) {
 	}
 	_ = os.Remove(filename)
 }
+
+func TestDecodeTempFlexible(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float32
+		wantErr  error
+	}{
+		{"12.34", 12.34, nil},
+		{"5", 5, nil},
+		{"-7", -7, nil},
+		{"1.5e1", 15, nil},
+		{" 3.0 ", 3, nil},
+		{"21.5°C", 21.5, nil},
+		{"212F", 100, nil},
+		{"32 °F", 0, nil},
+		{"273.15K", 0, nil},
+		{"", 0, utilities.ErrEmptyValue},
+		{"NaN", 0, utilities.ErrNaNValue},
+	}
+	for _, tt := range tests {
+		got, err := utilities.DecodeTemp([]byte(tt.input))
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeTemp(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeTemp(%q) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if math.Abs(float64(got-tt.expected)) > 1e-4 {
+			t.Errorf("DecodeTemp(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseTempPolicies(t *testing.T) {
+	if _, ok, err := utilities.ParseTemp([]byte(""), models.NumericOptions{}); ok || err != nil {
+		t.Errorf("default policy should skip empty values, got ok=%v err=%v", ok, err)
+	}
+	if v, ok, err := utilities.ParseTemp([]byte("nan"), models.NumericOptions{NaN: models.PolicyZero}); !ok || err != nil || v != 0 {
+		t.Errorf("zero policy should count NaN as 0, got %v ok=%v err=%v", v, ok, err)
+	}
+	if _, _, err := utilities.ParseTemp([]byte(" "), models.NumericOptions{Empty: models.PolicyFail}); !errors.Is(err, utilities.ErrEmptyValue) {
+		t.Errorf("fail policy should return ErrEmptyValue, got %v", err)
+	}
+}
+
+func TestBadInputErrors(t *testing.T) {
+	for _, err := range []error{
+		utilities.ErrEmptyValue, utilities.ErrNaNValue, utilities.ErrLineTooLong, utilities.ErrMemoryBudget,
+		utilities.ErrColumnarCorrupt, utilities.ErrPartialsCorrupt, utilities.ErrPartialsVersion, utilities.ErrPartialsMismatch,
+		cluster.ErrRangeRejected,
+	} {
+		wrapped := fmt.Errorf("part 2: %w", err)
+		if !errors.Is(wrapped, utilities.ErrBadInput) || !errors.Is(wrapped, err) {
+			t.Errorf("%q does not match ErrBadInput and itself", err)
+		}
+		if strings.Contains(err.Error(), "bad input") {
+			t.Errorf("%q carries the ErrBadInput message", err)
+		}
+	}
+	if errors.Is(utilities.ErrEmptyValue, utilities.ErrNaNValue) {
+		t.Error("Input errors match each other")
+	}
+	for _, err := range []error{io.ErrUnexpectedEOF, context.Canceled, store.ErrDuplicateInput, cluster.ErrNoWorkers} {
+		if errors.Is(err, utilities.ErrBadInput) {
+			t.Errorf("%q matches ErrBadInput", err)
+		}
+	}
+}
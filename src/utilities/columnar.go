@@ -0,0 +1,488 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+)
+
+// Columnar on-disk format, version 1. All integers are little endian.
+//
+//	file       = header block* dictionary trailer tail
+//	header     = magic "1BRCCOL\x00" | version u16 | scale u16 | blockRows u32
+//	block      = rows u32 | idWidth u8 | 3 reserved bytes
+//	             station ids (rows * idWidth) | values (rows * i16)
+//	             min i16 | max i16 | crc32 u32 (over everything before it in the block)
+//	dictionary = count u32 | (len uvarint | name)* | crc32 u32
+//	trailer    = dictOffset u64 | blockCount u32
+//	             (offset u64 | rows u32 | min i16 | max i16)* | crc32 u32
+//	tail       = trailerOffset u64 | magic "1BRCEND\x00"
+//
+// Values are temperatures in units of 1/scale °C (scale 10, i.e. tenths). Station ids index
+// the dictionary. The block index in the trailer repeats the per-block min/max so readers
+// can prune blocks without touching them.
+const (
+	columnarVersion   = 1
+	columnarScale     = 10
+	columnarBlockRows = 64 * 1024
+	columnarTailSize  = 16
+	// columnarMaxStations bounds the dictionary read from untrusted files.
+	columnarMaxStations = 1 << 24
+)
+
+var (
+	columnarMagic    = []byte("1BRCCOL\x00")
+	columnarEndMagic = []byte("1BRCEND\x00")
+
+	// ErrColumnarCorrupt is returned when a columnar file fails a structural or checksum check.
+	ErrColumnarCorrupt = BadInput("corrupt columnar file")
+)
+
+// ColumnarBlock describes one block of a columnar file.
+type ColumnarBlock struct {
+	Offset int64
+	Rows   uint32
+	Min    int16
+	Max    int16
+}
+
+// IsColumnar reports whether the input starts with the columnar magic bytes.
+func IsColumnar(r io.ReaderAt) bool {
+	head := make([]byte, len(columnarMagic))
+	n, _ := r.ReadAt(head, 0)
+	return n == len(head) && bytes.Equal(head, columnarMagic)
+}
+
+// ColumnarWriter encodes station readings into the columnar format.
+type ColumnarWriter struct {
+	w   *bufio.Writer
+	off int64
+	err error
+
+	ids      map[string]uint32
+	stations []string
+
+	blockIDs  []uint32
+	blockVals []int16
+	index     []ColumnarBlock
+}
+
+// NewColumnarWriter writes the file header and returns a writer ready for Append.
+func NewColumnarWriter(w io.Writer) *ColumnarWriter {
+	cw := &ColumnarWriter{
+		w:         bufio.NewWriterSize(w, 1<<20),
+		ids:       make(map[string]uint32),
+		blockIDs:  make([]uint32, 0, columnarBlockRows),
+		blockVals: make([]int16, 0, columnarBlockRows),
+	}
+	header := make([]byte, 0, 16)
+	header = append(header, columnarMagic...)
+	header = binary.LittleEndian.AppendUint16(header, columnarVersion)
+	header = binary.LittleEndian.AppendUint16(header, columnarScale)
+	header = binary.LittleEndian.AppendUint32(header, columnarBlockRows)
+	cw.write(header)
+	return cw
+}
+
+// Append adds one reading. The temperature is rounded to tenths of a degree.
+func (cw *ColumnarWriter) Append(station []byte, temp float32) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	scaled := math.Round(float64(temp) * columnarScale)
+	if scaled < math.MinInt16 || scaled > math.MaxInt16 {
+		return fmt.Errorf("temperature %.1f out of range for the columnar format", temp)
+	}
+	id, ok := cw.ids[string(station)]
+	if !ok {
+		id = uint32(len(cw.stations))
+		name := string(station)
+		cw.ids[name] = id
+		cw.stations = append(cw.stations, name)
+	}
+	cw.blockIDs = append(cw.blockIDs, id)
+	cw.blockVals = append(cw.blockVals, int16(scaled))
+	if len(cw.blockIDs) == columnarBlockRows {
+		cw.flushBlock()
+	}
+	return cw.err
+}
+
+// Close writes the last block, the dictionary and the trailer, then flushes the output.
+// It does not close the underlying writer.
+func (cw *ColumnarWriter) Close() error {
+	cw.flushBlock()
+
+	dictOffset := cw.off
+	dict := binary.LittleEndian.AppendUint32(nil, uint32(len(cw.stations)))
+	for _, name := range cw.stations {
+		dict = binary.AppendUvarint(dict, uint64(len(name)))
+		dict = append(dict, name...)
+	}
+	dict = binary.LittleEndian.AppendUint32(dict, crc32.ChecksumIEEE(dict))
+	cw.write(dict)
+
+	trailerOffset := cw.off
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(dictOffset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(cw.index)))
+	for _, b := range cw.index {
+		trailer = binary.LittleEndian.AppendUint64(trailer, uint64(b.Offset))
+		trailer = binary.LittleEndian.AppendUint32(trailer, b.Rows)
+		trailer = binary.LittleEndian.AppendUint16(trailer, uint16(b.Min))
+		trailer = binary.LittleEndian.AppendUint16(trailer, uint16(b.Max))
+	}
+	trailer = binary.LittleEndian.AppendUint32(trailer, crc32.ChecksumIEEE(trailer))
+	trailer = binary.LittleEndian.AppendUint64(trailer, uint64(trailerOffset))
+	trailer = append(trailer, columnarEndMagic...)
+	cw.write(trailer)
+
+	if cw.err != nil {
+		return cw.err
+	}
+	return cw.w.Flush()
+}
+
+// flushBlock encodes the buffered rows as one block.
+func (cw *ColumnarWriter) flushBlock() {
+	rows := len(cw.blockIDs)
+	if rows == 0 || cw.err != nil {
+		return
+	}
+	width := idWidth(uint32(len(cw.stations) - 1))
+	minV, maxV := cw.blockVals[0], cw.blockVals[0]
+	for _, v := range cw.blockVals {
+		minV = min(minV, v)
+		maxV = max(maxV, v)
+	}
+
+	block := make([]byte, 0, 8+rows*(width+2)+8)
+	block = binary.LittleEndian.AppendUint32(block, uint32(rows))
+	block = append(block, byte(width), 0, 0, 0)
+	for _, id := range cw.blockIDs {
+		switch width {
+		case 1:
+			block = append(block, byte(id))
+		case 2:
+			block = binary.LittleEndian.AppendUint16(block, uint16(id))
+		default:
+			block = binary.LittleEndian.AppendUint32(block, id)
+		}
+	}
+	for _, v := range cw.blockVals {
+		block = binary.LittleEndian.AppendUint16(block, uint16(v))
+	}
+	block = binary.LittleEndian.AppendUint16(block, uint16(minV))
+	block = binary.LittleEndian.AppendUint16(block, uint16(maxV))
+	block = binary.LittleEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+
+	cw.index = append(cw.index, ColumnarBlock{Offset: cw.off, Rows: uint32(rows), Min: minV, Max: maxV})
+	cw.write(block)
+	cw.blockIDs = cw.blockIDs[:0]
+	cw.blockVals = cw.blockVals[:0]
+}
+
+func (cw *ColumnarWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.Write(b)
+	cw.off += int64(n)
+	cw.err = err
+}
+
+// idWidth returns the number of bytes needed to store station ids up to maxID.
+func idWidth(maxID uint32) int {
+	switch {
+	case maxID <= math.MaxUint8:
+		return 1
+	case maxID <= math.MaxUint16:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// ColumnarFile is an opened columnar file: its dictionary and block index.
+type ColumnarFile struct {
+	Version   uint16
+	Scale     uint16
+	BlockRows uint32
+	Stations  []string
+	Blocks    []ColumnarBlock
+
+	r    io.ReaderAt
+	size int64
+}
+
+// OpenColumnar reads and verifies the header, trailer and dictionary of a columnar file.
+func OpenColumnar(r io.ReaderAt, size int64) (*ColumnarFile, error) {
+	if size < 16+columnarTailSize {
+		return nil, fmt.Errorf("%w: file too small", ErrColumnarCorrupt)
+	}
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:8], columnarMagic) {
+		return nil, fmt.Errorf("%w: bad magic", ErrColumnarCorrupt)
+	}
+	f := &ColumnarFile{
+		Version:   binary.LittleEndian.Uint16(header[8:]),
+		Scale:     binary.LittleEndian.Uint16(header[10:]),
+		BlockRows: binary.LittleEndian.Uint32(header[12:]),
+		r:         r,
+		size:      size,
+	}
+	if f.Version != columnarVersion {
+		return nil, fmt.Errorf("unsupported columnar version %d", f.Version)
+	}
+	if f.Scale == 0 || f.BlockRows == 0 {
+		return nil, fmt.Errorf("%w: bad header", ErrColumnarCorrupt)
+	}
+
+	tail := make([]byte, columnarTailSize)
+	if _, err := r.ReadAt(tail, size-columnarTailSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tail[8:], columnarEndMagic) {
+		return nil, fmt.Errorf("%w: bad end magic", ErrColumnarCorrupt)
+	}
+	trailerOffset := int64(binary.LittleEndian.Uint64(tail))
+	if trailerOffset < 16 || trailerOffset > size-columnarTailSize-16 {
+		return nil, fmt.Errorf("%w: bad trailer offset", ErrColumnarCorrupt)
+	}
+	trailer := make([]byte, size-columnarTailSize-trailerOffset)
+	if _, err := r.ReadAt(trailer, trailerOffset); err != nil {
+		return nil, err
+	}
+	if err := checkCRC(trailer); err != nil {
+		return nil, err
+	}
+	dictOffset := int64(binary.LittleEndian.Uint64(trailer))
+	count := int(binary.LittleEndian.Uint32(trailer[8:]))
+	if dictOffset < 16 || dictOffset > trailerOffset || len(trailer) != 12+count*16+4 {
+		return nil, fmt.Errorf("%w: bad trailer", ErrColumnarCorrupt)
+	}
+	f.Blocks = make([]ColumnarBlock, count)
+	for i := range f.Blocks {
+		e := trailer[12+i*16:]
+		f.Blocks[i] = ColumnarBlock{
+			Offset: int64(binary.LittleEndian.Uint64(e)),
+			Rows:   binary.LittleEndian.Uint32(e[8:]),
+			Min:    int16(binary.LittleEndian.Uint16(e[12:])),
+			Max:    int16(binary.LittleEndian.Uint16(e[14:])),
+		}
+		if f.Blocks[i].Offset < 16 || f.Blocks[i].Offset >= dictOffset || f.Blocks[i].Rows > f.BlockRows {
+			return nil, fmt.Errorf("%w: bad block index", ErrColumnarCorrupt)
+		}
+	}
+
+	dict := make([]byte, trailerOffset-dictOffset)
+	if _, err := r.ReadAt(dict, dictOffset); err != nil {
+		return nil, err
+	}
+	if err := checkCRC(dict); err != nil {
+		return nil, err
+	}
+	stations, err := decodeDictionary(dict[:len(dict)-4])
+	if err != nil {
+		return nil, err
+	}
+	f.Stations = stations
+	return f, nil
+}
+
+// Rows returns the total number of readings in the file.
+func (f *ColumnarFile) Rows() int64 {
+	var rows int64
+	for _, b := range f.Blocks {
+		rows += int64(b.Rows)
+	}
+	return rows
+}
+
+// Aggregate decodes all blocks with the given number of workers and returns one
+// TempStat map per worker, ready for MergeResults.
+func (f *ColumnarFile) Aggregate(workers int) ([]map[string]models.TempStat, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(f.Blocks) {
+		workers = max(len(f.Blocks), 1)
+	}
+	results := make([]map[string]models.TempStat, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			results[w], errs[w] = f.aggregateBlocks(w, workers)
+		}(w)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// columnarAcc accumulates one station in integer units to avoid float drift.
+type columnarAcc struct {
+	sum      int64
+	count    int32
+	min, max int16
+}
+
+// aggregateBlocks folds every workers-th block, starting at block w, into a dense table
+// indexed by station id, then converts it to a map.
+func (f *ColumnarFile) aggregateBlocks(w, workers int) (map[string]models.TempStat, error) {
+	acc := make([]columnarAcc, len(f.Stations))
+	var buf []byte
+	for i := w; i < len(f.Blocks); i += workers {
+		var err error
+		if buf, err = f.readBlock(f.Blocks[i], buf); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		rows := int(binary.LittleEndian.Uint32(buf))
+		width := int(buf[4])
+		ids := buf[8 : 8+rows*width]
+		vals := buf[8+rows*width:]
+		for r := 0; r < rows; r++ {
+			var id uint32
+			switch width {
+			case 1:
+				id = uint32(ids[r])
+			case 2:
+				id = uint32(binary.LittleEndian.Uint16(ids[r*2:]))
+			default:
+				id = binary.LittleEndian.Uint32(ids[r*4:])
+			}
+			if id >= uint32(len(acc)) {
+				return nil, fmt.Errorf("%w: station id out of range", ErrColumnarCorrupt)
+			}
+			v := int16(binary.LittleEndian.Uint16(vals[r*2:]))
+			a := &acc[id]
+			if a.count == 0 {
+				a.min, a.max = v, v
+			} else {
+				a.min = min(a.min, v)
+				a.max = max(a.max, v)
+			}
+			a.sum += int64(v)
+			a.count++
+		}
+	}
+
+	scale := float32(f.Scale)
+	result := make(map[string]models.TempStat)
+	for id, a := range acc {
+		if a.count == 0 {
+			continue
+		}
+		result[f.Stations[id]] = models.TempStat{
+			Sum:   float32(float64(a.sum) / float64(f.Scale)),
+			Min:   float32(a.min) / scale,
+			Max:   float32(a.max) / scale,
+			Count: a.count,
+		}
+	}
+	return result, nil
+}
+
+// readBlock reads and verifies one block into buf, growing it when needed.
+func (f *ColumnarFile) readBlock(b ColumnarBlock, buf []byte) ([]byte, error) {
+	head := make([]byte, 8)
+	if _, err := f.r.ReadAt(head, b.Offset); err != nil {
+		return buf, err
+	}
+	rows := binary.LittleEndian.Uint32(head)
+	width := int(head[4])
+	if rows != b.Rows || (width != 1 && width != 2 && width != 4) {
+		return buf, fmt.Errorf("%w: bad block header", ErrColumnarCorrupt)
+	}
+	n := 8 + int(rows)*(width+2) + 8
+	if b.Offset+int64(n) > f.size {
+		return buf, fmt.Errorf("%w: block past end of file", ErrColumnarCorrupt)
+	}
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := f.r.ReadAt(buf, b.Offset); err != nil {
+		return buf, err
+	}
+	if err := checkCRC(buf); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// decodeDictionary parses the length-prefixed station names.
+func decodeDictionary(b []byte) ([]string, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: short dictionary", ErrColumnarCorrupt)
+	}
+	count := binary.LittleEndian.Uint32(b)
+	if count > columnarMaxStations || int(count) > len(b) {
+		return nil, fmt.Errorf("%w: bad dictionary size", ErrColumnarCorrupt)
+	}
+	stations := make([]string, count)
+	p := 4
+	for i := range stations {
+		n, size := binary.Uvarint(b[p:])
+		if size <= 0 || n > uint64(len(b)-p-size) {
+			return nil, fmt.Errorf("%w: bad dictionary entry", ErrColumnarCorrupt)
+		}
+		p += size
+		stations[i] = string(b[p : p+int(n)])
+		p += int(n)
+	}
+	return stations, nil
+}
+
+// checkCRC verifies the trailing CRC-32 of a section.
+func checkCRC(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("%w: short section", ErrColumnarCorrupt)
+	}
+	body := b[:len(b)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(b[len(body):]) {
+		return fmt.Errorf("%w: checksum mismatch", ErrColumnarCorrupt)
+	}
+	return nil
+}
+
+// IngestColumnar converts a text or NDJSON stream into the columnar format and returns
+// the number of rows written. Rows are parsed with the decoder's dialect and numeric policy.
+func (dec *Decoder) IngestColumnar(r io.Reader, w io.Writer) (int64, error) {
+	cw := NewColumnarWriter(w)
+	var rows int64
+	err := dec.eachLine(r, dec.HasHeader(), func(line []byte) error {
+		entry, ok := dec.Split(line)
+		if !ok {
+			dec.countMalformed(line)
+			return nil
+		}
+		temp, ok, err := parseTempCounted(entry.Temperature, dec.Numeric, &dec.Errors)
+		if err != nil {
+			return fmt.Errorf("station %q: %w", entry.Station, err)
+		}
+		if !ok {
+			return nil
+		}
+		rows++
+		return cw.Append(entry.Station, temp)
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, cw.Close()
+}
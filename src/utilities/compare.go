@@ -0,0 +1,103 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"math"
+	"sort"
+)
+
+// DefaultMovers is the number of biggest movers listed when none is requested.
+const DefaultMovers = 10
+
+// CompareResults diffs two merged results station by station. Values are rounded to
+// opts.Decimals before they are compared, so differences below the displayed precision
+// count as unchanged. The station filters of opts apply to both sides; movers limits
+// the ranking of the largest mean changes.
+func CompareResults(base, target map[string]*models.TempStat, opts models.ResultOptions, movers int) models.Comparison {
+	loc := resultLocation(opts)
+	include := toSet(opts.Include)
+	exclude := toSet(opts.Exclude)
+	row := func(key string, stat *models.TempStat) (models.StationResult, bool) {
+		if stat == nil || stat.Count == 0 {
+			return models.StationResult{}, false
+		}
+		r := resultRow(key, stat, opts.Decimals, loc)
+		return r, selectStation(r.Station, include, exclude, opts)
+	}
+
+	cmp := models.Comparison{
+		Changed: []models.StationDelta{},
+		Added:   []models.StationResult{},
+		Removed: []models.StationResult{},
+	}
+	for key, stat := range base {
+		b, ok := row(key, stat)
+		if !ok {
+			continue
+		}
+		// The filters only look at the station name, so a miss here means the target lacks the key
+		t, ok := row(key, target[key])
+		if !ok {
+			cmp.Removed = append(cmp.Removed, b)
+			continue
+		}
+		d := models.StationDelta{
+			Station:    b.Station,
+			Start:      b.Start,
+			Base:       b,
+			Target:     t,
+			MeanDelta:  RoundHalfUp(t.Mean-b.Mean, opts.Decimals),
+			MinDelta:   RoundHalfUp(t.Min-b.Min, opts.Decimals),
+			MaxDelta:   RoundHalfUp(t.Max-b.Max, opts.Decimals),
+			CountDelta: t.Count - b.Count,
+		}
+		if d.MeanDelta == 0 && d.MinDelta == 0 && d.MaxDelta == 0 && d.CountDelta == 0 {
+			cmp.Unchanged++
+			continue
+		}
+		cmp.Changed = append(cmp.Changed, d)
+	}
+	for key, stat := range target {
+		if b := base[key]; b != nil && b.Count > 0 {
+			continue
+		}
+		if t, ok := row(key, stat); ok {
+			cmp.Added = append(cmp.Added, t)
+		}
+	}
+
+	sortRows(cmp.Added)
+	sortRows(cmp.Removed)
+	sort.Slice(cmp.Changed, func(i, j int) bool { return deltaLess(&cmp.Changed[i], &cmp.Changed[j]) })
+
+	cmp.Movers = make([]models.StationDelta, 0, len(cmp.Changed))
+	for _, d := range cmp.Changed {
+		if d.MeanDelta != 0 {
+			cmp.Movers = append(cmp.Movers, d)
+		}
+	}
+	sort.SliceStable(cmp.Movers, func(i, j int) bool {
+		return math.Abs(cmp.Movers[i].MeanDelta) > math.Abs(cmp.Movers[j].MeanDelta)
+	})
+	if movers >= 0 && len(cmp.Movers) > movers {
+		cmp.Movers = cmp.Movers[:movers]
+	}
+	return cmp
+}
+
+// sortRows orders rows by station, then bucket start.
+func sortRows(rows []models.StationResult) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Station != rows[j].Station {
+			return rows[i].Station < rows[j].Station
+		}
+		return rows[i].Start != nil && rows[j].Start != nil && rows[i].Start.Before(*rows[j].Start)
+	})
+}
+
+func deltaLess(a, b *models.StationDelta) bool {
+	if a.Station != b.Station {
+		return a.Station < b.Station
+	}
+	return a.Start != nil && b.Start != nil && a.Start.Before(*b.Start)
+}
@@ -0,0 +1,315 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Decoder holds the settings shared by all decode workers of a single run.
+// A Decoder is read-only once workers start, apart from its atomic Errors and its Spills,
+// which are safe for concurrent use, so it can be shared between goroutines.
+type Decoder struct {
+	Format  models.InputFormat
+	Dialect models.Dialect
+	JSON    models.JSONFields
+	Numeric models.NumericOptions
+	Time    models.TimeOptions
+	Errors  ParseErrors // input the decoder could not use
+
+	MemoryBudget int64     // bytes the table of one worker may take, 0 leaves it unbounded
+	BudgetPolicy string    // BudgetSpill or BudgetFail
+	Spills       *SpillSet // where tables over budget are spilled; nil fails them
+}
+
+// bucketRef identifies a station within a time bucket.
+type bucketRef struct {
+	station string
+	start   int64
+}
+
+// keyCache interns station names and bucket keys so each is allocated once per worker.
+// Every key of the table of the worker is interned, so the cache also measures the table.
+type keyCache struct {
+	stations map[string]string
+	buckets  map[bucketRef]string
+	bytes    int64 // estimated size of the keys and their table entries
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{
+		stations: make(map[string]string),
+		buckets:  make(map[bucketRef]string),
+	}
+}
+
+// NewDecoder returns a decoder configured from the processing options.
+func NewDecoder(opts models.ProcessOptions) *Decoder {
+	format := opts.Format
+	if format == "" {
+		format = models.FormatText
+	}
+	return &Decoder{
+		Format:       format,
+		Dialect:      opts.Dialect,
+		JSON:         opts.JSON,
+		Numeric:      opts.Numeric,
+		Time:         opts.Time,
+		MemoryBudget: tuning.MemoryBudget,
+		BudgetPolicy: tuning.BudgetPolicy,
+	}
+}
+
+// Split extracts the station, value and timestamp of one input line in the decoder's format.
+func (dec *Decoder) Split(line []byte) (models.LineSplit, bool) {
+	if dec.Format == models.FormatNDJSON {
+		return SplitJSONRecord(line, &dec.JSON)
+	}
+	return SplitRecord(line, &dec.Dialect)
+}
+
+// HasHeader reports whether the input starts with a header row that must be skipped.
+func (dec *Decoder) HasHeader() bool {
+	return dec.Format == models.FormatText && dec.Dialect.HasHeader
+}
+
+// HasTimestamp reports whether the options configure a timestamp column or field.
+func HasTimestamp(opts models.ProcessOptions) bool {
+	if opts.Format == models.FormatNDJSON {
+		return opts.JSON.Timestamp != ""
+	}
+	return opts.Dialect.HasTimestamp
+}
+
+// IsPreamble reports whether a line may precede the header row.
+func (dec *Decoder) IsPreamble(line []byte) bool {
+	return isPreamble(line, &dec.Dialect)
+}
+
+// DecodePart reads a part of the file and decodes temperature data into a map of TempStat.
+// The part starting at offset 0 skips the header row when the dialect has one.
+func (dec *Decoder) DecodePart(path string, offset, size int64, result map[string]models.TempStat) error {
+	return dec.DecodePartSketches(path, offset, size, result, nil)
+}
+
+// DecodePartSketches is DecodePart that also records every value in sketches when it is not nil.
+func (dec *Decoder) DecodePartSketches(path string, offset, size int64, result map[string]models.TempStat, sketches map[string]*models.TempSketch) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return dec.decode(io.LimitReader(f, size), offset == 0 && dec.HasHeader(), result, sketches)
+}
+
+// DecodeReader decodes a whole input stream, skipping the header row when the dialect has one.
+func (dec *Decoder) DecodeReader(r io.Reader, result map[string]models.TempStat) error {
+	return dec.decode(r, dec.HasHeader(), result, nil)
+}
+
+// DecodeSection decodes one range of an input that was split at line boundaries. Only the
+// range at the start of the input skips the header row.
+func (dec *Decoder) DecodeSection(r io.Reader, first bool, result map[string]models.TempStat) error {
+	return dec.decode(r, first && dec.HasHeader(), result, nil)
+}
+
+// DecodeSectionSketches is DecodeSection that also records every value in sketches when it is not nil.
+func (dec *Decoder) DecodeSectionSketches(r io.Reader, first bool, result map[string]models.TempStat, sketches map[string]*models.TempSketch) error {
+	return dec.decode(r, first && dec.HasHeader(), result, sketches)
+}
+
+// DecodeReaderSketches is DecodeReader that also records every value in sketches when it is not nil.
+func (dec *Decoder) DecodeReaderSketches(r io.Reader, result map[string]models.TempStat, sketches map[string]*models.TempSketch) error {
+	return dec.decode(r, dec.HasHeader(), result, sketches)
+}
+
+// decode reads r chunk by chunk and folds every valid line into result and the optional
+// sketches. When result outgrows the memory budget it is spilled and emptied, so it only
+// holds the stations seen since; the spilled ones are in dec.Spills.
+func (dec *Decoder) decode(r io.Reader, skipFirst bool, result map[string]models.TempStat, sketches map[string]*models.TempSketch) error {
+	cache := newKeyCache()
+	return dec.eachLine(r, skipFirst, func(line []byte) error {
+		if err := dec.decodeLine(line, cache, result, sketches); err != nil {
+			return err
+		}
+		if dec.MemoryBudget > 0 && cache.bytes > dec.MemoryBudget && len(result) > 0 {
+			if err := dec.overBudget(result, sketches, cache.bytes); err != nil {
+				return err
+			}
+			cache = newKeyCache()
+		}
+		return nil
+	})
+}
+
+// eachLine reads r chunk by chunk and calls fn for every line after the optional header row.
+// Lines longer than Tuning.MaxLineLength fail with ErrLineTooLong.
+func (dec *Decoder) eachLine(r io.Reader, skipFirst bool, fn func(line []byte) error) error {
+	buf := make([]byte, tuning.ReadBuffer)
+	var leftover []byte
+
+	for {
+		n, err := r.Read(buf)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+
+		chunk := append(leftover, buf[:n]...)
+		lines := bytes.Split(chunk, []byte{'\n'})
+		leftover = lines[len(lines)-1]
+		if err := checkLineLength(len(leftover)); err != nil {
+			return err
+		}
+
+		for _, line := range lines[:len(lines)-1] {
+			if err := checkLineLength(len(line)); err != nil {
+				return err
+			}
+			if skipFirst {
+				skipFirst = dec.IsPreamble(line)
+				continue
+			}
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// process leftover
+	if len(leftover) > 0 && !skipFirst {
+		return fn(leftover)
+	}
+	return nil
+}
+
+// decodeLine parses a single line and updates the station statistics.
+// It only returns an error when the numeric policy asks the run to fail.
+func (dec *Decoder) decodeLine(line []byte, cache *keyCache, result map[string]models.TempStat, sketches map[string]*models.TempSketch) error {
+	entry, ok := dec.Split(line)
+	if !ok {
+		dec.countMalformed(line)
+		return nil
+	}
+
+	temp, ok, err := parseTempCounted(entry.Temperature, dec.Numeric, &dec.Errors)
+	if err != nil {
+		return fmt.Errorf("station %q: %w", entry.Station, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	raw := entry.Station
+	station, ok := cache.stations[string(raw)]
+	if !ok {
+		station = string(raw)
+		cache.stations[station] = station
+		cache.bytes += int64(len(station)) + tableEntryOverhead
+	}
+
+	if dec.Time.Bucket > 0 {
+		ts, err := ParseTimestamp(entry.Timestamp, dec.Time.Format)
+		if err != nil {
+			dec.Errors.add(errTimestamp)
+			return nil
+		}
+		start := BucketStart(ts, dec.Time.Bucket, dec.Time.Location)
+		ref := bucketRef{station: station, start: start.Unix()}
+		key, ok := cache.buckets[ref]
+		if !ok {
+			key = BucketKey(station, start)
+			cache.buckets[ref] = key
+			cache.bytes += int64(len(key)) + tableEntryOverhead
+		}
+		station = key
+	}
+	if sketches != nil {
+		AddToSketch(sketches, station, temp)
+	}
+
+	stat, exists := result[station]
+	if !exists {
+		result[station] = models.TempStat{
+			Sum:   temp,
+			Min:   temp,
+			Max:   temp,
+			Count: 1,
+		}
+		return nil
+	}
+	stat.Sum += temp
+	stat.Count++
+	if temp > stat.Max {
+		stat.Max = temp
+	}
+	if temp < stat.Min {
+		stat.Min = temp
+	}
+	result[station] = stat
+	return nil
+}
+
+// countMalformed counts a line that did not split, unless it is blank or a comment.
+func (dec *Decoder) countMalformed(line []byte) {
+	if len(bytes.TrimSpace(line)) > 0 && !dec.IsPreamble(line) {
+		dec.Errors.add(errMalformed)
+	}
+}
+
+// ReadHeaderLine returns the header row of the input, used to resolve named columns.
+// Blank and comment lines before the header are skipped.
+func ReadHeaderLine(r io.ReaderAt, size int64, d *models.Dialect) ([]byte, error) {
+	reader := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		if !isPreamble(line, d) {
+			return line, nil
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("input has no header row")
+		}
+	}
+}
+
+// isPreamble reports whether a line is blank or a comment, which may precede the header row.
+func isPreamble(line []byte, d *models.Dialect) bool {
+	line = trimLine(line)
+	return len(line) == 0 || (d.Comment != 0 && line[0] == d.Comment)
+}
+
+// PrepareDialect validates the dialect and resolves named columns against the input's header row.
+func PrepareDialect(d models.Dialect, r io.ReaderAt, size int64) (models.Dialect, error) {
+	if err := ValidateDialect(d); err != nil {
+		return d, err
+	}
+	if !hasNamedColumns(d) {
+		return d, nil
+	}
+	header, err := ReadHeaderLine(r, size, &d)
+	if err != nil {
+		return d, err
+	}
+	if err := ResolveHeader(&d, header); err != nil {
+		return d, err
+	}
+	return d, nil
+}
@@ -0,0 +1,200 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DefaultDialect is the canonical `station;temperature` layout without header or quoting.
+var DefaultDialect = models.Dialect{
+	Delimiter:     ';',
+	StationColumn: 0,
+	ValueColumn:   1,
+}
+
+// ValidateDialect checks that a dialect is consistent before any input is read.
+func ValidateDialect(d models.Dialect) error {
+	switch d.Delimiter {
+	case 0, '\n', '\r':
+		return fmt.Errorf("invalid delimiter %q", d.Delimiter)
+	}
+	if d.Quote != 0 && (d.Quote == d.Delimiter || d.Quote == '\n' || d.Quote == '\r') {
+		return fmt.Errorf("invalid quote character %q", d.Quote)
+	}
+	if d.Comment != 0 && (d.Comment == d.Delimiter || d.Comment == d.Quote) {
+		return fmt.Errorf("invalid comment character %q", d.Comment)
+	}
+	if !hasNamedColumns(d) {
+		return checkColumns(d)
+	}
+	if !d.HasHeader {
+		return fmt.Errorf("column names require a header row")
+	}
+	return nil
+}
+
+// hasNamedColumns reports whether any column is addressed by header name.
+func hasNamedColumns(d models.Dialect) bool {
+	return d.StationName != "" || d.ValueName != "" || (d.HasTimestamp && d.TimestampName != "")
+}
+
+// checkColumns verifies that the resolved column indices are usable and distinct.
+func checkColumns(d models.Dialect) error {
+	if d.StationColumn < 0 || d.ValueColumn < 0 || (d.HasTimestamp && d.TimestampColumn < 0) {
+		return fmt.Errorf("column indices must not be negative")
+	}
+	if d.StationColumn == d.ValueColumn {
+		return fmt.Errorf("station and value columns must differ")
+	}
+	if d.HasTimestamp && (d.TimestampColumn == d.StationColumn || d.TimestampColumn == d.ValueColumn) {
+		return fmt.Errorf("timestamp column must differ from station and value columns")
+	}
+	return nil
+}
+
+// ResolveHeader maps the named columns of d onto indices using the given header line.
+// Columns that are addressed by index are left untouched.
+func ResolveHeader(d *models.Dialect, header []byte) error {
+	names := splitFields(trimLine(header), d)
+	find := func(name string) (int, error) {
+		for i, n := range names {
+			if strings.EqualFold(strings.TrimSpace(string(n)), name) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("column %q not found in header", name)
+	}
+	if d.StationName != "" {
+		idx, err := find(d.StationName)
+		if err != nil {
+			return err
+		}
+		d.StationColumn = idx
+	}
+	if d.ValueName != "" {
+		idx, err := find(d.ValueName)
+		if err != nil {
+			return err
+		}
+		d.ValueColumn = idx
+	}
+	if d.HasTimestamp && d.TimestampName != "" {
+		idx, err := find(d.TimestampName)
+		if err != nil {
+			return err
+		}
+		d.TimestampColumn = idx
+	}
+	return checkColumns(*d)
+}
+
+// SplitRecord extracts the station and value columns of a line according to the dialect.
+// It returns false for blank lines, comment lines and lines missing a column.
+func SplitRecord(line []byte, d *models.Dialect) (models.LineSplit, bool) {
+	line = trimLine(line)
+	if len(line) == 0 || (d.Comment != 0 && line[0] == d.Comment) {
+		return models.LineSplit{}, false
+	}
+
+	// Fast path for the canonical two column layout.
+	if d.Quote == 0 && d.StationColumn == 0 && d.ValueColumn == 1 && !d.HasTimestamp {
+		station, rest, found := bytes.Cut(line, []byte{d.Delimiter})
+		if !found || len(station) == 0 {
+			return models.LineSplit{}, false
+		}
+		if i := bytes.IndexByte(rest, d.Delimiter); i >= 0 {
+			rest = rest[:i]
+		}
+		return models.LineSplit{Station: station, Temperature: rest}, true
+	}
+
+	var entry models.LineSplit
+	last := d.StationColumn
+	if d.ValueColumn > last {
+		last = d.ValueColumn
+	}
+	if d.HasTimestamp && d.TimestampColumn > last {
+		last = d.TimestampColumn
+	}
+	rest := line
+	for col := 0; col <= last; col++ {
+		if rest == nil {
+			return models.LineSplit{}, false
+		}
+		var field []byte
+		field, rest = nextField(rest, d)
+		switch col {
+		case d.StationColumn:
+			entry.Station = bytes.TrimSpace(field)
+		case d.ValueColumn:
+			entry.Temperature = bytes.TrimSpace(field)
+		}
+		if d.HasTimestamp && col == d.TimestampColumn {
+			entry.Timestamp = bytes.TrimSpace(field)
+		}
+	}
+	if len(entry.Station) == 0 {
+		return models.LineSplit{}, false
+	}
+	return entry, true
+}
+
+// nextField returns the first field of line and the remainder after its delimiter.
+// The remainder is nil when the field was the last one on the line.
+func nextField(line []byte, d *models.Dialect) (field, rest []byte) {
+	if d.Quote == 0 || len(line) == 0 || line[0] != d.Quote {
+		i := bytes.IndexByte(line, d.Delimiter)
+		if i < 0 {
+			return line, nil
+		}
+		return line[:i], line[i+1:]
+	}
+
+	// Quoted field: a doubled quote inside the field stands for a literal quote.
+	var unescaped []byte
+	start := 1
+	for i := 1; i < len(line); i++ {
+		if line[i] != d.Quote {
+			continue
+		}
+		if i+1 < len(line) && line[i+1] == d.Quote {
+			unescaped = append(unescaped, line[start:i+1]...)
+			start = i + 2
+			i++
+			continue
+		}
+		if unescaped != nil {
+			field = append(unescaped, line[start:i]...)
+		} else {
+			field = line[start:i]
+		}
+		rest = line[i+1:]
+		if j := bytes.IndexByte(rest, d.Delimiter); j >= 0 {
+			return field, rest[j+1:]
+		}
+		return field, nil
+	}
+	// Unterminated quote: treat the remainder as the field.
+	return append(unescaped, line[start:]...), nil
+}
+
+// splitFields splits a whole line into its fields.
+func splitFields(line []byte, d *models.Dialect) [][]byte {
+	var fields [][]byte
+	for rest := line; rest != nil; {
+		var field []byte
+		field, rest = nextField(rest, d)
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// trimLine drops the carriage return left over from CRLF line endings.
+func trimLine(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		return line[:n-1]
+	}
+	return line
+}
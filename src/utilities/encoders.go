@@ -0,0 +1,212 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResultEncoder renders result rows in one output format.
+type ResultEncoder interface {
+	// ContentType is the media type sent with the encoded body.
+	ContentType() string
+	// Extension is the file extension used for downloads, without the dot.
+	Extension() string
+	// Encode writes the rows. Values are already rounded to opts.Decimals.
+	Encode(w io.Writer, rows []models.StationResult, opts models.ResultOptions) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]ResultEncoder{}
+)
+
+func init() {
+	RegisterEncoder("json", jsonEncoder{})
+	RegisterEncoder("ndjson", ndjsonEncoder{})
+	RegisterEncoder("csv", delimitedEncoder{sep: ',', contentType: "text/csv", ext: "csv"})
+	RegisterEncoder("tsv", delimitedEncoder{sep: '\t', contentType: "text/tab-separated-values", ext: "tsv"})
+	RegisterEncoder("text", textEncoder{})
+}
+
+// RegisterEncoder adds or replaces the encoder for a format name.
+func RegisterEncoder(name string, enc ResultEncoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[strings.ToLower(name)] = enc
+}
+
+// LookupEncoder returns the encoder registered for a format name.
+func LookupEncoder(name string) (ResultEncoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	enc, ok := encoders[strings.ToLower(name)]
+	return enc, ok
+}
+
+// NegotiateEncoder picks an encoder from an Accept header, honouring q-values.
+// It returns false when nothing but wildcards matched.
+func NegotiateEncoder(accept string) (string, ResultEncoder, bool) {
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	for _, c := range candidates {
+		for name, enc := range encoders {
+			if enc.ContentType() == c.mediaType {
+				return name, enc, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// formatNumber renders a rounded value with a fixed number of decimals.
+func formatNumber(v float64, decimals int) string {
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// formatStart renders a bucket start in RFC3339.
+func formatStart(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// hasBuckets reports whether any row belongs to a time bucket.
+func hasBuckets(rows []models.StationResult) bool {
+	return len(rows) > 0 && rows[0].Start != nil
+}
+
+// jsonEncoder writes the rows as a single JSON array.
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+func (jsonEncoder) Extension() string   { return "json" }
+
+func (jsonEncoder) Encode(w io.Writer, rows []models.StationResult, _ models.ResultOptions) error {
+	return json.NewEncoder(w).Encode(rows)
+}
+
+// ndjsonEncoder writes one JSON object per row.
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) ContentType() string { return "application/x-ndjson" }
+func (ndjsonEncoder) Extension() string   { return "ndjson" }
+
+func (ndjsonEncoder) Encode(w io.Writer, rows []models.StationResult, _ models.ResultOptions) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// delimitedEncoder writes CSV or TSV with a header row.
+type delimitedEncoder struct {
+	sep         byte
+	contentType string
+	ext         string
+}
+
+func (e delimitedEncoder) ContentType() string { return e.contentType }
+func (e delimitedEncoder) Extension() string   { return e.ext }
+
+func (e delimitedEncoder) Encode(w io.Writer, rows []models.StationResult, opts models.ResultOptions) error {
+	bw := bufio.NewWriter(w)
+	buckets := hasBuckets(rows)
+	columns := []string{"station", "mean", "min", "max", "count"}
+	if buckets {
+		columns = []string{"station", "bucket_start", "mean", "min", "max", "count"}
+	}
+	bw.WriteString(strings.Join(columns, string(e.sep)))
+	bw.WriteByte('\n')
+	for _, r := range rows {
+		bw.WriteString(e.quote(r.Station))
+		if buckets {
+			bw.WriteByte(e.sep)
+			bw.WriteString(formatStart(r.Start))
+		}
+		for _, v := range []float64{r.Mean, r.Min, r.Max} {
+			bw.WriteByte(e.sep)
+			bw.WriteString(formatNumber(v, opts.Decimals))
+		}
+		bw.WriteByte(e.sep)
+		bw.WriteString(strconv.FormatInt(r.Count, 10))
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// quote escapes a field that contains the separator, a quote or a line break.
+func (e delimitedEncoder) quote(field string) string {
+	if e.sep == '\t' {
+		// TSV has no quoting; replace the characters that would break the row.
+		return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(field)
+	}
+	if !strings.ContainsAny(field, string(e.sep)+"\"\r\n") {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// textEncoder writes the canonical 1BRC output: {station=min/mean/max, ...}.
+type textEncoder struct{}
+
+func (textEncoder) ContentType() string { return "text/plain" }
+func (textEncoder) Extension() string   { return "txt" }
+
+func (textEncoder) Encode(w io.Writer, rows []models.StationResult, opts models.ResultOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	for i, r := range rows {
+		if i > 0 {
+			bw.WriteString(", ")
+		}
+		bw.WriteString(r.Station)
+		if r.Start != nil {
+			bw.WriteByte('@')
+			bw.WriteString(formatStart(r.Start))
+		}
+		fmt.Fprintf(bw, "=%s/%s/%s",
+			formatNumber(r.Min, opts.Decimals),
+			formatNumber(r.Mean, opts.Decimals),
+			formatNumber(r.Max, opts.Decimals))
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
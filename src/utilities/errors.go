@@ -0,0 +1,21 @@
+package utilities
+
+import "errors"
+
+// ErrBadInput is matched by every error that the content of an input causes rather than
+// the server, such as ErrEmptyValue or ErrColumnarCorrupt. The HTTP, gRPC and cluster
+// transports answer it as a client error, so new input errors only need to match it.
+var ErrBadInput = errors.New("bad input")
+
+// BadInput returns a new sentinel error with message msg that also matches ErrBadInput.
+func BadInput(msg string) error {
+	return &badInputError{msg}
+}
+
+type badInputError struct {
+	msg string
+}
+
+func (e *badInputError) Error() string { return e.msg }
+
+func (e *badInputError) Is(target error) bool { return target == ErrBadInput }
@@ -1,106 +1,96 @@
 package utilities
 
 import (
-	"1brc-challange/models"
+	"context"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"os"
-	"sync"
 )
 
-// memoryThreshold is the threshold for determining whether to read the file in memory or stream it to disk.
-const memoryThreshold = 10 << 20 // 10MB
+// Upload is a multipart file prepared for decoding. Small files are read straight from the
+// multipart file; larger ones are spooled to a temporary file. Hash is the hex SHA-256 of the content.
+type Upload struct {
+	Hash string
+	Size int64
+	file multipart.File
+	temp *os.File
+	// observe is told the size of the spooled file, see SpoolUploadIn
+	observe func(bytes int64)
+}
 
-// SplitAndDecodeMultipartFileSmart splits and decodes a multipart file into parts, using memory or disk based on file size.
-// It returns a slice of maps containing the decoded results for each part.
-// If the file is small enough, it processes in memory; otherwise, it streams to a temporary file on disk.
-// It uses goroutines to decode each part concurrently.
-// The parts parameter specifies the number of parts to split the file into, typically the number of CPU cores available.
-func SplitAndDecodeMultipartFileSmart(
-	file multipart.File,
-	header *multipart.FileHeader,
-	parts int,
-) ([]map[string]models.TempStat, error) {
-	// Check if the file size is small enough to process in memory
-	if header.Size <= memoryThreshold {
-		// Decode the entire file in memory
-		workerResults := make([]map[string]models.TempStat, 1)
-		workerResults[0] = make(map[string]models.TempStat)
-		err := DecodeMultipartFilePart(file, workerResults[0])
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode multipart file part: %w", err)
-		}
-		defer file.Close()
-		return workerResults, nil
-	} else {
-		// Large file: stream to disk once
-		tempFile, err := streamToTempFile(file)
+// SpoolUploadIn hashes a multipart file and, when it is larger than Tuning.MemoryThreshold,
+// spools it to a temporary file in dir, or in the temp dir when dir is empty. observe, when
+// not nil, is called with the size of the spooled file and with minus that size once Close
+// removes it. The caller must Close the upload.
+func SpoolUploadIn(ctx context.Context, dir string, file multipart.File, header *multipart.FileHeader, observe func(bytes int64)) (*Upload, error) {
+	if header.Size <= tuning.MemoryThreshold {
+		hash, err := HashContent(file)
 		if err != nil {
-			return nil, nil
+			return nil, fmt.Errorf("failed to hash upload: %w", err)
 		}
-		// Split the file into parts
-		partsList, err := splitInDisk(tempFile, parts)
-		if err != nil {
-			tempFile.Close()
-			os.Remove(tempFile.Name())
-			return nil, nil
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
 		}
+		return &Upload{Hash: hash, Size: header.Size, file: file}, nil
+	}
+	tempFile, hash, err := streamToTempFile(ctx, dir, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool upload to disk: %w", err)
+	}
+	return newSpooledUpload(tempFile, hash, header.Size, file, observe), nil
+}
 
-		// Start decode workers
-		var wg sync.WaitGroup
-		workerResults := make([]map[string]models.TempStat, parts)
-		// Initialize worker results
-		fmt.Fprintf(os.Stderr, "🧵 Starting %d decode workers...\n", len(partsList))
-		for i, p := range partsList {
-			wg.Add(1)
-			workerResults[i] = make(map[string]models.TempStat)
-			go func(i int, p models.Part) {
-				defer wg.Done()
-				err := DecodePart(tempFile.Name(), p.Offset, p.Size, workerResults[i])
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Worker %d error: %v\n", i, err)
-				}
-			}(i, p)
-		}
-		wg.Wait()
-		defer os.Remove(tempFile.Name())
-		defer tempFile.Close()
-		return workerResults, nil
+// SpoolStream spools everything read from r to a temporary file in dir, or in the temp dir
+// when dir is empty, hashing it on the way. Unlike SpoolUploadIn the size is not known in
+// advance, so the content always goes to disk. observe is called like for SpoolUploadIn.
+// The caller must Close the upload.
+func SpoolStream(ctx context.Context, dir string, r io.Reader, observe func(bytes int64)) (*Upload, error) {
+	tempFile, hash, err := streamToTempFile(ctx, dir, r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := tempFile.Stat()
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return nil, err
 	}
+	return newSpooledUpload(tempFile, hash, info.Size(), nil, observe), nil
 }
 
-// WARNING : Currently not used, but can be used to decode a part of a multipart file in memory.
-// Parts is number of parts to split the file into. Usually this is the number of CPU cores available.
-func SplitMultipartFileSmart(file multipart.File, header *multipart.FileHeader, parts int) ([]models.Part, error) {
-	if header.Size <= memoryThreshold {
-		return splitInMemory(file, parts)
-	} else {
-		// Large file: stream to disk and use seek-based logic
-		tempFile, err := streamToTempFile(file)
-		if err != nil {
-			return nil, err
-		}
-		defer os.Remove(tempFile.Name())
-		defer tempFile.Close()
+func newSpooledUpload(temp *os.File, hash string, size int64, file multipart.File, observe func(bytes int64)) *Upload {
+	if observe == nil {
+		observe = func(int64) {}
+	}
+	observe(size)
+	return &Upload{Hash: hash, Size: size, file: file, temp: temp, observe: observe}
+}
 
-		return splitInDisk(tempFile, parts)
+// ReadAt reads the content of the upload, from the spooled file when there is one.
+func (u *Upload) ReadAt(p []byte, off int64) (int, error) {
+	if u.temp != nil {
+		return u.temp.ReadAt(p, off)
 	}
+	return u.file.ReadAt(p, off)
 }
 
-// WARNING : Currently not used, but can be used to decode a part of a multipart file in memory.
-// DecodeMultipartFileSmart processes a multipart.File with offset and size, using memory or disk based on file size.
-func DecodeMultipartFileSmart(file multipart.File, header *multipart.FileHeader, offset, size int64, result map[string]models.TempStat) error {
-	if header.Size <= memoryThreshold {
-		// Small file: decode in memory
-		return DecodeMultipartFilePart(file, result)
-	} else {
-		// Large file: stream to disk and use disk-based logic
-		tempFile, err := streamToTempFile(file)
-		if err != nil {
-			return err
-		}
-		defer os.Remove(tempFile.Name())
-		defer tempFile.Close()
-		return DecodePart(tempFile.Name(), offset, size, result)
+// Path returns the spooled temporary file, or "" for an upload decoded from memory.
+func (u *Upload) Path() string {
+	if u.temp == nil {
+		return ""
+	}
+	return u.temp.Name()
+}
+
+// Close removes the temporary file, if any.
+func (u *Upload) Close() error {
+	if u.temp == nil {
+		return nil
 	}
+	err := u.temp.Close()
+	os.Remove(u.temp.Name())
+	u.observe(-u.Size)
+	u.temp = nil
+	return err
 }
@@ -0,0 +1,238 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DefaultJSONFields matches objects such as {"station":"Hamburg","temp":12.0}.
+var DefaultJSONFields = models.JSONFields{
+	Station: "station",
+	Value:   "temp",
+}
+
+// ValidateJSONFields checks that the station and value fields are named and distinct.
+func ValidateJSONFields(f models.JSONFields) error {
+	if f.Station == "" || f.Value == "" {
+		return fmt.Errorf("station and value field names are required")
+	}
+	if f.Station == f.Value || (f.Timestamp != "" && (f.Timestamp == f.Station || f.Timestamp == f.Value)) {
+		return fmt.Errorf("JSON field names must differ")
+	}
+	return nil
+}
+
+// SplitJSONRecord extracts the configured fields from a single-line JSON object.
+// It is a hand-rolled scanner that only materialises the wanted fields; other values,
+// including nested objects and arrays, are skipped without being decoded.
+// A null or missing value yields an empty Temperature so the numeric policy applies.
+func SplitJSONRecord(line []byte, f *models.JSONFields) (models.LineSplit, bool) {
+	var entry models.LineSplit
+	p := skipSpace(line, 0)
+	if p >= len(line) || line[p] != '{' {
+		return entry, false
+	}
+	p = skipSpace(line, p+1)
+	if p < len(line) && line[p] == '}' {
+		return entry, false
+	}
+
+	for p < len(line) {
+		if line[p] != '"' {
+			return entry, false
+		}
+		key, next, escaped, ok := scanString(line, p)
+		if !ok {
+			return entry, false
+		}
+		if escaped {
+			key = unescapeString(key)
+		}
+		p = skipSpace(line, next)
+		if p >= len(line) || line[p] != ':' {
+			return entry, false
+		}
+		p = skipSpace(line, p+1)
+		if p >= len(line) {
+			return entry, false
+		}
+
+		var value []byte
+		switch line[p] {
+		case '"':
+			value, next, escaped, ok = scanString(line, p)
+			if !ok {
+				return entry, false
+			}
+			if escaped {
+				value = unescapeString(value)
+			}
+		case '{', '[':
+			next, ok = skipComposite(line, p)
+			if !ok {
+				return entry, false
+			}
+		default:
+			next = p
+			for next < len(line) && line[next] != ',' && line[next] != '}' && !isSpace(line[next]) {
+				next++
+			}
+			value = line[p:next]
+			if string(value) == "null" {
+				value = nil
+			}
+		}
+
+		switch string(key) {
+		case f.Station:
+			entry.Station = value
+		case f.Value:
+			entry.Temperature = value
+		case f.Timestamp:
+			if f.Timestamp != "" {
+				entry.Timestamp = value
+			}
+		}
+
+		p = skipSpace(line, next)
+		if p >= len(line) {
+			return entry, false
+		}
+		if line[p] == '}' {
+			break
+		}
+		if line[p] != ',' {
+			return entry, false
+		}
+		p = skipSpace(line, p+1)
+	}
+
+	if len(entry.Station) == 0 {
+		return models.LineSplit{}, false
+	}
+	return entry, true
+}
+
+// scanString returns the raw contents of the JSON string starting at line[p] == '"',
+// the index after its closing quote and whether it contains escape sequences.
+func scanString(line []byte, p int) (raw []byte, next int, escaped bool, ok bool) {
+	for i := p + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			return line[p+1 : i], i + 1, escaped, true
+		}
+	}
+	return nil, len(line), false, false
+}
+
+// skipComposite skips a nested object or array starting at line[p], honouring strings.
+func skipComposite(line []byte, p int) (int, bool) {
+	depth := 0
+	for i := p; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			_, next, _, ok := scanString(line, i)
+			if !ok {
+				return len(line), false
+			}
+			i = next - 1
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return len(line), false
+}
+
+// unescapeString decodes the escape sequences of a raw JSON string body.
+func unescapeString(raw []byte) []byte {
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' || i+1 >= len(raw) {
+			out = append(out, c)
+			continue
+		}
+		i++
+		switch raw[i] {
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'u':
+			r, size := decodeUnicodeEscape(raw[i-1:])
+			out = utf8.AppendRune(out, r)
+			i += size - 2
+		default: // '"', '\\', '/'
+			out = append(out, raw[i])
+		}
+	}
+	return out
+}
+
+// decodeUnicodeEscape decodes a \uXXXX sequence, combining surrogate pairs.
+// It returns the rune and the number of bytes consumed.
+func decodeUnicodeEscape(b []byte) (rune, int) {
+	r1, ok := parseHex4(b)
+	if !ok {
+		return utf8.RuneError, 2
+	}
+	if utf16.IsSurrogate(r1) {
+		if r2, ok := parseHex4(b[6:]); ok {
+			if r := utf16.DecodeRune(r1, r2); r != utf8.RuneError {
+				return r, 12
+			}
+		}
+		return utf8.RuneError, 6
+	}
+	return r1, 6
+}
+
+// parseHex4 parses the four hex digits of a `\uXXXX` escape at the start of b.
+func parseHex4(b []byte) (rune, bool) {
+	if len(b) < 6 || b[0] != '\\' || b[1] != 'u' {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[2:6] {
+		r <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			r |= rune(c - '0')
+		case c >= 'a' && c <= 'f':
+			r |= rune(c - 'a' + 10)
+		case c >= 'A' && c <= 'F':
+			r |= rune(c - 'A' + 10)
+		default:
+			return 0, false
+		}
+	}
+	return r, true
+}
+
+// skipSpace returns the index of the first non-whitespace byte at or after p.
+func skipSpace(line []byte, p int) int {
+	for p < len(line) && isSpace(line[p]) {
+		p++
+	}
+	return p
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,140 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	// ErrEmptyValue is returned by DecodeTemp for a blank temperature field.
+	ErrEmptyValue = BadInput("empty temperature value")
+	// ErrNaNValue is returned by DecodeTemp for a NaN temperature.
+	ErrNaNValue = BadInput("temperature is NaN")
+)
+
+// DecodeTemp decodes a byte slice representing a temperature value into a float32 in Celsius.
+// The canonical `-?\d+\.\d` form takes an allocation-free fast path. Anything else, such as
+// integers, extra decimals or exponent notation, falls back to strconv.ParseFloat.
+// An optional unit suffix (°C, C, °F, F, K) is normalised to Celsius.
+func DecodeTemp(tempBytes []byte) (float32, error) {
+	if temp, ok := decodeCanonicalTemp(tempBytes); ok {
+		return temp, nil
+	}
+	return decodeTempSlow(tempBytes)
+}
+
+// ParseTemp decodes a temperature and applies the empty and NaN policies.
+// It returns ok=false when the row should be dropped, and an error when the run should fail.
+func ParseTemp(tempBytes []byte, opts models.NumericOptions) (float32, bool, error) {
+	return parseTempCounted(tempBytes, opts, nil)
+}
+
+// parseTempCounted is ParseTemp that also counts a value it cannot decode in errs, if not nil.
+func parseTempCounted(tempBytes []byte, opts models.NumericOptions, errs *ParseErrors) (float32, bool, error) {
+	temp, err := DecodeTemp(tempBytes)
+	if err == nil {
+		return temp, true, nil
+	}
+	errs.addValue(err)
+	var policy models.ValuePolicy
+	switch {
+	case errors.Is(err, ErrEmptyValue):
+		policy = opts.Empty
+	case errors.Is(err, ErrNaNValue):
+		policy = opts.NaN
+	default:
+		return 0, false, nil
+	}
+	switch policy {
+	case models.PolicyZero:
+		return 0, true, nil
+	case models.PolicyFail:
+		return 0, false, err
+	default:
+		return 0, false, nil
+	}
+}
+
+// ValidateNumericOptions checks that both policies are known.
+func ValidateNumericOptions(opts models.NumericOptions) error {
+	for _, p := range []models.ValuePolicy{opts.Empty, opts.NaN} {
+		switch p {
+		case "", models.PolicySkip, models.PolicyZero, models.PolicyFail:
+		default:
+			return fmt.Errorf("unknown value policy %q", p)
+		}
+	}
+	return nil
+}
+
+// decodeCanonicalTemp parses the exact 1BRC form with a single decimal digit.
+func decodeCanonicalTemp(b []byte) (float32, bool) {
+	i := 0
+	negative := false
+	if len(b) > 0 && b[0] == '-' {
+		negative = true
+		i++
+	}
+	var tenths int32
+	digits := 0
+	for ; i < len(b) && b[i] >= '0' && b[i] <= '9'; i++ {
+		tenths = tenths*10 + int32(b[i]-'0')
+		digits++
+	}
+	if digits == 0 || digits > 6 || i+2 != len(b) || b[i] != '.' || b[i+1] < '0' || b[i+1] > '9' {
+		return 0, false
+	}
+	tenths = tenths*10 + int32(b[i+1]-'0')
+	if negative {
+		tenths = -tenths
+	}
+	return float32(tenths) / 10, true
+}
+
+// decodeTempSlow handles everything the fast path rejects.
+func decodeTempSlow(b []byte) (float32, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return 0, ErrEmptyValue
+	}
+	if bytes.EqualFold(b, []byte("nan")) {
+		return 0, ErrNaNValue
+	}
+
+	unit := byte('C')
+	switch {
+	case bytes.HasSuffix(b, []byte("°C")), bytes.HasSuffix(b, []byte("°F")):
+		unit = b[len(b)-1]
+		b = b[:len(b)-len("°C")]
+	case len(b) > 1 && (b[len(b)-1] == 'C' || b[len(b)-1] == 'F' || b[len(b)-1] == 'K'):
+		unit = b[len(b)-1]
+		b = b[:len(b)-1]
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return 0, ErrEmptyValue
+	}
+
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature format %q", b)
+	}
+	if math.IsNaN(v) {
+		return 0, ErrNaNValue
+	}
+	if math.IsInf(v, 0) {
+		return 0, fmt.Errorf("temperature out of range %q", b)
+	}
+
+	switch unit {
+	case 'F':
+		v = (v - 32) * 5 / 9
+	case 'K':
+		v -= 273.15
+	}
+	return float32(v), nil
+}
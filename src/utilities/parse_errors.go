@@ -0,0 +1,56 @@
+package utilities
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Categories of ParseErrors, indexing parseCategories.
+const (
+	errMalformed = iota // line without the configured columns or fields
+	errEmpty            // blank value, whatever the empty policy does with it
+	errNaN              // NaN value, whatever the NaN policy does with it
+	errInvalid          // value that is not a number
+	errTimestamp        // timestamp that does not parse
+)
+
+// parseCategories are the names ParseErrors.Counts reports the categories under.
+var parseCategories = [...]string{"malformed", "empty", "nan", "invalid_value", "timestamp"}
+
+// ParseErrors counts input that a run could not use, by category. It is safe for
+// concurrent use; a nil *ParseErrors counts nothing.
+type ParseErrors struct {
+	counts [len(parseCategories)]atomic.Int64
+}
+
+func (p *ParseErrors) add(i int) {
+	if p != nil {
+		p.counts[i].Add(1)
+	}
+}
+
+// addValue counts a DecodeTemp error.
+func (p *ParseErrors) addValue(err error) {
+	switch {
+	case errors.Is(err, ErrEmptyValue):
+		p.add(errEmpty)
+	case errors.Is(err, ErrNaNValue):
+		p.add(errNaN)
+	default:
+		p.add(errInvalid)
+	}
+}
+
+// Counts returns the non-zero counts keyed by category.
+func (p *ParseErrors) Counts() map[string]int64 {
+	counts := make(map[string]int64)
+	if p == nil {
+		return counts
+	}
+	for i, name := range parseCategories {
+		if n := p.counts[i].Load(); n > 0 {
+			counts[name] = n
+		}
+	}
+	return counts
+}
@@ -0,0 +1,481 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+// Partials binary format, version 1. All fixed-width integers are little endian.
+//
+//	file    = magic "1BRCPRT\x00" | version u16 | flags u16
+//	          bucketLen uvarint | bucket | partialCount uvarint | partial* | crc32 u32
+//	partial = stationCount uvarint | station*
+//	station = len uvarint | key | sum f32 | min f32 | max f32 | count u32 | sketch?
+//	sketch  = binCount uvarint | (binDelta varint | count uvarint)*
+//
+// Flag bit 0 marks that every station carries a sketch. Sketch bins are written in
+// ascending order, each as the difference to the previous bin (the first to 0). The
+// checksum covers everything before it. Keys are station names or bucket keys.
+const (
+	PartialsVersion = 1
+
+	partialsFlagSketches = 1 << 0
+	// partialsMaxBytes bounds how much of an untrusted partials upload is read.
+	partialsMaxBytes = 1 << 30
+)
+
+// PartialsEncoding selects how a partial set is serialized.
+type PartialsEncoding string
+
+const (
+	PartialsBinary PartialsEncoding = "binary"
+	PartialsJSON   PartialsEncoding = "json"
+)
+
+var (
+	partialsMagic = []byte("1BRCPRT\x00")
+
+	// ErrPartialsCorrupt is returned when a partials file fails a structural or checksum check.
+	ErrPartialsCorrupt = BadInput("corrupt partials file")
+	// ErrPartialsVersion is returned for a partials file written by an unknown format version.
+	ErrPartialsVersion = BadInput("unsupported partials version")
+	// ErrPartialsMismatch is returned when partials with different time buckets are merged.
+	ErrPartialsMismatch = BadInput("partials use different time buckets")
+)
+
+// EncodePartials writes a partial set in the given encoding.
+func EncodePartials(w io.Writer, set *models.PartialSet, encoding PartialsEncoding) error {
+	switch encoding {
+	case PartialsJSON:
+		return json.NewEncoder(w).Encode(set)
+	case PartialsBinary, "":
+		return encodePartialsBinary(w, set)
+	default:
+		return fmt.Errorf("unknown partials encoding %q", encoding)
+	}
+}
+
+func encodePartialsBinary(w io.Writer, set *models.PartialSet) error {
+	bw := bufio.NewWriterSize(w, 1<<16)
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+
+	var flags uint16
+	if hasSketches(set) {
+		flags |= partialsFlagSketches
+	}
+	buf := append([]byte(nil), partialsMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, PartialsVersion)
+	buf = binary.LittleEndian.AppendUint16(buf, flags)
+	buf = appendString(buf, set.Bucket)
+	buf = binary.AppendUvarint(buf, uint64(len(set.Partials)))
+	for _, p := range set.Partials {
+		buf = binary.AppendUvarint(buf, uint64(len(p.Stations)))
+		// Sorted keys keep the output deterministic
+		keys := make([]string, 0, len(p.Stations))
+		for key := range p.Stations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			stat := p.Stations[key]
+			buf = appendString(buf, key)
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(stat.Sum))
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(stat.Min))
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(stat.Max))
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(stat.Count))
+			if flags&partialsFlagSketches != 0 {
+				buf = appendSketch(buf, p.Sketches[key])
+			}
+		}
+		if _, err := out.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+	}
+	if _, err := out.Write(buf); err != nil {
+		return err
+	}
+	if _, err := bw.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// hasSketches reports whether every non-empty partial of the set carries sketches.
+func hasSketches(set *models.PartialSet) bool {
+	found := false
+	for _, p := range set.Partials {
+		if len(p.Stations) == 0 {
+			continue
+		}
+		if p.Sketches == nil {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendSketch(buf []byte, s *models.TempSketch) []byte {
+	if s == nil {
+		return binary.AppendUvarint(buf, 0)
+	}
+	bins := sketchBins(s)
+	buf = binary.AppendUvarint(buf, uint64(len(bins)))
+	var prev int32
+	for _, bin := range bins {
+		buf = binary.AppendVarint(buf, int64(bin)-int64(prev))
+		buf = binary.AppendUvarint(buf, s.Bins[bin])
+		prev = bin
+	}
+	return buf
+}
+
+// DecodePartials reads a partial set in either encoding; JSON is recognised by its leading
+// brace. Binary partials are decoded as they are read, so apart from a small read buffer
+// only the decoded set is held in memory.
+func DecodePartials(r io.Reader) (*models.PartialSet, error) {
+	lr := &io.LimitedReader{R: r, N: partialsMaxBytes + 1}
+	br := bufio.NewReaderSize(lr, 1<<16)
+	set, err := decodePartials(br)
+	if lr.N == 0 {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrPartialsCorrupt, partialsMaxBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if set.Version != PartialsVersion {
+		return nil, fmt.Errorf("%w: %d", ErrPartialsVersion, set.Version)
+	}
+	return set, nil
+}
+
+func decodePartials(br *bufio.Reader) (*models.PartialSet, error) {
+	// Leading whitespace may precede JSON; the binary magic starts with a digit
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: not a partials file", ErrPartialsCorrupt)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, _ := br.Peek(1); b[0] != '{' {
+		return decodePartialsBinary(br)
+	}
+	dec := json.NewDecoder(br)
+	set := &models.PartialSet{}
+	if err := dec.Decode(set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPartialsCorrupt, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data after the JSON document", ErrPartialsCorrupt)
+	}
+	return set, nil
+}
+
+// partialsMaxKey bounds the length of a station key in a partials file, so a corrupt
+// length cannot make the decoder allocate before the data runs out.
+const partialsMaxKey = 1 << 20
+
+// partialsReader consumes a binary partials file, checksumming what it consumes, and
+// remembers the first error.
+type partialsReader struct {
+	r     *bufio.Reader
+	crc   hash.Hash32
+	buf   []byte
+	one   [1]byte
+	err   error
+	ioErr error // failure of the underlying reader, passed on as is
+}
+
+func (r *partialsReader) fail(what string, err error) {
+	switch {
+	case r.err != nil:
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		r.err = fmt.Errorf("%w: truncated %s", ErrPartialsCorrupt, what)
+	case err == r.ioErr:
+		r.err = err
+	default:
+		r.err = fmt.Errorf("%w: %s: %v", ErrPartialsCorrupt, what, err)
+	}
+}
+
+// ReadByte lets the varint readers of encoding/binary consume the file through the checksum.
+func (r *partialsReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.one[0] = b
+		r.crc.Write(r.one[:])
+	} else if err != io.EOF {
+		r.ioErr = err
+	}
+	return b, err
+}
+
+// read consumes the next n bytes; the slice is only valid until the next call.
+func (r *partialsReader) read(what string, n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			r.ioErr = err
+		}
+		r.fail(what, err)
+		return nil
+	}
+	r.crc.Write(b)
+	return b
+}
+
+func (r *partialsReader) uvarint(what string) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		r.fail(what, err)
+		return 0
+	}
+	return v
+}
+
+func (r *partialsReader) varint(what string) int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r)
+	if err != nil {
+		r.fail(what, err)
+		return 0
+	}
+	return v
+}
+
+func (r *partialsReader) u32(what string) uint32 {
+	if b := r.read(what, 4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// count reads an element count. The file size is not known up front, so a count only
+// sizes the first allocation up to a bound and a false one fails once the data runs out.
+func (r *partialsReader) count(what string) (n uint64, hint int) {
+	n = r.uvarint(what)
+	return n, int(min(n, 1<<12))
+}
+
+func (r *partialsReader) string(what string) string {
+	n := r.uvarint(what)
+	if r.err == nil && n > partialsMaxKey {
+		r.err = fmt.Errorf("%w: %s of %d bytes", ErrPartialsCorrupt, what, n)
+	}
+	b := r.read(what, int(n))
+	if b == nil {
+		return ""
+	}
+	return string(b)
+}
+
+func decodePartialsBinary(br *bufio.Reader) (*models.PartialSet, error) {
+	r := &partialsReader{r: br, crc: crc32.NewIEEE()}
+	header := r.read("header", len(partialsMagic)+4)
+	if header == nil || !bytes.Equal(header[:len(partialsMagic)], partialsMagic) {
+		return nil, fmt.Errorf("%w: not a partials file", ErrPartialsCorrupt)
+	}
+	version := binary.LittleEndian.Uint16(header[len(partialsMagic):])
+	if version != PartialsVersion {
+		return nil, fmt.Errorf("%w: %d", ErrPartialsVersion, version)
+	}
+	flags := binary.LittleEndian.Uint16(header[len(partialsMagic)+2:])
+
+	set := &models.PartialSet{Version: int(version), Bucket: r.string("bucket")}
+	partials, hint := r.count("partial")
+	set.Partials = make([]models.Partial, 0, hint)
+	for i := uint64(0); i < partials && r.err == nil; i++ {
+		n, hint := r.count("station")
+		p := models.Partial{Stations: make(map[string]models.TempStat, hint)}
+		if flags&partialsFlagSketches != 0 {
+			p.Sketches = make(map[string]*models.TempSketch, hint)
+		}
+		for j := uint64(0); j < n && r.err == nil; j++ {
+			key := r.string("station key")
+			p.Stations[key] = models.TempStat{
+				Sum:   math.Float32frombits(r.u32("sum")),
+				Min:   math.Float32frombits(r.u32("min")),
+				Max:   math.Float32frombits(r.u32("max")),
+				Count: int32(r.u32("count")),
+			}
+			if p.Sketches != nil {
+				p.Sketches[key] = r.sketch()
+			}
+		}
+		set.Partials = append(set.Partials, p)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// The checksum follows the data it covers and must end the file
+	sum := r.crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(br, trailer[:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated checksum", ErrPartialsCorrupt)
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrPartialsCorrupt)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing bytes after the checksum", ErrPartialsCorrupt)
+	}
+	return set, nil
+}
+
+func (r *partialsReader) sketch() *models.TempSketch {
+	n, hint := r.count("sketch bin")
+	s := &models.TempSketch{Bins: make(map[int32]uint64, hint)}
+	var bin int64
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		bin += r.varint("sketch bin")
+		s.Bins[int32(bin)] += r.uvarint("sketch count")
+	}
+	return s
+}
+
+// MergePartials merges every partial of the given sets through MergeResults, and their
+// sketches when all partials carry them. It returns the shared time bucket of the sets.
+func MergePartials(sets []*models.PartialSet) (map[string]*models.TempStat, map[string]*models.TempSketch, string, error) {
+	var (
+		stats    []map[string]models.TempStat
+		sketches []map[string]*models.TempSketch
+		bucket   string
+	)
+	complete := true
+	for i, set := range sets {
+		if i > 0 && set.Bucket != bucket {
+			return nil, nil, "", fmt.Errorf("%w: %q and %q", ErrPartialsMismatch, bucket, set.Bucket)
+		}
+		bucket = set.Bucket
+		for _, p := range set.Partials {
+			stats = append(stats, p.Stations)
+			sketches = append(sketches, p.Sketches)
+			if p.Sketches == nil && len(p.Stations) > 0 {
+				complete = false
+			}
+		}
+	}
+	merged := MergeResults(stats)
+	// Quantiles over a subset of the rows would be misleading, so they need every sketch
+	if !complete || len(merged) == 0 {
+		return merged, nil, bucket, nil
+	}
+	return merged, MergeSketches(sketches), bucket, nil
+}
+
+// AddToSketch records one temperature in the sketch of key.
+func AddToSketch(sketches map[string]*models.TempSketch, key string, temp float32) {
+	s, ok := sketches[key]
+	if !ok {
+		s = &models.TempSketch{Bins: make(map[int32]uint64)}
+		sketches[key] = s
+	}
+	s.Bins[int32(math.Round(float64(temp)*10))]++
+}
+
+// MergeSketches adds up the sketches of several workers key by key.
+func MergeSketches(input []map[string]*models.TempSketch) map[string]*models.TempSketch {
+	final := make(map[string]*models.TempSketch)
+	for _, part := range input {
+		for key, s := range part {
+			if s == nil {
+				continue
+			}
+			existing, ok := final[key]
+			if !ok {
+				existing = &models.TempSketch{Bins: make(map[int32]uint64, len(s.Bins))}
+				final[key] = existing
+			}
+			for bin, n := range s.Bins {
+				existing.Bins[bin] += n
+			}
+		}
+	}
+	return final
+}
+
+// SketchQuantile returns the nearest-rank q-quantile (0 < q <= 1) of the sketch in degrees.
+func SketchQuantile(s *models.TempSketch, q float64) float64 {
+	if s == nil {
+		return 0
+	}
+	bins := sketchBins(s)
+	var total uint64
+	for _, bin := range bins {
+		total += s.Bins[bin]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for _, bin := range bins {
+		seen += s.Bins[bin]
+		if seen >= rank {
+			return float64(bin) / 10
+		}
+	}
+	return float64(bins[len(bins)-1]) / 10
+}
+
+// SketchQuantiles summarises each sketch as its median, 90th and 99th percentile.
+func SketchQuantiles(sketches map[string]*models.TempSketch) map[string]models.StationQuantiles {
+	out := make(map[string]models.StationQuantiles, len(sketches))
+	for key, s := range sketches {
+		out[key] = models.StationQuantiles{
+			P50: SketchQuantile(s, 0.5),
+			P90: SketchQuantile(s, 0.9),
+			P99: SketchQuantile(s, 0.99),
+		}
+	}
+	return out
+}
+
+func sketchBins(s *models.TempSketch) []int32 {
+	bins := make([]int32, 0, len(s.Bins))
+	for bin, n := range s.Bins {
+		if n > 0 {
+			bins = append(bins, bin)
+		}
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i] < bins[j] })
+	return bins
+}
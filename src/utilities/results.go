@@ -0,0 +1,142 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultResultOptions renders one decimal in ascending station order, like the 1BRC reference output.
+var DefaultResultOptions = models.ResultOptions{Decimals: 1, Sort: models.SortName}
+
+// BuildRows turns a merged result into the stations selected by opts, ordered by opts.Sort.
+// Mean, min and max are rounded half up to opts.Decimals. Ties are broken by station
+// name and bucket start so the order is stable across runs. Paging is left to PageRows.
+func BuildRows(stats map[string]*models.TempStat, opts models.ResultOptions) []models.StationResult {
+	loc := resultLocation(opts)
+	include := toSet(opts.Include)
+	exclude := toSet(opts.Exclude)
+
+	rows := make([]models.StationResult, 0, len(stats))
+	for key, stat := range stats {
+		if stat.Count == 0 {
+			continue
+		}
+		row := resultRow(key, stat, opts.Decimals, loc)
+		if !selectStation(row.Station, include, exclude, opts) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	value := sortValue(opts.Sort)
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := &rows[i], &rows[j]
+		if value != nil {
+			if va, vb := value(a), value(b); va != vb {
+				return (va < vb) != opts.Descending
+			}
+		} else if a.Station != b.Station {
+			return (a.Station < b.Station) != opts.Descending
+		}
+		if a.Station != b.Station {
+			return a.Station < b.Station
+		}
+		return a.Start != nil && b.Start != nil && a.Start.Before(*b.Start)
+	})
+	return rows
+}
+
+// resultRow renders one entry of a result map, splitting bucketed keys into station and start.
+func resultRow(key string, stat *models.TempStat, decimals int, loc *time.Location) models.StationResult {
+	row := models.StationResult{
+		Station: key,
+		Mean:    RoundHalfUp(float64(stat.Sum)/float64(stat.Count), decimals),
+		Min:     RoundHalfUp(float64(stat.Min), decimals),
+		Max:     RoundHalfUp(float64(stat.Max), decimals),
+		Count:   int64(stat.Count),
+	}
+	if station, start, ok := SplitBucketKey(key); ok {
+		start = start.In(loc)
+		row.Station = station
+		row.Start = &start
+	}
+	return row
+}
+
+func resultLocation(opts models.ResultOptions) *time.Location {
+	if opts.Location == nil {
+		return time.UTC
+	}
+	return opts.Location
+}
+
+// PageRows returns the window of rows selected by opts.Offset and opts.Limit.
+func PageRows(rows []models.StationResult, opts models.ResultOptions) []models.StationResult {
+	if opts.Offset >= len(rows) {
+		return rows[:0]
+	}
+	rows = rows[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(rows) {
+		rows = rows[:opts.Limit]
+	}
+	return rows
+}
+
+// ValidResultSort reports whether s names a sortable column.
+func ValidResultSort(s models.ResultSort) bool {
+	return s == models.SortName || sortValue(s) != nil
+}
+
+// sortValue returns the numeric key for a sort column, or nil when rows sort by name.
+func sortValue(s models.ResultSort) func(*models.StationResult) float64 {
+	switch s {
+	case models.SortMean:
+		return func(r *models.StationResult) float64 { return r.Mean }
+	case models.SortMin:
+		return func(r *models.StationResult) float64 { return r.Min }
+	case models.SortMax:
+		return func(r *models.StationResult) float64 { return r.Max }
+	case models.SortCount:
+		return func(r *models.StationResult) float64 { return float64(r.Count) }
+	case models.SortRange:
+		return func(r *models.StationResult) float64 { return r.Max - r.Min }
+	}
+	return nil
+}
+
+// selectStation applies the include, exclude, prefix and regex filters to a station name.
+func selectStation(station string, include, exclude map[string]struct{}, opts models.ResultOptions) bool {
+	if len(include) > 0 {
+		if _, ok := include[station]; !ok {
+			return false
+		}
+	}
+	if _, ok := exclude[station]; ok {
+		return false
+	}
+	if opts.Prefix != "" && !strings.HasPrefix(station, opts.Prefix) {
+		return false
+	}
+	return opts.Match == nil || opts.Match.MatchString(station)
+}
+
+func toSet(names []string) map[string]struct{} {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		set[n] = struct{}{}
+	}
+	return set
+}
+
+// RoundHalfUp rounds v to the given number of decimals, with ties rounded towards +Inf
+// as in the reference 1BRC implementation.
+func RoundHalfUp(v float64, decimals int) float64 {
+	p := math.Pow10(decimals)
+	return math.Floor(v*p+0.5) / p
+}
@@ -0,0 +1,400 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+)
+
+// ErrMemoryBudget is returned when the station table of a decode worker outgrows
+// Tuning.MemoryBudget and the run cannot spill it to disk.
+var ErrMemoryBudget = BadInput("memory budget exceeded")
+
+// Budget policies: what a decode worker does when its table outgrows the memory budget.
+const (
+	BudgetSpill = "spill" // write the table to disk as a sorted run and start a new one
+	BudgetFail  = "fail"  // fail the run
+)
+
+// tableEntryOverhead estimates the bytes a station takes in the table of a worker and in
+// its key cache, apart from the name itself. Measured on amd64, it varies with the growth
+// of the maps between about 100 and 170.
+const tableEntryOverhead = 128
+
+// spillRecordSize is the size of a TempStat in a run file: sum, min, max and count.
+const spillRecordSize = 16
+
+// spillFanIn is the number of runs merged at once. Merges of more runs go through passes
+// that merge groups of this many into intermediate runs, so a run never has more files open.
+const spillFanIn = 64
+
+// SpillSet collects the sorted runs that the decode workers of one run write when their
+// tables outgrow the memory budget, and merges them once the workers are done. It is safe
+// for concurrent use. A nil SpillSet cannot spill, so workers over budget fail.
+type SpillSet struct {
+	dir     string
+	observe func(bytes int64)
+	mu      sync.Mutex
+	runs    []spillRun
+	spilled int
+}
+
+// spillRun is one run file of a spill set.
+type spillRun struct {
+	path     string
+	size     int64
+	observed bool // a spilled table reported to observe; runs of merge passes are not
+}
+
+// NewSpillSet returns a spill set that writes its runs to dir. observe, when not nil, is
+// called with the size of every table spilled and with minus that size once it is removed.
+func NewSpillSet(dir string, observe func(bytes int64)) *SpillSet {
+	if observe == nil {
+		observe = func(int64) {}
+	}
+	return &SpillSet{dir: dir, observe: observe}
+}
+
+// Runs returns the number of tables spilled so far.
+func (s *SpillSet) Runs() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spilled
+}
+
+// Spill writes table to a new run file, sorted by station. An empty table writes nothing.
+func (s *SpillSet) Spill(table map[string]models.TempStat) error {
+	if len(table) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	w, err := createRun(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		w.write(k, table[k])
+	}
+	run, err := w.finish()
+	if err != nil {
+		return err
+	}
+	run.observed = true
+
+	s.mu.Lock()
+	s.runs = append(s.runs, run)
+	s.spilled++
+	s.mu.Unlock()
+	s.observe(run.size)
+	return nil
+}
+
+// Merge reads the runs in station order and calls fn once per station with its statistics
+// over every run. More than spillFanIn runs are first merged in passes into fewer, larger
+// runs; each pass closes its files before the next one starts.
+func (s *SpillSet) Merge(ctx context.Context, fn func(station string, stat models.TempStat) error) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.runs) > spillFanIn {
+		var next []spillRun
+		for i := 0; i < len(s.runs); i += spillFanIn {
+			group := s.runs[i:min(i+spillFanIn, len(s.runs))]
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			run, err := s.mergeRun(ctx, group)
+			if err != nil {
+				// Close still removes the runs not merged yet
+				s.runs = append(next, s.runs[i:]...)
+				return err
+			}
+			next = append(next, run)
+			if err := s.remove(group); err != nil {
+				s.runs = append(next, s.runs[i:]...)
+				return err
+			}
+		}
+		s.runs = next
+	}
+	return mergeRuns(ctx, s.runs, fn)
+}
+
+// mergeRun merges group into a new intermediate run.
+func (s *SpillSet) mergeRun(ctx context.Context, group []spillRun) (spillRun, error) {
+	w, err := createRun(s.dir)
+	if err != nil {
+		return spillRun{}, err
+	}
+	if err := mergeRuns(ctx, group, func(station string, stat models.TempStat) error {
+		w.write(station, stat)
+		return nil
+	}); err != nil {
+		w.abort()
+		return spillRun{}, err
+	}
+	return w.finish()
+}
+
+// remove deletes the files of runs that have been merged into another.
+func (s *SpillSet) remove(runs []spillRun) error {
+	var errs []error
+	for i := range runs {
+		if err := os.Remove(runs[i].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if runs[i].observed {
+			s.observe(-runs[i].size)
+			runs[i].observed = false
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MergeInto folds the merged runs into table. With a limit above 0 it fails with
+// ErrMemoryBudget once table takes more than about limit bytes, before it grows further.
+func (s *SpillSet) MergeInto(ctx context.Context, table map[string]models.TempStat, limit int64) error {
+	var size int64
+	for station := range table {
+		size += int64(len(station)) + tableEntryOverhead
+	}
+	return s.Merge(ctx, func(station string, stat models.TempStat) error {
+		if prev, ok := table[station]; ok {
+			stat = mergeStat(prev, stat)
+		} else {
+			size += int64(len(station)) + tableEntryOverhead
+			if limit > 0 && size > limit {
+				return fmt.Errorf("%w: the merged result reached %d stations in about %d bytes, over the budget of %d of the run",
+					ErrMemoryBudget, len(table)+1, size, limit)
+			}
+		}
+		table[station] = stat
+		return nil
+	})
+}
+
+// Close removes the run files.
+func (s *SpillSet) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.remove(s.runs)
+	s.runs = nil
+	return err
+}
+
+// overBudget handles a worker table that outgrew the memory budget with stations in it:
+// it spills the table and empties it, or fails the run.
+func (dec *Decoder) overBudget(table map[string]models.TempStat, sketches map[string]*models.TempSketch, size int64) error {
+	reason := ""
+	switch {
+	case dec.BudgetPolicy == BudgetFail:
+		reason = "the budget policy is fail"
+	case dec.Spills == nil:
+		reason = "this run cannot spill to disk"
+	case sketches != nil:
+		reason = "runs with sketches cannot spill to disk"
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: a decode worker holds %d stations in about %d bytes, over the budget of %d, and %s",
+			ErrMemoryBudget, len(table), size, dec.MemoryBudget, reason)
+	}
+	if err := dec.Spills.Spill(table); err != nil {
+		return err
+	}
+	clear(table)
+	return nil
+}
+
+func putStat(b []byte, stat models.TempStat) {
+	binary.LittleEndian.PutUint32(b[0:], math.Float32bits(stat.Sum))
+	binary.LittleEndian.PutUint32(b[4:], math.Float32bits(stat.Min))
+	binary.LittleEndian.PutUint32(b[8:], math.Float32bits(stat.Max))
+	binary.LittleEndian.PutUint32(b[12:], uint32(stat.Count))
+}
+
+func getStat(b []byte) models.TempStat {
+	return models.TempStat{
+		Sum:   math.Float32frombits(binary.LittleEndian.Uint32(b[0:])),
+		Min:   math.Float32frombits(binary.LittleEndian.Uint32(b[4:])),
+		Max:   math.Float32frombits(binary.LittleEndian.Uint32(b[8:])),
+		Count: int32(binary.LittleEndian.Uint32(b[12:])),
+	}
+}
+
+// mergeStat combines the statistics of one station from two tables.
+func mergeStat(a, b models.TempStat) models.TempStat {
+	a.Sum += b.Sum
+	a.Count += b.Count
+	a.Min = min(a.Min, b.Min)
+	a.Max = max(a.Max, b.Max)
+	return a
+}
+
+// runWriter writes the records of a new run file in station order.
+type runWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	rec [binary.MaxVarintLen64 + spillRecordSize]byte
+}
+
+func createRun(dir string) (*runWriter, error) {
+	f, err := os.CreateTemp(dir, "spill-*.run")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	return &runWriter{f: f, w: bufio.NewWriterSize(f, 1<<20)}, nil
+}
+
+// write appends a record; errors surface in finish.
+func (rw *runWriter) write(station string, stat models.TempStat) {
+	n := binary.PutUvarint(rw.rec[:], uint64(len(station)))
+	rw.w.Write(rw.rec[:n])
+	rw.w.WriteString(station)
+	putStat(rw.rec[:spillRecordSize], stat)
+	rw.w.Write(rw.rec[:spillRecordSize])
+}
+
+// finish flushes and closes the file, or removes it when writing failed.
+func (rw *runWriter) finish() (spillRun, error) {
+	err := rw.w.Flush()
+	size, _ := rw.f.Seek(0, io.SeekCurrent)
+	if cerr := rw.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(rw.f.Name())
+		return spillRun{}, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	return spillRun{path: rw.f.Name(), size: size}, nil
+}
+
+// abort closes and removes the file.
+func (rw *runWriter) abort() {
+	rw.f.Close()
+	os.Remove(rw.f.Name())
+}
+
+// mergeRuns opens runs, calls fn once per station in station order with its statistics
+// over every run, and closes them again.
+func mergeRuns(ctx context.Context, runs []spillRun, fn func(station string, stat models.TempStat) error) error {
+	var h runHeap
+	files := make([]*os.File, 0, len(runs))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, run := range runs {
+		f, err := os.Open(run.path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		r := &runReader{r: bufio.NewReaderSize(ContextReader{Ctx: ctx, R: f}, 1<<16)}
+		if ok, err := r.next(); err != nil {
+			return fmt.Errorf("%s: %w", run.path, err)
+		} else if ok {
+			h = append(h, r)
+		}
+	}
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		station, stat := h[0].station, h[0].stat
+		if err := h.advance(); err != nil {
+			return err
+		}
+		// Fold the same station of the other runs, which now sort it first
+		for len(h) > 0 && h[0].station == station {
+			stat = mergeStat(stat, h[0].stat)
+			if err := h.advance(); err != nil {
+				return err
+			}
+		}
+		if err := fn(station, stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runReader reads the records of one run file in order.
+type runReader struct {
+	r       *bufio.Reader
+	station string
+	stat    models.TempStat
+	rec     [spillRecordSize]byte
+}
+
+// next reads the following record, and reports false at the end of the run.
+func (rr *runReader) next() (bool, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, name); err != nil {
+		return false, err
+	}
+	if _, err := io.ReadFull(rr.r, rr.rec[:]); err != nil {
+		return false, err
+	}
+	rr.station, rr.stat = string(name), getStat(rr.rec[:])
+	return true, nil
+}
+
+// runHeap orders the run readers by their current station.
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].station < h[j].station }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// advance moves the first run to its next record and drops it at its end.
+func (h *runHeap) advance() error {
+	ok, err := (*h)[0].next()
+	if err != nil {
+		return err
+	}
+	if ok {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package utilities
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// tempPatterns match the temporary files uploads leave in the temp dir: spooled uploads,
+// columnar files being ingested, tables spilled over the memory budget, and the parts of
+// multipart forms net/http spools to disk.
+var tempPatterns = []string{"upload-*.tmp", "ingest-*.brc", "spill-*.run", "multipart-*"}
+
+// SweepTempFiles removes the upload files in dir that were last modified more than
+// olderThan ago. A process that dies mid-request leaves them behind; the age keeps the
+// files of other processes sharing dir safe. It returns how many files were removed.
+func SweepTempFiles(dir string, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	var errs []error
+	for _, pattern := range tempPatterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return removed, err
+		}
+		for _, path := range matches {
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+				continue
+			}
+			removed++
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// ContextReader stops a read loop once its context is done.
+type ContextReader struct {
+	Ctx context.Context
+	R   io.Reader
+}
+
+func (cr ContextReader) Read(p []byte) (int, error) {
+	if err := cr.Ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.R.Read(p)
+}
@@ -0,0 +1,144 @@
+package utilities
+
+import (
+	"1brc-challange/models"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bucketSeparator joins station and bucket start in the keys of a bucketed result map.
+// It is the ASCII unit separator, which never appears in station names.
+const bucketSeparator = "\x1f"
+
+// ParseTimestamp parses an RFC3339 or epoch timestamp according to the given format.
+func ParseTimestamp(b []byte, format string) (time.Time, error) {
+	if len(b) == 0 {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	switch format {
+	case models.TimeFormatRFC3339:
+		return time.Parse(time.RFC3339Nano, string(b))
+	case models.TimeFormatUnix:
+		return parseEpoch(b, time.Second)
+	case models.TimeFormatUnixMs:
+		return parseEpoch(b, time.Millisecond)
+	case "", models.TimeFormatAuto:
+		if isNumeric(b) {
+			t, err := parseEpoch(b, time.Second)
+			// Values beyond the year 5138 in seconds are taken as milliseconds.
+			if err == nil && math.Abs(float64(t.Unix())) >= 1e11 {
+				return parseEpoch(b, time.Millisecond)
+			}
+			return t, err
+		}
+		return time.Parse(time.RFC3339Nano, string(b))
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", format)
+}
+
+// parseEpoch parses an integer or fractional count of units since the Unix epoch.
+func parseEpoch(b []byte, unit time.Duration) (time.Time, error) {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		if unit == time.Second {
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return time.UnixMilli(n).UTC(), nil
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, fmt.Errorf("invalid epoch timestamp %q", b)
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+// isNumeric reports whether b looks like a plain, possibly fractional, number.
+func isNumeric(b []byte) bool {
+	for i, c := range b {
+		if (c < '0' || c > '9') && c != '.' && !(i == 0 && c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateTimeOptions checks the timestamp format and bucket width.
+// hasTimestamp tells whether the input is configured with a timestamp column or field.
+func ValidateTimeOptions(opts models.TimeOptions, hasTimestamp bool) error {
+	switch opts.Format {
+	case "", models.TimeFormatAuto, models.TimeFormatRFC3339, models.TimeFormatUnix, models.TimeFormatUnixMs:
+	default:
+		return fmt.Errorf("unknown time format %q", opts.Format)
+	}
+	if opts.Bucket < 0 || (opts.Bucket > 0 && opts.Bucket < time.Second) {
+		return fmt.Errorf("bucket width must be at least one second")
+	}
+	if opts.Bucket > 0 && !hasTimestamp {
+		return fmt.Errorf("time buckets require a timestamp column")
+	}
+	return nil
+}
+
+// BucketStart returns the start of the bucket containing t, aligned to wall-clock time in loc.
+// Buckets of exactly one day start at local midnight even across daylight saving changes.
+func BucketStart(t time.Time, width time.Duration, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	if width == 24*time.Hour {
+		y, m, d := local.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+	_, offset := local.Zone()
+	sec := t.Unix() + int64(offset)
+	w := int64(width / time.Second)
+	rem := sec % w
+	if rem < 0 {
+		rem += w
+	}
+	return time.Unix(sec-rem-int64(offset), 0).In(loc)
+}
+
+// BucketKey builds the result map key for a station within a bucket.
+func BucketKey(station string, start time.Time) string {
+	return station + bucketSeparator + strconv.FormatInt(start.Unix(), 10)
+}
+
+// SplitBucketKey reverses BucketKey.
+func SplitBucketKey(key string) (string, time.Time, bool) {
+	i := strings.LastIndex(key, bucketSeparator)
+	if i < 0 {
+		return key, time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(key[i+1:], 10, 64)
+	if err != nil {
+		return key, time.Time{}, false
+	}
+	return key[:i], time.Unix(sec, 0), true
+}
+
+// GroupBuckets turns a bucketed result map into a list ordered by station, then bucket start.
+func GroupBuckets(result map[string]*models.TempStat, loc *time.Location) []models.BucketStat {
+	if loc == nil {
+		loc = time.UTC
+	}
+	buckets := make([]models.BucketStat, 0, len(result))
+	for key, stat := range result {
+		station, start, ok := SplitBucketKey(key)
+		if !ok {
+			continue
+		}
+		buckets = append(buckets, models.BucketStat{Station: station, Start: start.In(loc), TempStat: *stat})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Station != buckets[j].Station {
+			return buckets[i].Station < buckets[j].Station
+		}
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
@@ -0,0 +1,56 @@
+package utilities
+
+import "fmt"
+
+// ErrLineTooLong is returned when a line of the input exceeds Tuning.MaxLineLength.
+var ErrLineTooLong = BadInput("line too long")
+
+// Tuning holds the buffer sizes and limits of the input pipeline. The defaults suit the
+// challenge input; Configure replaces them at startup, before any input is read.
+type Tuning struct {
+	MemoryThreshold int64 // uploads up to this size are read in memory, larger ones are spooled to disk
+	ReadBuffer      int   // bytes the decoders read at a time
+	LineBuffer      int   // bytes the line reader of anomaly detection reads at a time
+	ChannelBuffer   int   // lines and entries queued between the stages of anomaly detection
+	MaxLineLength   int   // longest accepted line in bytes, 0 accepts any length
+
+	// MemoryBudget bounds the bytes the station table of one decode worker may take; a
+	// table over it is spilled to disk or fails the run, as BudgetPolicy says. The merged
+	// result of spilled runs may take the budget of all workers together. 0 leaves the
+	// tables unbounded.
+	MemoryBudget int64
+	BudgetPolicy string // BudgetSpill or BudgetFail
+}
+
+// DefaultTuning returns the limits used when none are configured.
+func DefaultTuning() Tuning {
+	return Tuning{
+		MemoryThreshold: 10 << 20,
+		ReadBuffer:      1 << 20,
+		LineBuffer:      4 << 20,
+		ChannelBuffer:   10000,
+		MaxLineLength:   1 << 20,
+		MemoryBudget:    256 << 20,
+		BudgetPolicy:    BudgetSpill,
+	}
+}
+
+var tuning = DefaultTuning()
+
+// Configure replaces the limits of the input pipeline. It is not safe to call while inputs are read.
+func Configure(t Tuning) {
+	tuning = t
+}
+
+// CurrentTuning returns the limits in effect.
+func CurrentTuning() Tuning {
+	return tuning
+}
+
+// checkLineLength fails lines longer than the configured limit.
+func checkLineLength(n int) error {
+	if tuning.MaxLineLength > 0 && n > tuning.MaxLineLength {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrLineTooLong, n, tuning.MaxLineLength)
+	}
+	return nil
+}
@@ -4,13 +4,16 @@ import (
 	"1brc-challange/models"
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
 	"sort"
-	"strconv"
 	"sync"
+	"time"
 )
 
 // ReadFile reads a file line by line and sends each line to the provided channel.
@@ -57,16 +60,23 @@ func ReadFile(path string, out chan<- []byte) {
 
 // ReadMultipartFile reads a multipart.File line by line and sends each line to the provided channel.
 func ReadMultipartFile(file multipart.File, out chan<- []byte) {
+	if err := ReadLines(file, out); err != nil {
+		panic(err)
+	}
+}
+
+// ReadLines reads r line by line and sends each non-empty line to out, which it closes
+// when r is exhausted or fails. Lines longer than Tuning.MaxLineLength fail with ErrLineTooLong.
+func ReadLines(r io.Reader, out chan<- []byte) error {
 	defer close(out)
 
-	const bufSize = 4 * 1024 * 1024
-	buf := make([]byte, bufSize)
+	buf := make([]byte, tuning.LineBuffer)
 	var leftover []byte
 
 	for {
-		n, err := file.Read(buf)
+		n, err := r.Read(buf)
 		if err != nil && err != io.EOF {
-			panic(err)
+			return err
 		}
 		if n == 0 {
 			break
@@ -75,7 +85,13 @@ func ReadMultipartFile(file multipart.File, out chan<- []byte) {
 		chunk := append(leftover, buf[:n]...)
 		lines := bytes.Split(chunk, []byte{'\n'})
 		leftover = lines[len(lines)-1]
+		if err := checkLineLength(len(leftover)); err != nil {
+			return err
+		}
 		for _, line := range lines[:len(lines)-1] {
+			if err := checkLineLength(len(line)); err != nil {
+				return err
+			}
 			if len(line) > 0 {
 				out <- line
 			}
@@ -89,53 +105,31 @@ func ReadMultipartFile(file multipart.File, out chan<- []byte) {
 	if len(leftover) > 0 {
 		out <- leftover
 	}
+	return nil
 }
 
-// SplitLines splits lines from the input channel into station and temperature parts,
-func SplitLines(in <-chan []byte, out chan<- models.LineSplit) {
+// SplitLines splits lines from the input channel into station and temperature parts
+// in the decoder's format. The header row is dropped when the input has one.
+func SplitLines(in <-chan []byte, out chan<- models.LineSplit, dec *Decoder) {
 	defer close(out)
+	header := dec.HasHeader()
 	for line := range in {
-		parts := bytes.SplitN(line, []byte(";"), 2)
-		if len(parts) != 2 {
+		if header {
+			header = dec.IsPreamble(line)
+			continue
+		}
+		entry, ok := dec.Split(line)
+		if !ok {
+			dec.countMalformed(line)
 			continue
 		}
-		out <- models.LineSplit{Station: parts[0], Temperature: parts[1]}
+		out <- entry
 	}
 }
 
 // LineSplitter splits a line into station and temperature parts.
 func LineSplitter(line []byte) (models.LineSplit, bool) {
-	station, temp, isFound := bytes.Cut(line, []byte(";"))
-	if !isFound {
-		return models.LineSplit{}, false
-	}
-	return models.LineSplit{Station: station, Temperature: temp}, true
-}
-
-// DecodeTemp decodes a byte slice representing a temperature value into a float32.
-func DecodeTemp(tempBytes []byte) (float32, error) {
-	if len(tempBytes) < 3 {
-		return 0, fmt.Errorf("invalid temperature format")
-	}
-	var negative bool
-	i := 0
-	if tempBytes[i] == '-' {
-		negative = true
-		i++
-	}
-	var intPart int32
-	for ; i < len(tempBytes) && tempBytes[i] != '.'; i++ {
-		intPart = intPart*10 + int32(tempBytes[i]-'0')
-	}
-	if i+1 >= len(tempBytes) || tempBytes[i] != '.' {
-		return 0, fmt.Errorf("invalid decimal format")
-	}
-	fracPart := int32(tempBytes[i+1] - '0')
-	temp := float32(intPart) + float32(fracPart)/10
-	if negative {
-		temp = -temp
-	}
-	return temp, nil
+	return SplitRecord(line, &DefaultDialect)
 }
 
 // MergeResults merges multiple maps of TempStat into a single map.
@@ -164,238 +158,12 @@ func MergeResults(input []map[string]models.TempStat) map[string]*models.TempSta
 
 // DecodePart reads a part of the file and decodes temperature data into a map of TempStat.
 func DecodePart(path string, offset, size int64, result map[string]models.TempStat) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	_, err = f.Seek(offset, io.SeekStart)
-	if err != nil {
-		return err
-	}
-	limit := io.LimitReader(f, size)
-
-	const bufSize = 1024 * 1024
-	buf := make([]byte, bufSize)
-	var leftover []byte
-
-	stationCache := make(map[string]string)
-
-	for {
-		n, err := limit.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if n == 0 {
-			break
-		}
-
-		chunk := append(leftover, buf[:n]...)
-		lines := bytes.Split(chunk, []byte{'\n'})
-		leftover = lines[len(lines)-1]
-
-		for _, line := range lines[:len(lines)-1] {
-			entry, ok := LineSplitter(line)
-			if !ok {
-				continue
-			}
-
-			raw := entry.Station
-			key := string(raw)
-
-			station, ok := stationCache[key]
-			if !ok {
-				copied := make([]byte, len(raw))
-				copy(copied, raw)
-				station = string(copied)
-				stationCache[key] = station
-			}
-
-			temp, err := DecodeTemp(entry.Temperature)
-			if err != nil {
-				continue
-			}
-
-			stat, exists := result[station]
-			if !exists {
-				result[station] = models.TempStat{
-					Sum:   temp,
-					Min:   temp,
-					Max:   temp,
-					Count: 1,
-				}
-			} else {
-				stat.Sum += temp
-				stat.Count++
-				if temp > stat.Max {
-					stat.Max = temp
-				}
-				if temp < stat.Min {
-					stat.Min = temp
-				}
-				result[station] = stat
-			}
-		}
-
-		if err == io.EOF {
-			break
-		}
-	}
-
-	// process leftover
-	if len(leftover) > 0 {
-		entry, ok := LineSplitter(leftover)
-		if ok {
-			raw := entry.Station
-			key := string(raw)
-
-			station, ok := stationCache[key]
-			if !ok {
-				copied := make([]byte, len(raw))
-				copy(copied, raw)
-				station = string(copied)
-				stationCache[key] = station
-			}
-
-			temp, err := DecodeTemp(entry.Temperature)
-			if err == nil {
-				stat, exists := result[station]
-				if !exists {
-					result[station] = models.TempStat{
-						Sum:   temp,
-						Min:   temp,
-						Max:   temp,
-						Count: 1,
-					}
-				} else {
-					stat.Sum += temp
-					stat.Count++
-					if temp > stat.Max {
-						stat.Max = temp
-					}
-					if temp < stat.Min {
-						stat.Min = temp
-					}
-					result[station] = stat
-				}
-			}
-		}
-	}
-
-	return nil
+	return NewDecoder(models.ProcessOptions{Dialect: DefaultDialect}).DecodePart(path, offset, size, result)
 }
 
 // DecodeMultipartFilePart reads a multipart.File and decodes temperature data into a map of TempStat.
 func DecodeMultipartFilePart(file multipart.File, result map[string]models.TempStat) error {
-	const bufSize = 1024 * 1024
-	buf := make([]byte, bufSize)
-	var leftover []byte
-
-	stationCache := make(map[string]string)
-
-	for {
-		n, err := file.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if n == 0 {
-			break
-		}
-
-		chunk := append(leftover, buf[:n]...)
-		lines := bytes.Split(chunk, []byte{'\n'})
-		leftover = lines[len(lines)-1]
-
-		for _, line := range lines[:len(lines)-1] {
-			entry, ok := LineSplitter(line)
-			if !ok {
-				continue
-			}
-
-			raw := entry.Station
-			key := string(raw)
-
-			station, ok := stationCache[key]
-			if !ok {
-				copied := make([]byte, len(raw))
-				copy(copied, raw)
-				station = string(copied)
-				stationCache[key] = station
-			}
-
-			temp, err := DecodeTemp(entry.Temperature)
-			if err != nil {
-				continue
-			}
-
-			stat, exists := result[station]
-			if !exists {
-				result[station] = models.TempStat{
-					Sum:   temp,
-					Min:   temp,
-					Max:   temp,
-					Count: 1,
-				}
-			} else {
-				stat.Sum += temp
-				stat.Count++
-				if temp > stat.Max {
-					stat.Max = temp
-				}
-				if temp < stat.Min {
-					stat.Min = temp
-				}
-				result[station] = stat
-			}
-		}
-
-		if err == io.EOF {
-			break
-		}
-	}
-
-	// process leftover
-	if len(leftover) > 0 {
-		entry, ok := LineSplitter(leftover)
-		if ok {
-			raw := entry.Station
-			key := string(raw)
-
-			station, ok := stationCache[key]
-			if !ok {
-				copied := make([]byte, len(raw))
-				copy(copied, raw)
-				station = string(copied)
-				stationCache[key] = station
-			}
-
-			temp, err := DecodeTemp(entry.Temperature)
-			if err == nil {
-				stat, exists := result[station]
-				if !exists {
-					result[station] = models.TempStat{
-						Sum:   temp,
-						Min:   temp,
-						Max:   temp,
-						Count: 1,
-					}
-				} else {
-					stat.Sum += temp
-					stat.Count++
-					if temp > stat.Max {
-						stat.Max = temp
-					}
-					if temp < stat.Min {
-						stat.Min = temp
-					}
-					result[station] = stat
-				}
-			}
-		}
-	}
-
-	return nil
+	return NewDecoder(models.ProcessOptions{Dialect: DefaultDialect}).DecodeReader(file, result)
 }
 
 // Parts is number of parts to split the file into. Usually this is the number of CPU cores available.
@@ -408,117 +176,103 @@ func SplitFile(path string, parts int) ([]models.Part, error) {
 	return splitInDisk(f, parts)
 }
 
-// splitInMemory splits a file in memory into parts based on line offsets.
-func splitInMemory(file multipart.File, parts int) ([]models.Part, error) {
-	var buf bytes.Buffer
-	offsets := make([]int64, 0)
-
-	scanner := bufio.NewScanner(io.TeeReader(file, &buf))
-	var currentOffset int64
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		lineLen := int64(len(line)) + 1
-		offsets = append(offsets, currentOffset)
-		currentOffset += lineLen
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	total := int64(buf.Len())
-	return splitOffsets(offsets, total, parts), nil
-}
-
 // splitInDisk splits a file on disk into parts based on line offsets
 func splitInDisk(f *os.File, parts int) ([]models.Part, error) {
-	const maxLineLength = 100
 	info, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
-	size := info.Size()
-	chunk := size / int64(parts)
+	return SplitReaderAt(f, info.Size(), parts)
+}
 
-	buf := make([]byte, maxLineLength)
+// SplitReaderAt splits the first size bytes of r into at most parts ranges that end at
+// line boundaries. Ranges are about size/parts long; an input with fewer lines than parts
+// yields fewer ranges.
+func SplitReaderAt(r io.ReaderAt, size int64, parts int) ([]models.Part, error) {
+	if parts < 1 {
+		parts = 1
+	}
+	chunk := size / int64(parts)
+	buf := make([]byte, 4096)
 	result := make([]models.Part, 0, parts)
 
 	var offset int64
-	for i := 0; i < parts; i++ {
-		if i == parts-1 {
-			result = append(result, models.Part{
-				Offset: offset,
-				Size:   size - offset})
-			break
-		}
-		seek := offset + chunk
-		if seek > size {
-			seek = size
-		}
-		_, err := f.Seek(seek, io.SeekStart)
+	for i := 0; i < parts-1 && offset < size; i++ {
+		cut, err := nextLineStart(r, offset+chunk, size, buf)
 		if err != nil {
 			return nil, err
 		}
-		n, _ := f.Read(buf)
-		pos := bytes.IndexByte(buf[:n], '\n')
-		if pos < 0 {
-			return nil, fmt.Errorf("could not find newline at part %d", i)
+		if cut >= size {
+			break
 		}
-		cut := seek + int64(pos) + 1
-		result = append(result, models.Part{
-			Offset: offset,
-			Size:   cut - offset})
+		result = append(result, models.Part{Offset: offset, Size: cut - offset})
 		offset = cut
 	}
+	if offset < size || len(result) == 0 {
+		result = append(result, models.Part{Offset: offset, Size: size - offset})
+	}
 	return result, nil
 }
 
-// streamToTempFile streams a multipart.File to a temporary file and returns the file handle.
-func streamToTempFile(file multipart.File) (*os.File, error) {
-	tmp, err := os.CreateTemp("", "upload-*.tmp")
+// nextLineStart returns the offset after the first newline at or after from, or size when
+// the input ends first.
+func nextLineStart(r io.ReaderAt, from, size int64, buf []byte) (int64, error) {
+	for from < size {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-from)], from)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return from + int64(i) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		from += int64(n)
+	}
+	return size, nil
+}
+
+// streamToTempFile streams a reader to a temporary file in dir, or in the temp dir
+// when dir is empty, and returns the file handle
+// together with the hex SHA-256 of the content, computed while copying. Copying stops when
+// ctx is done.
+func streamToTempFile(ctx context.Context, dir string, file io.Reader) (*os.File, string, error) {
+	tmp, err := os.CreateTemp(dir, "upload-*.tmp")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	if _, err := io.Copy(tmp, file); err != nil {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), ContextReader{ctx, file}); err != nil {
 		tmp.Close()
-		return nil, err
+		os.Remove(tmp.Name())
+		return nil, "", err
 	}
-	_, err = tmp.Seek(0, io.SeekStart)
-	return tmp, err
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	return tmp, hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// splitOffsets splits the offsets into parts based on the total size and number of parts.
-func splitOffsets(offsets []int64, total int64, parts int) []models.Part {
-	if parts <= 0 {
-		return nil
+// HashContent returns the hex SHA-256 of everything read from r.
+func HashContent(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
 	}
-	result := make([]models.Part, 0, parts)
-	lines := len(offsets)
-	linesPerPart := lines / parts
-
-	for i := 0; i < parts; i++ {
-		start := i * linesPerPart
-		end := start + linesPerPart
-		if i == parts-1 {
-			end = lines
-		}
-		if start >= lines {
-			break
-		}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-		startOffset := offsets[start]
-		var endOffset int64
-		if end >= lines {
-			endOffset = total
-		} else {
-			endOffset = offsets[end]
-		}
-		result = append(result, models.Part{
-			Offset: startOffset,
-			Size:   endOffset - startOffset,
-		})
-	}
-	return result
+// DefaultAnomalyRules flags readings outside [-50, 60] °C and jumps of more than 20 °C.
+var DefaultAnomalyRules = models.AnomalyRules{
+	ExtremeMin: -50,
+	ExtremeMax: 60,
+	SpikeDelta: 20,
 }
 
+// DetectAnomalies applies the default rules to the entries of in and sends anomalies to out.
 func DetectAnomalies(
 	in <-chan models.LineSplit,
 	out chan<- models.Anomaly,
@@ -527,41 +281,94 @@ func DetectAnomalies(
 	totalAnomalies *int32,
 	spikeCount *int32,
 ) {
+	opts := models.ProcessOptions{Rules: DefaultAnomalyRules}
+	_ = DetectAnomaliesWithRules(in, out, lastTemps, nil, mu, totalAnomalies, spikeCount, nil, opts)
+}
+
+// DetectAnomaliesWithRules applies opts.Rules to the entries of in and sends anomalies to out.
+// lastTimes may be nil when the entries carry no timestamps. Entries of one station must
+// arrive in order for the spike rule to be meaningful.
+// When the numeric policy fails a row, the remaining entries are drained and the error is returned.
+// Values and timestamps that do not parse are counted in parseErrors, which may be nil.
+func DetectAnomaliesWithRules(
+	in <-chan models.LineSplit,
+	out chan<- models.Anomaly,
+	lastTemps map[string]float32,
+	lastTimes map[string]time.Time,
+	mu *sync.Mutex,
+	totalAnomalies *int32,
+	spikeCount *int32,
+	parseErrors *ParseErrors,
+	opts models.ProcessOptions,
+) error {
+	rules := opts.Rules
+	var failed error
 	for entry := range in {
-		temp, err := strconv.ParseFloat(string(entry.Temperature), 32)
+		if failed != nil {
+			continue
+		}
+		t, ok, err := parseTempCounted(entry.Temperature, opts.Numeric, parseErrors)
 		if err != nil {
+			failed = fmt.Errorf("station %q: %w", entry.Station, err)
+			continue
+		}
+		if !ok {
 			continue
 		}
-		t := float32(temp)
 		station := string(entry.Station)
 
+		var ts time.Time
+		if lastTimes != nil && len(entry.Timestamp) > 0 {
+			if ts, err = ParseTimestamp(entry.Timestamp, opts.Time.Format); err != nil {
+				parseErrors.add(errTimestamp)
+			}
+		}
+
 		isAnomaly := false
 		reason := ""
 
 		// Rule 1: extreme temperature
-		if t < -50 || t > 60 {
+		if t < rules.ExtremeMin || t > rules.ExtremeMax {
 			isAnomaly = true
 			reason = "extreme"
 		}
 
-		// Rule 2: sudden spike (Δ > 20°C)
+		// Rule 2: sudden spike, either per reading or per minute of elapsed time
 		mu.Lock()
 		prev, exists := lastTemps[station]
-		if exists && !isAnomaly && abs(t-prev) > 20.0 {
+		if exists && !isAnomaly && isSpike(t-prev, ts, lastTimes[station], rules) {
 			isAnomaly = true
 			reason = "spike"
 			*spikeCount++
 		}
 		lastTemps[station] = t
+		if !ts.IsZero() {
+			lastTimes[station] = ts
+		}
 		if isAnomaly {
 			*totalAnomalies++
 		}
 		mu.Unlock()
 
 		if isAnomaly {
-			out <- models.Anomaly{Station: station, Temp: t, Reason: reason}
+			anomaly := models.Anomaly{Station: station, Temp: t, Reason: reason}
+			if !ts.IsZero() {
+				anomaly.Time = &ts
+			}
+			out <- anomaly
 		}
 	}
+	return failed
+}
+
+// isSpike evaluates the spike rule. The rate rule is used when it is configured and both
+// readings have increasing timestamps; otherwise the plain delta rule applies.
+func isSpike(delta float32, now, prev time.Time, rules models.AnomalyRules) bool {
+	if rules.SpikeRate > 0 && !now.IsZero() && !prev.IsZero() && now.After(prev) {
+		minutes := float32(now.Sub(prev).Minutes())
+		return abs(delta)/minutes > rules.SpikeRate
+	}
+	return rules.SpikeDelta > 0 && abs(delta) > rules.SpikeDelta
 }
 
 // WriteCSV writes the aggregated temperature statistics to a CSV file.
@@ -627,3 +434,29 @@ func abs(f float32) float32 {
 	}
 	return f
 }
+
+// ShardSplits routes every entry of in to one of the out channels by a hash of its station,
+// so all readings of a station are handled in order by the same worker. It closes every out channel
+// and, when rows is not nil, stores the number of routed entries there first.
+func ShardSplits(in <-chan models.LineSplit, out []chan models.LineSplit, rows *int64) {
+	var n int64
+	defer func() {
+		// Written before the shards close, so readers may use it once the detectors are done
+		if rows != nil {
+			*rows = n
+		}
+		for _, ch := range out {
+			close(ch)
+		}
+	}()
+	for entry := range in {
+		n++
+		// FNV-1a over the station name
+		h := uint32(2166136261)
+		for _, c := range entry.Station {
+			h ^= uint32(c)
+			h *= 16777619
+		}
+		out[h%uint32(len(out))] <- entry
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"1brc-challange/cluster"
+	"1brc-challange/config"
+	"1brc-challange/delivery"
+	http_delivery "1brc-challange/delivery/http"
+	"1brc-challange/tracing"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+// runWorker implements the `worker` subcommand, which serves decode requests for a
+// coordinator and keeps itself registered with heartbeats.
+func runWorker(args []string, cfg config.Config) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	coordinator := fs.String("coordinator", "", "base URL of the coordinator, e.g. http://localhost:8080")
+	listen := fs.String("listen", ":9001", "address the worker listens on")
+	advertise := fs.String("advertise", "", "base URL the coordinator reaches this worker at (default http://127.0.0.1:<listen port>)")
+	slots := fs.Int("slots", runtime.NumCPU(), "ranges decoded at the same time")
+	sharedDir := fs.String("shared-dir", cfg.Cluster.SharedDir, "dedicated directory the coordinator may point at by path, empty accepts only range bytes ($CLUSTER_SHARED_DIR)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coordinator == "" {
+		fs.Usage()
+		return fmt.Errorf("-coordinator is required")
+	}
+	if cfg.Cluster.Token == "" {
+		return fmt.Errorf("cluster.token is required to join a coordinator ($CLUSTER_TOKEN)")
+	}
+	// The temp dir holds the uploads and spilled tables of other requests
+	if *sharedDir != "" && config.SameDir(*sharedDir, os.TempDir()) {
+		return fmt.Errorf("-shared-dir: must be a dedicated directory, not the temp dir")
+	}
+	if *advertise == "" {
+		_, port, err := net.SplitHostPort(*listen)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		*advertise = "http://127.0.0.1:" + port
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Config("1brc-worker"))
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	router := delivery.RouteConfig{
+		Router:        newRouter(),
+		WorkerHandler: &http_delivery.WorkerHandler{SharedDir: *sharedDir, Token: cfg.Cluster.Token},
+	}
+	router.SetupWorkerRoutes()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The listener is open before registering, so the first range cannot arrive too early
+	agentDone := make(chan struct{})
+	go func() {
+		defer close(agentDone)
+		cluster.RunAgent(ctx, cluster.AgentConfig{
+			Coordinator:  *coordinator,
+			Token:        cfg.Cluster.Token,
+			Registration: cluster.Registration{Addr: *advertise, Slots: *slots},
+		})
+	}()
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	srv := newServer(*listen, router.Router, jobs)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		// Leave the cluster first so no new ranges arrive, then finish the ranges in flight
+		<-agentDone
+		drain([]*http.Server{srv}, nil, cfg.Server.ShutdownTimeout, cancelJobs)
+		return nil
+	}
+}